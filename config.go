@@ -16,8 +16,66 @@ type TenseiConfig struct {
 		OwnerID string `toml:"owner_id"`
 	} `toml:"discord"`
 	Twitch struct {
-		ClientID string `toml:"client_id"`
+		ClientID     string `toml:"client_id"`
+		ClientSecret string `toml:"client_secret"`
+		// TokenURL overrides the twitch oauth token endpoint
+		TokenURL string `toml:"token_url"`
+		// APIURL overrides the helix api
+		APIURL string `toml:"api_url"`
+		// EventSub webhooks are disabled when Listen is empty
+		EventSub struct {
+			Listen   string `toml:"listen"`
+			Callback string `toml:"callback"`
+			Secret   string `toml:"secret"`
+		} `toml:"eventsub"`
+		// Chat connects anonymously when Token is empty, Address defaults to twitch
+		Chat struct {
+			Address string `toml:"address"`
+			TLS     bool   `toml:"tls"`
+			Nick    string `toml:"nick"`
+			Token   string `toml:"token"`
+		} `toml:"chat"`
 	} `toml:"twitch"`
+	YouTube struct {
+		// APIURL overrides the youtube data api, the key is google.api_key
+		APIURL string `toml:"api_url"`
+		// WebSub push notifications are disabled when Listen is empty
+		WebSub struct {
+			Listen   string `toml:"listen"`
+			Callback string `toml:"callback"`
+			Secret   string `toml:"secret"`
+			// HubURL overrides the google pubsubhubbub hub
+			HubURL string `toml:"hub_url"`
+		} `toml:"websub"`
+	} `toml:"youtube"`
+	Translate struct {
+		// Order translators are tried in, empty is google, deepl, libretranslate
+		Order []string `toml:"order"`
+		// MonthlyCharacters each guild can translate per month, 0 is unlimited
+		MonthlyCharacters int `toml:"monthly_characters"`
+		// Cache keeps translations in memory and optionally in the database
+		Cache struct {
+			// Size translations kept in memory, 0 is 1000
+			Size int `toml:"size"`
+			// TTL like 24h, empty is 24h
+			TTL      string `toml:"ttl"`
+			Database bool   `toml:"database"`
+		} `toml:"cache"`
+		// DeepL is disabled without APIKey, APIURL defaults to the free or pro api by key
+		DeepL struct {
+			APIKey string `toml:"api_key"`
+			APIURL string `toml:"api_url"`
+		} `toml:"deepl"`
+		// LibreTranslate is disabled without URL
+		LibreTranslate struct {
+			URL    string `toml:"url"`
+			APIKey string `toml:"api_key"`
+		} `toml:"libretranslate"`
+	} `toml:"translate"`
+	Feeds struct {
+		// Interval between polls of every feed like 10m
+		Interval string `toml:"interval"`
+	} `toml:"feeds"`
 	Database struct {
 		Dialect          string `toml:"dialect"`
 		ConnectionString string `toml:"connection_string"`
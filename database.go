@@ -25,6 +25,17 @@ type Guild struct {
 	OwnerID   string
 
 	AdminRoleID string
+	// Prefix for commands, empty uses the prefix from the config
+	Prefix string
+	// Timezone IANA name for dates in alerts, empty is UTC
+	Timezone string
+	// DateFormat go time layout or dateFormatDiscord, empty is RFC822
+	DateFormat string
+
+	// Translator tried first for translations, empty uses the configured order
+	Translator string
+	// FlagTranslate translates messages members react to with a country flag
+	FlagTranslate bool
 
 	TranslateCooldown *int64 `gorm:"default:3"`
 	TwitchCooldown    *int64 `gorm:"default:3"`
@@ -40,10 +51,78 @@ type TwitchStreamer struct {
 	ChannelID       string
 	ProfileImageURL string
 
+	LiveState
+
+	TwitchAlertSubscriptions []*TwitchAlertSubscription
+
+	// Session of the running stream, nil while offline
+	Session *TwitchStreamSession `gorm:"-"`
+	// lastStream session and media of the stream that just ended for the end embeds
+	lastStream *endedStream `gorm:"-"`
+}
+
+// LiveState when the last stream of a tracked channel started and ended, embedded by the channels of every LiveSource
+type LiveState struct {
 	StreamStartTime time.Time
 	StreamEndTime   time.Time
 
-	TwitchAlertSubscriptions []*TwitchAlertSubscription
+	// job is set while a job of the channel runs, the other jobs skip the channel
+	job bool
+}
+
+// TwitchStreamSession stores the history of a single stream
+type TwitchStreamSession struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	TwitchStreamerID uint `gorm:"index"`
+	StreamID         string
+
+	StartTime time.Time
+	// EndTime is zero while the stream is running
+	EndTime time.Time
+
+	// Titles and Categories newline separated in the order they were first seen
+	Titles     string `gorm:"type:text"`
+	Categories string `gorm:"type:text"`
+
+	// Title and GameID of the last poll to detect changes
+	Title  string
+	GameID string
+	// Changes timeline entries recorded, the first one is the state at the start and no change
+	Changes int
+
+	PeakViewers   int
+	ViewerTotal   int
+	ViewerSamples int
+
+	// Timeline is loaded when the session ends
+	Timeline []*TwitchSessionChange `gorm:"-"`
+}
+
+// TwitchSessionChange is an entry of the title and category timeline of a stream session
+type TwitchSessionChange struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+
+	TwitchStreamSessionID uint `gorm:"index"`
+	Time                  time.Time
+	Title                 string
+	GameID                string
+	Category              string
+	// TitleChanged and CategoryChanged are both false for the entry of the stream start
+	TitleChanged    bool
+	CategoryChanged bool
+}
+
+// TwitchViewerSample viewer count of a stream session at one poll
+type TwitchViewerSample struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+
+	TwitchStreamSessionID uint `gorm:"index"`
+	Viewers               int
 }
 
 // TwitchAlertSubscription ...
@@ -52,13 +131,195 @@ type TwitchAlertSubscription struct {
 	CreatedAt time.Time
 	UpdatedAt time.Time
 
+	AlertSubscription
+
+	TwitchStreamerID uint
+}
+
+// AlertSubscription settings of a live alert in a discord channel, embedded by the subscriptions of every LiveSource
+type AlertSubscription struct {
 	MessageID string
 	ChannelID string
 	GuildID   string
 
+	// Message is sent with the live embed, the placeholders of the source like {title} get replaced
+	Message string
+	// MentionRoleID role to ping when the stream starts, mentionEveryone for @everyone
+	MentionRoleID string
+	Color         *int `gorm:"default:16711680"`
+	HideThumbnail bool
+	// NotifyChanges posts a notice when the title or category changes during the stream
+	NotifyChanges bool
+	// Timezone and DateFormat override the guild settings when set
+	Timezone   string
+	DateFormat string
+}
+
+// YouTubeChannel stores data about a youtube channel
+type YouTubeChannel struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	ChannelID    string
+	Title        string
+	ThumbnailURL string
+
+	LiveState
+	// LiveVideoID video of the running or last live stream
+	LiveVideoID string
+	// LastUploadTime publish time of the newest announced upload, older ones are ignored
+	LastUploadTime time.Time
+	// liveVideo latest polled state of the live video
+	liveVideo *youtubeVideo `gorm:"-"`
+
+	YouTubeAlertSubscriptions []*YouTubeAlertSubscription
+}
+
+// YouTubeAlertSubscription posts live streams and uploads of a youtube channel
+type YouTubeAlertSubscription struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	AlertSubscription
+
+	YouTubeChannelID uint
+}
+
+// FeedSubscription posts new entries of an rss or atom feed to a channel
+type FeedSubscription struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	ChannelID string
+	GuildID   string
+
+	URL string
+	// Title of the feed, shown as author of the embeds
+	Title string
+	// Keywords comma separated, entries need one of them in the title or summary, -keyword excludes
+	Keywords string
+	// Template of the embed description, empty shows the summary
+	Template string
+
+	// ETag and LastModified of the last response, sent back to only get changed feeds
+	ETag         string
+	LastModified string
+}
+
+// FeedEntry entry of a feed that was already seen
+type FeedEntry struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+
+	FeedSubscriptionID uint `gorm:"index"`
+	GUID               string
+}
+
+// TranslationCacheEntry translation kept by the cache, Hash covers provider, languages and text
+type TranslationCacheEntry struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+
+	Hash string `gorm:"unique_index"`
+	// Source language of the text, the detected one for automatic detection
+	Source string
+	Text   string `gorm:"type:text"`
+}
+
+// TranslationUsage characters a guild translated in a month
+type TranslationUsage struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	GuildID string `gorm:"unique_index:idx_translation_usage"`
+	// Month like 2006-01
+	Month      string `gorm:"unique_index:idx_translation_usage"`
+	Characters int
+}
+
+// TranslateLink mirrors messages of a channel translated into another channel,
+// a linked pair of channels has one link per direction
+type TranslateLink struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	GuildID   string
+	ChannelID string
+	// TargetChannelID channel the translations are posted in
+	TargetChannelID string
+	// Language of the target channel
+	Language string
+	// WebhookID and WebhookToken of the webhook in the target channel
+	WebhookID    string
+	WebhookToken string
+}
+
+// kinds of TwitchGroupSubscription
+const (
+	groupKindCategory = "category"
+	groupKindTeam     = "team"
+)
+
+// TwitchGroupSubscription alerts a channel about every stream of a category or team that passes the filters
+type TwitchGroupSubscription struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	ChannelID string
+	GuildID   string
+
+	Kind string
+	// TargetID game or team id, TargetName its name
+	TargetID   string
+	TargetName string
+
+	// Language of the stream like ja, empty for every language
+	Language   string
+	MinViewers int
+	// Tags comma separated, a stream needs all of them
+	Tags string
+}
+
+// TwitchGroupAlert live embed sent for a group subscription, one per stream and channel
+type TwitchGroupAlert struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	TwitchGroupSubscriptionID uint
+	ChannelID                 string `gorm:"unique_index:idx_group_alert_stream"`
+	GuildID                   string
+	StreamID                  string `gorm:"unique_index:idx_group_alert_stream"`
+	UserID                    string
+	UserLogin                 string
+	MessageID                 string
+	StartedAt                 time.Time
+	Live                      bool
+}
+
+// TwitchChatBridge relays the twitch chat of a streamer into a discord channel
+type TwitchChatBridge struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	ChannelID string
+	GuildID   string
+	// OnlyLive relays messages only while the streamer is live
+	OnlyLive bool
+
 	TwitchStreamerID uint
 }
 
+// mentionEveryone MentionRoleID value to ping @everyone
+const mentionEveryone = "everyone"
+
 // NewDatabase create/opens a database
 func (tb *TenseiBot) NewDatabase() {
 	db := tb.Config.Database.ConnectionString
@@ -77,6 +338,19 @@ func (tb *TenseiBot) NewDatabase() {
 	tb.db.AutoMigrate(&Guild{})
 	tb.db.AutoMigrate(&TwitchStreamer{})
 	tb.db.AutoMigrate(&TwitchAlertSubscription{})
+	tb.db.AutoMigrate(&TwitchStreamSession{})
+	tb.db.AutoMigrate(&TwitchViewerSample{})
+	tb.db.AutoMigrate(&TwitchSessionChange{})
+	tb.db.AutoMigrate(&TwitchGroupSubscription{})
+	tb.db.AutoMigrate(&TwitchGroupAlert{})
+	tb.db.AutoMigrate(&TwitchChatBridge{})
+	tb.db.AutoMigrate(&YouTubeChannel{})
+	tb.db.AutoMigrate(&YouTubeAlertSubscription{})
+	tb.db.AutoMigrate(&FeedSubscription{})
+	tb.db.AutoMigrate(&FeedEntry{})
+	tb.db.AutoMigrate(&TranslationCacheEntry{})
+	tb.db.AutoMigrate(&TranslationUsage{})
+	tb.db.AutoMigrate(&TranslateLink{})
 
 	log.Info("[MODULE] database loaded")
 }
@@ -119,6 +393,28 @@ func (tb *TenseiBot) AddStreamer(streamer *TwitchStreamer) {
 	tb.db.Create(streamer)
 }
 
+// RemoveStreamer deletes the streamer and its stream sessions from the database
+func (tb *TenseiBot) RemoveStreamer(streamer *TwitchStreamer) {
+	var sessionIDs []uint
+	tb.db.Model(&TwitchStreamSession{}).Where("twitch_streamer_id = ?", streamer.ID).Pluck("id", &sessionIDs)
+	if len(sessionIDs) > 0 {
+		tb.db.Where("twitch_stream_session_id IN (?)", sessionIDs).Delete(TwitchViewerSample{})
+		tb.db.Where("twitch_stream_session_id IN (?)", sessionIDs).Delete(TwitchSessionChange{})
+		tb.db.Where("twitch_streamer_id = ?", streamer.ID).Delete(TwitchStreamSession{})
+	}
+	tb.db.Delete(streamer)
+}
+
+// RemoveAlertSubscription deletes the alert subscription from the database
+func (tb *TenseiBot) RemoveAlertSubscription(alert *TwitchAlertSubscription) {
+	tb.db.Delete(alert)
+}
+
+// UpdateAlertSubscription updates the alert subscription in the database
+func (tb *TenseiBot) UpdateAlertSubscription(alert *TwitchAlertSubscription) {
+	tb.db.Save(alert)
+}
+
 // GetStreamers returns a list of all TwitchStreamers in the database
 func (tb *TenseiBot) GetStreamers() []*TwitchStreamer {
 	var streamers []*TwitchStreamer
@@ -150,3 +446,236 @@ func (tb *TenseiBot) GetStreamerWithName(name string) (*TwitchStreamer, error) {
 	}
 	return &streamer, nil
 }
+
+// AddStreamSession adds a new stream session to the database
+func (tb *TenseiBot) AddStreamSession(session *TwitchStreamSession) {
+	tb.db.Create(session)
+}
+
+// UpdateStreamSession updates stream session in database
+func (tb *TenseiBot) UpdateStreamSession(session *TwitchStreamSession) {
+	tb.db.Save(session)
+}
+
+// AddViewerSample adds a viewer sample to the database
+func (tb *TenseiBot) AddViewerSample(sample *TwitchViewerSample) {
+	tb.db.Create(sample)
+}
+
+// GetOpenStreamSession returns the session of the streamer that didn't end yet
+func (tb *TenseiBot) GetOpenStreamSession(streamer *TwitchStreamer) (*TwitchStreamSession, error) {
+	var session TwitchStreamSession
+	tb.db.Where("twitch_streamer_id = ? AND start_time = ?", streamer.ID, streamer.StreamStartTime).Order("id desc").First(&session)
+	if session.ID == 0 {
+		return nil, fmt.Errorf("[DATABASE] no open stream session for streamer: %s found", streamer.Name)
+	}
+	return &session, nil
+}
+
+// GetStreamSessions returns the latest stream sessions of the streamer
+func (tb *TenseiBot) GetStreamSessions(streamer *TwitchStreamer, limit int) []*TwitchStreamSession {
+	var sessions []*TwitchStreamSession
+	tb.db.Where("twitch_streamer_id = ?", streamer.ID).Order("start_time desc").Limit(limit).Find(&sessions)
+	return sessions
+}
+
+// AddSessionChange adds a timeline entry to the database
+func (tb *TenseiBot) AddSessionChange(change *TwitchSessionChange) {
+	tb.db.Create(change)
+}
+
+// GetSessionChanges returns the timeline of the stream session, oldest first
+func (tb *TenseiBot) GetSessionChanges(session *TwitchStreamSession) []*TwitchSessionChange {
+	var changes []*TwitchSessionChange
+	tb.db.Where("twitch_stream_session_id = ?", session.ID).Order("time asc").Find(&changes)
+	return changes
+}
+
+// AddGroupSubscription adds a category or team subscription to the database
+func (tb *TenseiBot) AddGroupSubscription(sub *TwitchGroupSubscription) {
+	tb.db.Create(sub)
+}
+
+// RemoveGroupSubscription deletes the category or team subscription from the database
+func (tb *TenseiBot) RemoveGroupSubscription(sub *TwitchGroupSubscription) {
+	tb.db.Delete(sub)
+}
+
+// GetGroupSubscriptions returns all category and team subscriptions
+func (tb *TenseiBot) GetGroupSubscriptions() []*TwitchGroupSubscription {
+	var subs []*TwitchGroupSubscription
+	tb.db.Find(&subs)
+	return subs
+}
+
+// AddGroupAlert adds a sent group alert to the database
+func (tb *TenseiBot) AddGroupAlert(alert *TwitchGroupAlert) error {
+	return tb.db.Create(alert).Error
+}
+
+// UpdateGroupAlert updates the group alert in the database
+func (tb *TenseiBot) UpdateGroupAlert(alert *TwitchGroupAlert) {
+	tb.db.Save(alert)
+}
+
+// GetLiveGroupAlerts returns the group alerts of streams that didn't end yet
+func (tb *TenseiBot) GetLiveGroupAlerts() []*TwitchGroupAlert {
+	var alerts []*TwitchGroupAlert
+	tb.db.Where("live = ?", true).Find(&alerts)
+	return alerts
+}
+
+// AddChatBridge adds a chat bridge to the database
+func (tb *TenseiBot) AddChatBridge(bridge *TwitchChatBridge) {
+	tb.db.Create(bridge)
+}
+
+// UpdateChatBridge updates the chat bridge in the database
+func (tb *TenseiBot) UpdateChatBridge(bridge *TwitchChatBridge) {
+	tb.db.Save(bridge)
+}
+
+// RemoveChatBridge deletes the chat bridge from the database
+func (tb *TenseiBot) RemoveChatBridge(bridge *TwitchChatBridge) {
+	tb.db.Delete(bridge)
+}
+
+// GetChatBridges returns all chat bridges
+func (tb *TenseiBot) GetChatBridges() []*TwitchChatBridge {
+	var bridges []*TwitchChatBridge
+	tb.db.Find(&bridges)
+	return bridges
+}
+
+// AddYouTubeChannel adds a youtube channel to the database
+func (tb *TenseiBot) AddYouTubeChannel(channel *YouTubeChannel) {
+	tb.db.Create(channel)
+}
+
+// UpdateYouTubeChannel updates the youtube channel and its subscriptions in the database
+func (tb *TenseiBot) UpdateYouTubeChannel(channel *YouTubeChannel) {
+	tb.db.Save(channel)
+}
+
+// RemoveYouTubeChannel deletes the youtube channel from the database
+func (tb *TenseiBot) RemoveYouTubeChannel(channel *YouTubeChannel) {
+	tb.db.Delete(channel)
+}
+
+// GetYouTubeChannels returns all youtube channels with their subscriptions
+func (tb *TenseiBot) GetYouTubeChannels() []*YouTubeChannel {
+	var channels []*YouTubeChannel
+	tb.db.Set("gorm:auto_preload", true).Find(&channels)
+	return channels
+}
+
+// UpdateYouTubeAlertSubscription updates the youtube subscription in the database
+func (tb *TenseiBot) UpdateYouTubeAlertSubscription(alert *YouTubeAlertSubscription) {
+	tb.db.Save(alert)
+}
+
+// RemoveYouTubeAlertSubscription deletes the youtube subscription from the database
+func (tb *TenseiBot) RemoveYouTubeAlertSubscription(alert *YouTubeAlertSubscription) {
+	tb.db.Delete(alert)
+}
+
+// AddFeedSubscription adds a feed subscription to the database
+func (tb *TenseiBot) AddFeedSubscription(sub *FeedSubscription) {
+	tb.db.Create(sub)
+}
+
+// UpdateFeedSubscription updates the feed subscription in the database
+func (tb *TenseiBot) UpdateFeedSubscription(sub *FeedSubscription) {
+	tb.db.Save(sub)
+}
+
+// RemoveFeedSubscription deletes the feed subscription and its seen entries from the database
+func (tb *TenseiBot) RemoveFeedSubscription(sub *FeedSubscription) {
+	tb.db.Where("feed_subscription_id = ?", sub.ID).Delete(FeedEntry{})
+	tb.db.Delete(sub)
+}
+
+// GetFeedSubscriptions returns all feed subscriptions
+func (tb *TenseiBot) GetFeedSubscriptions() []*FeedSubscription {
+	var subs []*FeedSubscription
+	tb.db.Find(&subs)
+	return subs
+}
+
+// AddFeedEntries marks the entries of the feed subscription as seen
+func (tb *TenseiBot) AddFeedEntries(sub *FeedSubscription, guids []string) {
+	for _, guid := range guids {
+		tb.db.Create(&FeedEntry{FeedSubscriptionID: sub.ID, GUID: guid})
+	}
+}
+
+// GetFeedEntries returns the seen entries of the feed subscription
+func (tb *TenseiBot) GetFeedEntries(sub *FeedSubscription) []*FeedEntry {
+	var entries []*FeedEntry
+	tb.db.Where("feed_subscription_id = ?", sub.ID).Find(&entries)
+	return entries
+}
+
+// RemoveFeedEntries deletes seen entries by id
+func (tb *TenseiBot) RemoveFeedEntries(ids []uint) {
+	if len(ids) > 0 {
+		tb.db.Where("id IN (?)", ids).Delete(FeedEntry{})
+	}
+}
+
+// GetTranslationCacheEntry returns the cached translation created after since
+func (tb *TenseiBot) GetTranslationCacheEntry(hash string, since time.Time) (*TranslationCacheEntry, error) {
+	var entry TranslationCacheEntry
+	err := tb.db.Where("hash = ? AND created_at > ?", hash, since).First(&entry).Error
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// AddTranslationCacheEntry stores the translation, replacing an expired one with the same hash
+func (tb *TenseiBot) AddTranslationCacheEntry(entry *TranslationCacheEntry) {
+	tb.db.Where("hash = ?", entry.Hash).Delete(TranslationCacheEntry{})
+	tb.db.Create(entry)
+}
+
+// RemoveExpiredTranslations deletes cached translations created before
+func (tb *TenseiBot) RemoveExpiredTranslations(before time.Time) {
+	tb.db.Where("created_at < ?", before).Delete(TranslationCacheEntry{})
+}
+
+// GetTranslationUsage returns the characters the guild translated in the month
+func (tb *TenseiBot) GetTranslationUsage(guildID, month string) int {
+	var usage TranslationUsage
+	tb.db.Where("guild_id = ? AND month = ?", guildID, month).First(&usage)
+	return usage.Characters
+}
+
+// AddTranslationUsage adds characters to the usage of the guild in the month
+func (tb *TenseiBot) AddTranslationUsage(guildID, month string, characters int) {
+	usage := TranslationUsage{GuildID: guildID, Month: month}
+	tb.db.Where(usage).FirstOrCreate(&usage)
+	tb.db.Model(&usage).UpdateColumn("characters", gorm.Expr("characters + ?", characters))
+}
+
+// AddTranslateLink adds a translate link to the database
+func (tb *TenseiBot) AddTranslateLink(link *TranslateLink) {
+	tb.db.Create(link)
+}
+
+// UpdateTranslateLink updates the translate link in the database
+func (tb *TenseiBot) UpdateTranslateLink(link *TranslateLink) {
+	tb.db.Save(link)
+}
+
+// RemoveTranslateLink deletes the translate link from the database
+func (tb *TenseiBot) RemoveTranslateLink(link *TranslateLink) {
+	tb.db.Delete(link)
+}
+
+// GetTranslateLinks returns all translate links
+func (tb *TenseiBot) GetTranslateLinks() []*TranslateLink {
+	var links []*TranslateLink
+	tb.db.Find(&links)
+	return links
+}
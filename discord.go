@@ -1,17 +1,19 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/bwmarrin/discordgo"
 	log "github.com/sirupsen/logrus"
 )
 
-type commandFunc func(s *discordgo.Session, m *discordgo.MessageCreate, command string)
-
 // TenseiDiscord discord part of the bot
 type TenseiDiscord struct {
 	c      *discordgo.Session
@@ -21,17 +23,19 @@ type TenseiDiscord struct {
 	msgCache      []*discordgo.Message
 	msgCacheLimit int
 
-	commands map[string]command
+	commands    map[string]*command
+	commandList []*command
+
+	prefixesMutex sync.Mutex
+	prefixes      map[string]string
+
+	paginationsMutex sync.Mutex
+	paginations      map[string]*paginatedMessage
 
 	cooldowns      map[string]*Cooldowns
 	CooldownsMutex sync.Mutex
 }
 
-type command struct {
-	f   commandFunc
-	cds map[string]time.Time
-}
-
 // Cooldowns struct for storing channel specific cooldowns
 type Cooldowns struct {
 	Translate time.Time
@@ -39,13 +43,350 @@ type Cooldowns struct {
 }
 
 // SetupDiscordCommands ...
-func (tb *TenseiBot) SetupDiscordCommands(prefix string) {
-	tb.Discord.commands = map[string]command{
-		prefix + "tr":     {f: discordTranslate(tb), cds: make(map[string]time.Time)},
-		prefix + "twitch": {f: discordTwitch(tb), cds: make(map[string]time.Time)},
-		prefix + "uptime": {f: discordUptime(tb), cds: make(map[string]time.Time)},
-		prefix + "stats":  {f: discordStats(tb), cds: make(map[string]time.Time)},
-		prefix + "tb":     {f: discordTenseiBot(tb), cds: make(map[string]time.Time)},
+func (tb *TenseiBot) SetupDiscordCommands() {
+	commands := []*command{
+		{
+			name:        "help",
+			args:        []commandArg{{name: "command", typ: argText, optional: true}},
+			f:           discordHelp(tb),
+			description: "returns the commands you can use or details about one command",
+			examples:    []string{"help", "help twitch add"},
+		},
+		{
+			name:    "tr",
+			aliases: []string{"translate"},
+			args: []commandArg{
+				{name: "target", typ: argWord, complete: tb.completeLanguages},
+				{name: "text", typ: argText},
+			},
+			f:           discordTranslate(tb),
+			slashName:   "text",
+			description: "returns translated text in target language, from:to sets the source language too",
+			examples:    []string{"tr en こんにちは", "tr japanese good morning", "tr ja:en 橋"},
+			subcommands: []*command{
+				{
+					name: "link",
+					args: []commandArg{
+						{name: "channel", typ: argChannel},
+						{name: "language", typ: argWord, complete: tb.completeLanguages},
+						{name: "partner", typ: argChannel},
+						{name: "partner_language", typ: argWord, complete: tb.completeLanguages},
+					},
+					f:           discordTranslateLink(tb),
+					description: "translates every message of each channel into the other one, posted with the authors name and avatar",
+					examples:    []string{"tr link #japanese ja #english en"},
+					perm:        permAdmin,
+				},
+				{
+					name:        "unlink",
+					args:        []commandArg{{name: "channel", typ: argChannel}, {name: "partner", typ: argChannel}},
+					f:           discordTranslateUnlink(tb),
+					description: "stops translating between the channels",
+					examples:    []string{"tr unlink #japanese #english"},
+					perm:        permAdmin,
+				},
+				{
+					name:        "links",
+					f:           discordTranslateLinks(tb),
+					description: "returns the linked channels of this server",
+				},
+			},
+		},
+		{
+			name:        "detect",
+			args:        []commandArg{{name: "text", typ: argText}},
+			f:           discordDetect(tb),
+			description: "returns the language of the text",
+			examples:    []string{"detect こんにちは"},
+		},
+		{
+			name:        "languages",
+			f:           discordLanguages(tb),
+			description: "returns the languages translations support",
+		},
+		{
+			name:        "twitch",
+			description: "twitch lookups and stream alerts",
+			subcommands: []*command{
+				{
+					name:        "id",
+					args:        []commandArg{{name: "username", typ: argWord}},
+					f:           discordTwitchID(tb),
+					description: "returns users twitch id",
+					examples:    []string{"twitch id tensei"},
+				},
+				{
+					name:        "name",
+					args:        []commandArg{{name: "id", typ: argWord}},
+					f:           discordTwitchName(tb),
+					description: "returns users twitch name",
+					examples:    []string{"twitch name 11249217"},
+				},
+				{
+					name: "add",
+					args: []commandArg{
+						{name: "streamer", typ: argWord, complete: tb.completeStreamers},
+						{name: "channel", typ: argChannel},
+					},
+					f:           discordTwitchAdd(tb),
+					description: "posts live alerts for the streamer in the channel",
+					examples:    []string{"twitch add tensei #streams"},
+					perm:        permAdmin,
+				},
+				{
+					name: "remove",
+					args: []commandArg{
+						{name: "streamer", typ: argWord, complete: tb.completeStreamers},
+						{name: "channel", typ: argChannel, optional: true},
+					},
+					f:           discordTwitchRemove(tb),
+					description: "stops posting live alerts for the streamer, in every channel of this server if none is given",
+					examples:    []string{"twitch remove tensei", "twitch remove tensei #streams"},
+					perm:        permAdmin,
+				},
+				{
+					name:        "list",
+					f:           discordTwitchList(tb),
+					description: "returns the live, category and team alerts of this server",
+				},
+				{
+					name: "chat",
+					args: []commandArg{
+						{name: "streamer", typ: argWord, complete: tb.completeStreamers},
+						{name: "channel", typ: argChannel},
+						{name: "mode", typ: argWord, optional: true, complete: completeChatModes},
+					},
+					f:           discordTwitchChat(tb),
+					description: "relays the twitch chat of a streamer alerted in this server, always, only while live or off",
+					examples:    []string{"twitch chat tensei #chat", "twitch chat tensei #chat live", "twitch chat tensei #chat off"},
+					perm:        permAdmin,
+				},
+				{
+					name:        "category",
+					description: "alerts for every stream in a category",
+					subcommands: []*command{
+						{
+							name:        "add",
+							args:        []commandArg{{name: "channel", typ: argChannel}, {name: "category", typ: argText}},
+							f:           discordTwitchGroupAdd(tb, groupKindCategory),
+							description: "adds a category alert, streams have to be filtered with lang:, viewers: or tags:",
+							examples:    []string{"twitch category add #streams Elden Ring viewers:100", "twitch category add #streams Elden Ring lang:ja viewers:50 tags:Speedrun"},
+							perm:        permAdmin,
+						},
+						{
+							name:        "remove",
+							args:        []commandArg{{name: "channel", typ: argChannel}, {name: "category", typ: argText}},
+							f:           discordTwitchGroupRemove(tb, groupKindCategory),
+							description: "removes a category alert",
+							examples:    []string{"twitch category remove #streams Elden Ring"},
+							perm:        permAdmin,
+						},
+					},
+				},
+				{
+					name:        "team",
+					description: "alerts for every stream of a twitch team",
+					subcommands: []*command{
+						{
+							name:        "add",
+							args:        []commandArg{{name: "channel", typ: argChannel}, {name: "team", typ: argText}},
+							f:           discordTwitchGroupAdd(tb, groupKindTeam),
+							description: "adds a team alert, streams can be filtered with lang:, viewers: and tags:",
+							examples:    []string{"twitch team add #streams hololive", "twitch team add #streams hololive lang:en"},
+							perm:        permAdmin,
+						},
+						{
+							name:        "remove",
+							args:        []commandArg{{name: "channel", typ: argChannel}, {name: "team", typ: argText}},
+							f:           discordTwitchGroupRemove(tb, groupKindTeam),
+							description: "removes a team alert",
+							examples:    []string{"twitch team remove #streams hololive"},
+							perm:        permAdmin,
+						},
+					},
+				},
+				{
+					name: "config",
+					args: []commandArg{
+						{name: "streamer", typ: argWord, complete: tb.completeStreamers},
+						{name: "channel", typ: argChannel},
+						{name: "setting", typ: argWord, optional: true, complete: completeAlertSettings},
+						{name: "value", typ: argText, optional: true},
+					},
+					f:           discordTwitchConfig(tb),
+					description: "shows or changes the message, mention, colour, thumbnail, change notices and dates of a live alert",
+					examples: []string{
+						"twitch config tensei #streams",
+						"twitch config tensei #streams message {streamer} is live playing {game}! {url}",
+						"twitch config tensei #streams mention @Viewers",
+						"twitch config tensei #streams color #9146ff",
+						"twitch config tensei #streams thumbnail off",
+						"twitch config tensei #streams changes on",
+						"twitch config tensei #streams timezone Asia/Tokyo",
+					},
+					perm: permAdmin,
+				},
+				{
+					name:        "history",
+					args:        []commandArg{{name: "streamer", typ: argWord, complete: tb.completeStreamers}},
+					f:           discordTwitchHistory(tb),
+					description: "returns the recent streams of a tracked streamer with their viewers",
+					examples:    []string{"twitch history tensei"},
+				},
+				{
+					name:        "online",
+					aliases:     []string{"live"},
+					args:        []commandArg{{name: "streamer", typ: argWord, complete: tb.completeStreamers}},
+					f:           discordTwitchOnline(tb),
+					description: "returns if the streamer is live",
+					examples:    []string{"twitch online tensei"},
+				},
+			},
+		},
+		{
+			name:        "youtube",
+			description: "youtube live and upload alerts",
+			subcommands: []*command{
+				{
+					name: "add",
+					args: []commandArg{
+						{name: "channel", typ: argWord, complete: tb.completeYouTubeChannels},
+						{name: "discordchannel", typ: argChannel},
+					},
+					f:           discordYouTubeAdd(tb),
+					description: "posts live and upload alerts for the youtube channel, given as @handle, channel id or url",
+					examples:    []string{"youtube add @tensei #streams", "youtube add https://www.youtube.com/channel/UCxxxxxxxxxxxxxxxxxxxxxx #streams"},
+					perm:        permAdmin,
+				},
+				{
+					name: "remove",
+					args: []commandArg{
+						{name: "channel", typ: argWord, complete: tb.completeYouTubeChannels},
+						{name: "discordchannel", typ: argChannel, optional: true},
+					},
+					f:           discordYouTubeRemove(tb),
+					description: "stops posting alerts for the youtube channel, in every channel of this server if none is given",
+					examples:    []string{"youtube remove @tensei", "youtube remove @tensei #streams"},
+					perm:        permAdmin,
+				},
+				{
+					name:        "list",
+					f:           discordYouTubeList(tb),
+					description: "returns the youtube alerts of this server",
+				},
+			},
+		},
+		{
+			name:        "feed",
+			description: "posts new entries of rss and atom feeds",
+			subcommands: []*command{
+				{
+					name: "add",
+					args: []commandArg{
+						{name: "channel", typ: argChannel},
+						{name: "url", typ: argWord},
+						{name: "options", typ: argText, optional: true},
+					},
+					f:           discordFeedAdd(tb),
+					description: "posts new entries of the feed in the channel, keywords: filters them (-word excludes) and template: sets the embed text with {title}, {link}, {summary}, {author}, {feed} and {published}",
+					examples: []string{
+						"feed add #news https://example.com/feed.xml",
+						"feed add #news https://example.com/feed.xml keywords:patch,hotfix,-rumor template:{summary} by {author}",
+					},
+					perm: permAdmin,
+				},
+				{
+					name:        "remove",
+					args:        []commandArg{{name: "channel", typ: argChannel}, {name: "url", typ: argWord}},
+					f:           discordFeedRemove(tb),
+					description: "stops posting the feed in the channel",
+					examples:    []string{"feed remove #news https://example.com/feed.xml"},
+					perm:        permAdmin,
+				},
+				{
+					name:        "list",
+					f:           discordFeedList(tb),
+					description: "returns the feeds of this server",
+				},
+			},
+		},
+		{
+			name:        "uptime",
+			f:           discordUptime(tb),
+			description: "returns bot uptime",
+			perm:        permBotOwner,
+		},
+		{
+			name:        "stats",
+			f:           discordStats(tb),
+			description: "returns bot stats",
+			perm:        permBotOwner,
+		},
+		{
+			name:        "tb",
+			description: "bot settings for this server",
+			perm:        permGuildOwner,
+			subcommands: []*command{
+				{
+					name:        "set",
+					description: "change a setting",
+					subcommands: []*command{
+						{
+							name:        "adminrole",
+							args:        []commandArg{{name: "role", typ: argRole}},
+							f:           discordSetAdminRole(tb),
+							description: "sets the role allowed to manage alerts and skip cooldowns",
+							examples:    []string{"tb set adminrole @Mods"},
+						},
+						{
+							name:        "prefix",
+							args:        []commandArg{{name: "prefix", typ: argWord}},
+							f:           discordSetPrefix(tb),
+							description: "sets the command prefix for this server, mentioning the bot always works",
+							examples:    []string{"tb set prefix ?"},
+						},
+						{
+							name:        "timezone",
+							args:        []commandArg{{name: "timezone", typ: argWord}},
+							f:           discordSetTimezone(tb),
+							description: "sets the timezone for dates in stream alerts",
+							examples:    []string{"tb set timezone Europe/Berlin", "tb set timezone UTC"},
+						},
+						{
+							name:        "translator",
+							args:        []commandArg{{name: "translator", typ: argWord, complete: tb.completeTranslators}},
+							f:           discordSetTranslator(tb),
+							description: "sets the translator tried first, the others are used when it fails, auto uses the default order",
+							examples:    []string{"tb set translator deepl", "tb set translator auto"},
+						},
+						{
+							name:        "dateformat",
+							args:        []commandArg{{name: "format", typ: argText}},
+							f:           discordSetDateFormat(tb),
+							description: "sets how dates in stream alerts look, discord shows every reader their local time",
+							examples:    []string{"tb set dateformat discord", "tb set dateformat 2006-01-02 15:04 MST"},
+						},
+						{
+							name:        "flags",
+							args:        []commandArg{{name: "mode", typ: argWord, complete: completeOnOff}},
+							f:           discordSetFlags(tb),
+							description: "turns translating messages members react to with a country flag on or off",
+							examples:    []string{"tb set flags on"},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	tb.Discord.commandList = commands
+	tb.Discord.commands = make(map[string]*command)
+	for _, c := range commands {
+		c.cds = make(map[string]time.Time)
+		tb.Discord.commands[c.name] = c
+		for _, alias := range c.aliases {
+			tb.Discord.commands[alias] = c
+		}
 	}
 }
 
@@ -64,6 +405,8 @@ func (tb *TenseiBot) NewDiscord() {
 	tb.Discord.msgCacheLimit = 1000
 	tb.Discord.msgCache = []*discordgo.Message{}
 	tb.Discord.cooldowns = make(map[string]*Cooldowns)
+	tb.Discord.paginations = make(map[string]*paginatedMessage)
+	tb.Discord.prefixes = make(map[string]string)
 	tb.Discord.prefix = tb.Config.Discord.Prefix
 	tb.Discord.c = s
 
@@ -72,14 +415,19 @@ func (tb *TenseiBot) NewDiscord() {
 	s.AddHandler(tb.GuildMemberAdd)
 	s.AddHandler(tb.GuildMemberRemove)
 	s.AddHandler(tb.GuildMemberUpdate)
+	s.AddHandler(tb.MessageCreate)
+	s.AddHandler(tb.MessageUpdate)
 	s.AddHandler(tb.MessageDelete)
+	s.AddHandler(tb.MessageReactionAdd)
+	s.AddHandler(tb.InteractionCreate)
 
-	tb.SetupDiscordCommands(tb.Config.Discord.Prefix)
+	tb.SetupDiscordCommands()
 
 	err = s.Open()
 	if err != nil {
 		log.Fatalf("failed opening connection to discord: %v", err)
 	}
+	tb.RegisterApplicationCommands()
 
 	log.Info("[MODULE] discord loaded")
 }
@@ -88,19 +436,59 @@ func (tb *TenseiBot) NewDiscord() {
 func (tb *TenseiBot) CommandHandler(s *discordgo.Session, m *discordgo.MessageCreate) {
 	tb.Discord.addMessageToCache(m)
 
-	if !strings.HasPrefix(m.Content, tb.Discord.prefix) {
+	content, ok := tb.trimPrefix(s, m)
+	if !ok {
+		return
+	}
+	tokens := tokenize(content)
+	if len(tokens) < 1 {
 		return
 	}
 
-	parts := strings.SplitN(m.Content, " ", 2)
-	for k, c := range tb.Discord.commands {
-		if strings.EqualFold(k, parts[0]) {
-			guild, _ := s.Guild(m.GuildID)
-			log.Infof("[COMMAND] %s used in server: %s(%s), user: %s(%s) ", parts[0], guild.Name, guild.ID, m.Author.String(), m.Author.ID)
-			go c.f(s, m, k)
-			return
+	c, ok := tb.Discord.commands[strings.ToLower(tokens[0].value)]
+	if !ok {
+		return
+	}
+	guild, _ := s.Guild(m.GuildID)
+	log.Infof("[COMMAND] %s used in server: %s(%s), user: %s(%s) ", tokens[0].value, guild.Name, guild.ID, m.Author.String(), m.Author.ID)
+	go tb.runCommand(s, m, c, tokens[1:])
+}
+
+// trimPrefix returns the content without the guilds prefix or the bots mention
+func (tb *TenseiBot) trimPrefix(s *discordgo.Session, m *discordgo.MessageCreate) (string, bool) {
+	for _, mention := range []string{"<@" + s.State.User.ID + ">", "<@!" + s.State.User.ID + ">"} {
+		if strings.HasPrefix(m.Content, mention) {
+			return strings.TrimPrefix(m.Content, mention), true
 		}
 	}
+	prefix := tb.guildPrefix(m.GuildID)
+	if !strings.HasPrefix(m.Content, prefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(m.Content, prefix), true
+}
+
+// guildPrefix returns the command prefix of the guild
+func (tb *TenseiBot) guildPrefix(guildID string) string {
+	tb.Discord.prefixesMutex.Lock()
+	defer tb.Discord.prefixesMutex.Unlock()
+
+	if prefix, ok := tb.Discord.prefixes[guildID]; ok {
+		return prefix
+	}
+	prefix := tb.GetGuildSettingsFromDB(guildID).Prefix
+	if prefix == "" {
+		prefix = tb.Discord.prefix
+	}
+	tb.Discord.prefixes[guildID] = prefix
+	return prefix
+}
+
+func (td *TenseiDiscord) setGuildPrefix(guildID, prefix string) {
+	td.prefixesMutex.Lock()
+	defer td.prefixesMutex.Unlock()
+
+	td.prefixes[guildID] = prefix
 }
 
 func (td *TenseiDiscord) getCooldown(c string, ch string) time.Time {
@@ -123,6 +511,19 @@ func (td *TenseiDiscord) setCooldown(c, channelID string, time time.Time) {
 	td.commands[c].cds[channelID] = time
 }
 
+// cachedMessage returns the message from the cache or fetches it from discord
+func (td *TenseiDiscord) cachedMessage(channelID, messageID string) (*discordgo.Message, error) {
+	td.msgCacheMutex.Lock()
+	for _, mc := range td.msgCache {
+		if mc.ID == messageID {
+			td.msgCacheMutex.Unlock()
+			return mc, nil
+		}
+	}
+	td.msgCacheMutex.Unlock()
+	return td.c.ChannelMessage(channelID, messageID)
+}
+
 func (td *TenseiDiscord) addMessageToCache(m *discordgo.MessageCreate) {
 	td.msgCacheMutex.Lock()
 	defer td.msgCacheMutex.Unlock()
@@ -161,70 +562,159 @@ func (tb *TenseiBot) isDiscordCommandOnCD(cmd, chID string, member *discordgo.Me
 	return true
 }
 
-func discordTranslate(tb *TenseiBot) func(s *discordgo.Session, m *discordgo.MessageCreate, command string) {
-	return func(s *discordgo.Session, m *discordgo.MessageCreate, command string) {
-		set := tb.GetGuildSettingsFromDB(m.GuildID)
-		member, _ := s.GuildMember(m.GuildID, m.Author.ID)
-		if tb.isDiscordCommandOnCD(command, m.ChannelID, member, *set.TranslateCooldown, set) {
-			log.Debugf("[COMMAND] %s is on cd", command)
+func discordTranslate(tb *TenseiBot) commandFunc {
+	return func(ctx *commandContext) {
+		set := tb.GetGuildSettingsFromDB(ctx.guildID)
+		if tb.isDiscordCommandOnCD(ctx.root.name, ctx.channelID, ctx.member, *set.TranslateCooldown, set) {
+			log.Debugf("[COMMAND] %s is on cd", ctx.root.name)
+			ctx.notice("%s is on cooldown", ctx.path)
 			return
 		}
 
-		parts := strings.SplitN(m.Content, " ", 3)
-		if len(parts) < 3 {
-			log.Info("[TRANSLATE] failed translating missing text")
-			return
+		text := ctx.str("text")
+		// if member didn't use the right language format get it from the supported list
+		source, target := tb.Translate.languagePair(ctx.str("target"))
+		for _, lang := range []string{source, target} {
+			if lang != "" && len(tb.Translate.languages) > 0 && !tb.Translate.supported(lang) {
+				ctx.error("'%s' isn't a supported language, `%slanguages` lists them", lang, ctx.prefix)
+				return
+			}
 		}
 
-		text := strings.TrimSpace(parts[2])
-		if text == "" {
-			log.Info("[TRANSLATE] failed translating text is empty")
+		output, err := tb.translateText(ctx.guildID, text, source, target)
+		if errors.Is(err, errTranslateBudget) {
+			_, limit := tb.translateBudget(ctx.guildID)
+			ctx.error("this server used its %d translated characters for this month", limit)
+			return
 		}
+		if err != nil {
+			log.Infof("[TRANSLATE] failed translating '%s', error: %v", text, err)
+			ctx.notice("failed translating text")
+			return
+		}
+		ctx.reply(tb.translationEmbed(text, target, output))
+	}
+}
 
-		target := strings.TrimSpace(parts[1])
+// embedFieldLimit discord rejects embed fields with longer values
+const embedFieldLimit = 1024
 
-		// if member didn't use the right language format get it from the supported list
-		if len(target) > 2 && len(tb.Google.supportedLanguages) > 0 {
-			for _, l := range tb.Google.supportedLanguages {
-				if strings.EqualFold(target, l.Name) {
-					target = l.Tag.String()
-					break
-				}
-			}
+// translationEmbed shows the input, its translation and the languages, with the
+// confidence of an automatically detected source language when the translator tells it
+func (tb *TenseiBot) translationEmbed(input, target string, output *translation) *discordgo.MessageEmbed {
+	embed := &discordgo.MessageEmbed{
+		Fields: []*discordgo.MessageEmbedField{
+			{
+				Name:   "Input",
+				Value:  truncateField(input),
+				Inline: false,
+			},
+			{
+				Name:   "Output",
+				Value:  truncateField(output.text),
+				Inline: false,
+			},
+		},
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: " - " + translatorTitle(output.provider),
+		},
+	}
+	if output.source != "" {
+		languages := fmt.Sprintf("%s → %s", tb.Translate.languageName(output.source), tb.Translate.languageName(target))
+		if output.confidence > 0 {
+			languages += fmt.Sprintf(", detected with %.0f%% confidence", output.confidence*100)
 		}
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:  "Language",
+			Value: languages,
+		})
+	}
+	return embed
+}
 
-		output, err := tb.Google.Translate(text, target)
+func discordDetect(tb *TenseiBot) commandFunc {
+	return func(ctx *commandContext) {
+		set := tb.GetGuildSettingsFromDB(ctx.guildID)
+		if tb.isDiscordCommandOnCD(ctx.root.name, ctx.channelID, ctx.member, *set.TranslateCooldown, set) {
+			log.Debugf("[COMMAND] %s is on cd", ctx.root.name)
+			ctx.notice("%s is on cooldown", ctx.path)
+			return
+		}
+
+		text := ctx.str("text")
+		result, err := tb.detectLanguage(ctx.guildID, text)
 		if err != nil {
-			log.Infof("[TRANSLATE] failed translating '%s', error: %v", text, err)
+			log.Infof("[TRANSLATE] failed detecting language of '%s', error: %v", text, err)
+			ctx.notice("failed detecting language")
 			return
 		}
 
-		_, _ = s.ChannelMessageSendEmbed(m.ChannelID, &discordgo.MessageEmbed{
+		confidence := "unknown"
+		if result.confidence > 0 {
+			confidence = fmt.Sprintf("%.0f%%", result.confidence*100)
+		}
+		ctx.reply(&discordgo.MessageEmbed{
 			Fields: []*discordgo.MessageEmbedField{
 				{
-					Name:   "Input",
-					Value:  text,
-					Inline: false,
+					Name:   "Language",
+					Value:  fmt.Sprintf("%s (%s)", tb.Translate.languageName(result.language), result.language),
+					Inline: true,
 				},
 				{
-					Name:   "Output",
-					Value:  output,
-					Inline: false,
+					Name:   "Confidence",
+					Value:  confidence,
+					Inline: true,
 				},
 			},
 			Footer: &discordgo.MessageEmbedFooter{
-				Text: " - Google Cloud Translate",
+				Text: " - " + translatorTitle(result.provider),
 			},
 		})
 	}
 }
 
-func discordUptime(tb *TenseiBot) func(s *discordgo.Session, m *discordgo.MessageCreate, command string) {
-	return func(s *discordgo.Session, m *discordgo.MessageCreate, command string) {
-		if m.Author.ID != tb.Config.Discord.OwnerID {
+const languagesPerPage = 20
+
+func discordLanguages(tb *TenseiBot) commandFunc {
+	return func(ctx *commandContext) {
+		languages := append([]translateLanguage(nil), tb.Translate.languages...)
+		if len(languages) < 1 {
+			ctx.error("the supported languages couldn't be loaded")
 			return
 		}
-		_, _ = s.ChannelMessageSendEmbed(m.ChannelID, &discordgo.MessageEmbed{
+		sort.Slice(languages, func(i, j int) bool {
+			return languages[i].name < languages[j].name
+		})
+
+		var pages []*discordgo.MessageEmbed
+		for start := 0; start < len(languages); start += languagesPerPage {
+			end := start + languagesPerPage
+			if end > len(languages) {
+				end = len(languages)
+			}
+			lines := make([]string, 0, end-start)
+			for _, l := range languages[start:end] {
+				lines = append(lines, fmt.Sprintf("%s `%s`", l.name, l.code))
+			}
+			pages = append(pages, &discordgo.MessageEmbed{
+				Title:       fmt.Sprintf("Languages (%d)", len(languages)),
+				Description: strings.Join(lines, "\n"),
+			})
+		}
+		ctx.replyPages(pages)
+	}
+}
+
+func truncateField(value string) string {
+	if utf8.RuneCountInString(value) <= embedFieldLimit {
+		return value
+	}
+	return string([]rune(value)[:embedFieldLimit-3]) + "..."
+}
+
+func discordUptime(tb *TenseiBot) commandFunc {
+	return func(ctx *commandContext) {
+		ctx.reply(&discordgo.MessageEmbed{
 			Fields: []*discordgo.MessageEmbedField{
 				{
 					Name:   "Started",
@@ -241,18 +731,30 @@ func discordUptime(tb *TenseiBot) func(s *discordgo.Session, m *discordgo.Messag
 	}
 }
 
-func discordStats(tb *TenseiBot) func(s *discordgo.Session, m *discordgo.MessageCreate, command string) {
-	return func(s *discordgo.Session, m *discordgo.MessageCreate, command string) {
-		if !tb.isOwner(m.Author.ID) {
-			return
-		}
-		guilds := len(s.State.Guilds)
+func discordStats(tb *TenseiBot) commandFunc {
+	return func(ctx *commandContext) {
+		guilds := len(ctx.s.State.Guilds)
 		users := 0
-		for _, g := range s.State.Guilds {
+		for _, g := range ctx.s.State.Guilds {
 			users += len(g.Members)
 		}
+		remaining, limit, reset := tb.Twitch.RateLimitBudget()
+		resetIn := time.Until(reset).Round(time.Second)
+		if resetIn < 0 {
+			resetIn = 0
+		}
+		hits, misses, cached := tb.Translate.cache.stats()
+		hitRate := 0
+		if hits+misses > 0 {
+			hitRate = hits * 100 / (hits + misses)
+		}
+		used, budget := tb.translateBudget(ctx.guildID)
+		usage := fmt.Sprintf("%d characters", used)
+		if budget > 0 {
+			usage = fmt.Sprintf("%d/%d characters", used, budget)
+		}
 
-		_, _ = s.ChannelMessageSendEmbed(m.ChannelID, &discordgo.MessageEmbed{
+		ctx.reply(&discordgo.MessageEmbed{
 			Title: "Stats",
 			Fields: []*discordgo.MessageEmbedField{
 				{
@@ -265,115 +767,481 @@ func discordStats(tb *TenseiBot) func(s *discordgo.Session, m *discordgo.Message
 					Value:  fmt.Sprintf("%d", users),
 					Inline: true,
 				},
+				{
+					Name:   "Helix budget",
+					Value:  fmt.Sprintf("%d/%d, resets in %s", remaining, limit, resetIn),
+					Inline: true,
+				},
+				{
+					Name:   "Helix auth",
+					Value:  tb.Twitch.AppAccessTokenStatus(),
+					Inline: true,
+				},
+				{
+					Name:   "Translation cache",
+					Value:  fmt.Sprintf("%d hits, %d misses (%d%%), %d cached", hits, misses, hitRate, cached),
+					Inline: true,
+				},
+				{
+					Name:   "Translated here this month",
+					Value:  usage,
+					Inline: true,
+				},
 			},
 		})
 	}
 }
 
-func discordTwitch(tb *TenseiBot) func(s *discordgo.Session, m *discordgo.MessageCreate, command string) {
-	return func(s *discordgo.Session, m *discordgo.MessageCreate, command string) {
-		set := tb.GetGuildSettingsFromDB(m.GuildID)
-		member, _ := s.GuildMember(m.GuildID, m.Author.ID)
-		if tb.isDiscordCommandOnCD(command, m.ChannelID, member, *set.TwitchCooldown, set) {
+// isTwitchCommandOnCD checks the twitch cooldown of the guild
+func (tb *TenseiBot) isTwitchCommandOnCD(ctx *commandContext) bool {
+	set := tb.GetGuildSettingsFromDB(ctx.guildID)
+	if tb.isDiscordCommandOnCD(ctx.root.name, ctx.channelID, ctx.member, *set.TwitchCooldown, set) {
+		log.Debugf("[COMMAND] %s is on cd", ctx.root.name)
+		ctx.notice("%s is on cooldown", ctx.path)
+		return true
+	}
+	return false
+}
+
+func discordTwitchID(tb *TenseiBot) commandFunc {
+	return func(ctx *commandContext) {
+		if tb.isTwitchCommandOnCD(ctx) {
 			return
 		}
-		parts := strings.Split(m.Content, " ")
-		if len(parts) < 3 {
+		tb.Twitch.discordGetUserTwitchID(ctx, ctx.str("username"))
+	}
+}
+
+func discordTwitchName(tb *TenseiBot) commandFunc {
+	return func(ctx *commandContext) {
+		if tb.isTwitchCommandOnCD(ctx) {
+			return
+		}
+		tb.Twitch.discordGetUserTwitchName(ctx, ctx.str("id"))
+	}
+}
+
+func discordTwitchAdd(tb *TenseiBot) commandFunc {
+	return func(ctx *commandContext) {
+		name := ctx.str("streamer")
+		channelID := ctx.str("channel")
+		// the channel is checked first, a streamer without alerts would be tracked for nothing
+		if err := guildChannel(ctx.s, ctx.guildID, channelID); err != nil {
+			ctx.error("%v", err)
 			return
 		}
-		switch parts[1] {
-		case "id":
-			// get member id
-			if tb.isDiscordCommandOnCD(command, m.ChannelID, member, *set.TwitchCooldown, set) {
-				log.Debugf("[COMMAND] %s is on cd", command)
+		tb.Twitch.TwitchStreamerMutex.RLock()
+		tracked := tb.Twitch.trackedStreamer(name) != nil
+		tb.Twitch.TwitchStreamerMutex.RUnlock()
+
+		// the lookup can wait for the helix rate limit, it is made without the lock
+		var found *TwitchStreamer
+		if !tracked {
+			users, err := tb.Twitch.GetUsers(priorityUser, nil, []string{name})
+			if err != nil {
+				log.Warn(err)
+				ctx.notice("failed looking up twitch user %s", name)
 				return
 			}
-			tb.Twitch.discordGetUserTwitchID(s, m, parts[2])
-		case "name":
-			// get member name
-			if tb.isDiscordCommandOnCD(command, m.ChannelID, member, *set.TwitchCooldown, set) {
-				log.Debugf("[COMMAND] %s is on cd", command)
+			if len(users) < 1 {
+				ctx.error("couldn't find twitch user %s", name)
 				return
 			}
-			tb.Twitch.discordGetUserTwitchName(s, m, parts[2])
-		case "add":
-			// add stuff
-			if !isServerAdmin(set, member) {
+			found = &TwitchStreamer{
+				Name:            strings.ToLower(users[0].DisplayName),
+				ChannelID:       users[0].ID,
+				ProfileImageURL: users[0].ProfileImageURL,
+			}
+		}
+
+		tb.Twitch.TwitchStreamerMutex.Lock()
+		defer tb.Twitch.TwitchStreamerMutex.Unlock()
+		streamer := tb.Twitch.trackedStreamer(name)
+		if streamer == nil {
+			if found == nil {
+				ctx.notice("%s was removed in the meantime, try again", name)
 				return
 			}
-			streamer, err := tb.GetStreamerWithName(parts[2])
-			if err != nil {
-				log.Warn(err)
-				users, err := tb.Twitch.GetUsers(nil, []string{parts[2]})
-				if err != nil {
-					log.Warn(err)
-					return
+			streamer = found
+			tb.AddStreamer(streamer)
+			tb.Twitch.TwitchStreamers = append(tb.Twitch.TwitchStreamers, streamer)
+			go tb.syncEventSubSubscriptions()
+		}
+		if hasAlertSubscription(streamer, channelID) {
+			ctx.notice("%s alerts are already posted in <#%s>", streamer.Name, channelID)
+			return
+		}
+		streamer.TwitchAlertSubscriptions = append(streamer.TwitchAlertSubscriptions, &TwitchAlertSubscription{
+			AlertSubscription: AlertSubscription{
+				ChannelID: channelID,
+				GuildID:   ctx.guildID,
+			},
+		})
+		tb.UpdateStreamer(streamer)
+		ctx.success("added %s alert to channel <#%s>", streamer.Name, channelID)
+	}
+}
+
+func discordTwitchRemove(tb *TenseiBot) commandFunc {
+	return func(ctx *commandContext) {
+		name := ctx.str("streamer")
+		channelID := ctx.str("channel")
+		tb.Twitch.TwitchStreamerMutex.Lock()
+		streamer := tb.Twitch.trackedStreamer(name)
+		if streamer == nil {
+			tb.Twitch.TwitchStreamerMutex.Unlock()
+			ctx.error("%s isn't tracked", name)
+			return
+		}
+
+		var kept []*TwitchAlertSubscription
+		var removed []LiveAlert
+		var mentions []string
+		for _, alert := range streamer.TwitchAlertSubscriptions {
+			if alert.GuildID != ctx.guildID || (channelID != "" && alert.ChannelID != channelID) {
+				kept = append(kept, alert)
+				continue
+			}
+			tb.RemoveAlertSubscription(alert)
+			removed = append(removed, alert)
+			mentions = append(mentions, fmt.Sprintf("<#%s>", alert.ChannelID))
+		}
+		if len(removed) < 1 {
+			tb.Twitch.TwitchStreamerMutex.Unlock()
+			ctx.error("there are no %s alerts to remove", streamer.Name)
+			return
+		}
+		streamer.TwitchAlertSubscriptions = kept
+		unbridge := !streamer.hasGuildAlert(ctx.guildID)
+		// stop tracking streamers nobody is subscribed to, the removed alerts still hold
+		// their live embeds, they are ended like the job would unless a job runs right now
+		untrack := len(kept) < 1
+		ending := untrack && streamer.IsLive() && !streamer.job
+		if untrack {
+			tb.Twitch.untrackStreamer(streamer)
+		}
+		if ending {
+			streamer.job = true
+			streamer.StreamEndTime = time.Now().UTC()
+		}
+		tb.Twitch.TwitchStreamerMutex.Unlock()
+
+		if ending {
+			// untracked streamers aren't saved, the deleted alerts aren't stored again
+			tb.endLiveAlerts(tb.twitchSource(), streamer, removed)
+		}
+		if untrack {
+			// after the session was ended, ending it would store it again
+			tb.RemoveStreamer(streamer)
+			tb.removeChatBridges(streamer, "")
+			go tb.syncEventSubSubscriptions()
+			log.Infof("[TWITCH] stopped tracking streamer %s", streamer.Name)
+		} else if unbridge {
+			// the chat bridges of a guild belong to its alerts
+			tb.removeChatBridges(streamer, ctx.guildID)
+		}
+		ctx.success("removed %s alert from %s", streamer.Name, strings.Join(mentions, ", "))
+	}
+}
+
+const twitchListPerPage = 10
+
+func discordTwitchList(tb *TenseiBot) commandFunc {
+	return func(ctx *commandContext) {
+		var fields []*discordgo.MessageEmbedField
+		tf := tb.guildTimeFormat(ctx.guildID)
+		tb.Twitch.TwitchStreamerMutex.RLock()
+		for _, streamer := range tb.Twitch.TwitchStreamers {
+			for _, alert := range streamer.TwitchAlertSubscriptions {
+				if alert.GuildID != ctx.guildID {
+					continue
 				}
-				user := users[0]
-				streamer = &TwitchStreamer{
-					Name:            strings.ToLower(user.DisplayName),
-					ChannelID:       user.ID,
-					ProfileImageURL: user.ProfileImageURL,
+				fields = append(fields, &discordgo.MessageEmbedField{
+					Name:  fmt.Sprintf("%s in #%s", streamer.Name, channelName(ctx.s, alert.ChannelID)),
+					Value: liveStatus(&streamer.LiveState, tf),
+				})
+			}
+		}
+		tb.Twitch.TwitchStreamerMutex.RUnlock()
+		fields = append(fields, tb.groupSubscriptionFields(ctx.s, ctx.guildID)...)
+		fields = append(fields, tb.chatBridgeFields(ctx.s, ctx.guildID)...)
+
+		if len(fields) < 1 {
+			ctx.error("there are no twitch alerts on this server")
+			return
+		}
+
+		var pages []*discordgo.MessageEmbed
+		for len(fields) > 0 {
+			n := twitchListPerPage
+			if n > len(fields) {
+				n = len(fields)
+			}
+			pages = append(pages, &discordgo.MessageEmbed{
+				Title:  "Twitch alerts",
+				Fields: fields[:n],
+			})
+			fields = fields[n:]
+		}
+		ctx.replyPages(pages)
+	}
+}
+
+const (
+	twitchHistoryPerPage = 5
+	twitchHistoryLimit   = 25
+)
+
+func discordTwitchHistory(tb *TenseiBot) commandFunc {
+	return func(ctx *commandContext) {
+		name := ctx.str("streamer")
+		tb.Twitch.TwitchStreamerMutex.RLock()
+		streamer := tb.Twitch.trackedStreamer(name)
+		tb.Twitch.TwitchStreamerMutex.RUnlock()
+		if streamer == nil {
+			ctx.error("%s isn't tracked", name)
+			return
+		}
+
+		sessions := tb.GetStreamSessions(streamer, twitchHistoryLimit)
+		if len(sessions) < 1 {
+			ctx.error("there are no recorded streams of %s", streamer.Name)
+			return
+		}
+
+		tf := tb.guildTimeFormat(ctx.guildID)
+		var fields []*discordgo.MessageEmbedField
+		for _, session := range sessions {
+			length := "live now"
+			if !session.EndTime.IsZero() {
+				length = humanizeDuration(session.EndTime.Sub(session.StartTime))
+			}
+			var sb strings.Builder
+			sb.WriteString(fmt.Sprintf("**Started:** %s\n", tf.format(session.StartTime)))
+			sb.WriteString(fmt.Sprintf("**Length:** %s\n", length))
+			sb.WriteString(fmt.Sprintf("**Viewers:** %s", session.viewerSummary()))
+			if categories := session.CategoryList(); len(categories) > 0 {
+				sb.WriteString(fmt.Sprintf("\n**Categories:** %s", escapeDiscord(strings.Join(categories, ", "))))
+			}
+			title := "untitled stream"
+			if titles := session.TitleList(); len(titles) > 0 {
+				title = titles[len(titles)-1]
+			}
+			fields = append(fields, &discordgo.MessageEmbedField{
+				Name:  title,
+				Value: sb.String(),
+			})
+		}
+
+		var pages []*discordgo.MessageEmbed
+		for len(fields) > 0 {
+			n := twitchHistoryPerPage
+			if n > len(fields) {
+				n = len(fields)
+			}
+			pages = append(pages, &discordgo.MessageEmbed{
+				Title:  fmt.Sprintf("Recent streams of %s", streamer.Name),
+				URL:    fmt.Sprintf("https://twitch.tv/%s", streamer.Name),
+				Fields: fields[:n],
+			})
+			fields = fields[n:]
+		}
+		ctx.replyPages(pages)
+	}
+}
+
+var alertSettings = []string{"message", "mention", "color", "thumbnail", "changes", "timezone", "dateformat"}
+
+func completeAlertSettings(value string) []string {
+	var settings []string
+	for _, setting := range alertSettings {
+		if strings.HasPrefix(setting, strings.ToLower(value)) {
+			settings = append(settings, setting)
+		}
+	}
+	return settings
+}
+
+func discordTwitchConfig(tb *TenseiBot) commandFunc {
+	return func(ctx *commandContext) {
+		name := ctx.str("streamer")
+		channelID := ctx.str("channel")
+		value := ctx.str("value")
+		tb.Twitch.TwitchStreamerMutex.Lock()
+		defer tb.Twitch.TwitchStreamerMutex.Unlock()
+
+		var alert *TwitchAlertSubscription
+		if streamer := tb.Twitch.trackedStreamer(name); streamer != nil {
+			for _, a := range streamer.TwitchAlertSubscriptions {
+				if a.ChannelID == channelID && a.GuildID == ctx.guildID {
+					alert = a
 				}
-				tb.AddStreamer(streamer)
-				tb.Twitch.RateLimitMutex.Lock()
-				tb.Twitch.TwitchStreamers = append(tb.Twitch.TwitchStreamers, streamer)
-				tb.Twitch.RateLimitMutex.Unlock()
 			}
-			if !strings.HasPrefix(parts[3], "<#") {
-				return
+		}
+		if alert == nil {
+			ctx.error("there is no %s alert in <#%s>", name, channelID)
+			return
+		}
+
+		switch strings.ToLower(ctx.str("setting")) {
+		case "":
+			ctx.reply(alertConfigEmbed(name, alert))
+			return
+		case "message":
+			// no value resets to only sending the embed
+			alert.Message = value
+		case "mention":
+			switch strings.ToLower(value) {
+			case "", "none":
+				alert.MentionRoleID = ""
+			case mentionEveryone, "@everyone":
+				alert.MentionRoleID = mentionEveryone
+			default:
+				roleID, err := parseMention(roleMentionRe, value, "role")
+				if err != nil {
+					ctx.error("%v, use a role, everyone or none", err)
+					return
+				}
+				alert.MentionRoleID = roleID
 			}
-			channelID := parts[3][2 : len(parts[3])-1]
-			if hasAlertSubscription(streamer, channelID) {
+		case "color", "colour":
+			color, err := strconv.ParseInt(strings.TrimPrefix(value, "#"), 16, 32)
+			if err != nil || color < 0 || color > 0xFFFFFF {
+				ctx.error("'%s' is not a hex colour like #9146ff", value)
 				return
 			}
-			channel, err := s.Channel(channelID)
-			if err != nil {
-				DiscordSendErrorMessageEmbed(s, m.ChannelID, "couldn't find channel with id: %s, err: %v", channelID, err)
+			c := int(color)
+			alert.Color = &c
+		case "thumbnail":
+			switch strings.ToLower(value) {
+			case "on", "show", "true":
+				alert.HideThumbnail = false
+			case "off", "hide", "false":
+				alert.HideThumbnail = true
+			default:
+				ctx.error("use on or off")
 				return
 			}
-			// make sure the channel is on the same server
-			if channel.GuildID == m.GuildID {
-				streamer.TwitchAlertSubscriptions = append(streamer.TwitchAlertSubscriptions, &TwitchAlertSubscription{
-					ChannelID: channelID,
-					GuildID:   m.GuildID,
-				})
-				tb.UpdateStreamer(streamer)
-				DiscordSendSuccessMessageEmbed(s, m.ChannelID, "added %s alert to channel %s", streamer.Name, channel.Mention())
-			} else {
-				DiscordSendErrorMessageEmbed(s, m.ChannelID, "can't add channel on other server")
-			}
-		case "remove":
-			// remove stuff
-			if !isServerAdmin(set, member) {
+		case "changes":
+			switch strings.ToLower(value) {
+			case "on", "true":
+				alert.NotifyChanges = true
+			case "off", "false":
+				alert.NotifyChanges = false
+			default:
+				ctx.error("use on or off")
 				return
 			}
-		case "online":
-			streamer := parts[2]
-			streams, err := tb.Twitch.getStream(nil, []string{streamer})
-			if err != nil {
-				log.Warn(err)
+		case "timezone":
+			// no value uses the server timezone again
+			if _, err := time.LoadLocation(value); err != nil {
+				ctx.error("unknown timezone '%s', use a name like Europe/Berlin", value)
 				return
 			}
-			if len(streams) > 0 {
-				DiscordSendSuccessMessageEmbed(s, m.ChannelID, fmt.Sprintf("%s stream is currently %v", streamer, isStreaming(&streams[0])))
-			} else {
-				DiscordSendErrorMessageEmbed(s, m.ChannelID, fmt.Sprintf("%s stream is currently offline", streamer))
-			}
+			alert.Timezone = value
+		case "dateformat":
+			alert.DateFormat = value
+		default:
+			ctx.error("unknown setting '%s', use one of %s", ctx.str("setting"), strings.Join(alertSettings, ", "))
+			return
 		}
+
+		tb.UpdateAlertSubscription(alert)
+		ctx.reply(alertConfigEmbed(name, alert))
+	}
+}
+
+// alertConfigEmbed shows the settings of an alert subscription
+func alertConfigEmbed(name string, alert *TwitchAlertSubscription) *discordgo.MessageEmbed {
+	message := alert.Message
+	if message == "" {
+		message = "none"
+	}
+	mention := "none"
+	switch alert.MentionRoleID {
+	case "":
+	case mentionEveryone:
+		mention = "@everyone"
+	default:
+		mention = fmt.Sprintf("<@&%s>", alert.MentionRoleID)
+	}
+	color := 0xFF0000
+	if alert.Color != nil {
+		color = *alert.Color
+	}
+	thumbnail := "on"
+	if alert.HideThumbnail {
+		thumbnail = "off"
+	}
+	changes := "off"
+	if alert.NotifyChanges {
+		changes = "on"
+	}
+	timezone := alert.Timezone
+	if timezone == "" {
+		timezone = "server default"
+	}
+	dateFormat := alert.DateFormat
+	if dateFormat == "" {
+		dateFormat = "server default"
+	}
+
+	return &discordgo.MessageEmbed{
+		Description: fmt.Sprintf("**%s** alert in <#%s>", name, alert.ChannelID),
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "Message", Value: message},
+			{Name: "Mention", Value: mention, Inline: true},
+			{Name: "Colour", Value: fmt.Sprintf("#%06x", color), Inline: true},
+			{Name: "Thumbnail", Value: thumbnail, Inline: true},
+			{Name: "Change notices", Value: changes, Inline: true},
+			{Name: "Timezone", Value: timezone, Inline: true},
+			{Name: "Date format", Value: dateFormat, Inline: true},
+		},
+		Color: color,
 	}
 }
 
-func (tt *TenseiTwitch) discordGetUserTwitchID(s *discordgo.Session, m *discordgo.MessageCreate, name string) {
-	users, err := tt.GetUsers(nil, []string{name})
+// liveStatus describes if the channel is live and when the last stream was
+func liveStatus(state *LiveState, tf timeFormat) string {
+	if state.IsLive() {
+		return fmt.Sprintf("**live** for %s", humanizeDuration(time.Now().UTC().Sub(state.StreamStartTime)))
+	}
+	if state.StreamStartTime.IsZero() {
+		return "offline, no streams yet"
+	}
+	return fmt.Sprintf("offline, last stream %s - %s", tf.format(state.StreamStartTime), tf.format(state.StreamEndTime))
+}
+
+func discordTwitchOnline(tb *TenseiBot) commandFunc {
+	return func(ctx *commandContext) {
+		if tb.isTwitchCommandOnCD(ctx) {
+			return
+		}
+		streamer := ctx.str("streamer")
+		streams, err := tb.Twitch.getStream(priorityUser, nil, []string{streamer})
+		if err != nil {
+			log.Warn(err)
+			ctx.notice("failed checking stream of %s", streamer)
+			return
+		}
+		if len(streams) > 0 {
+			ctx.success("%s stream is currently %v", streamer, isStreaming(&streams[0]))
+		} else {
+			ctx.error("%s stream is currently offline", streamer)
+		}
+	}
+}
+func (tt *TenseiTwitch) discordGetUserTwitchID(ctx *commandContext, name string) {
+	users, err := tt.GetUsers(priorityUser, nil, []string{name})
 	if err != nil {
 		log.Warn(err)
 	}
 	if len(users) < 1 {
+		ctx.notice("couldn't find twitch user %s", name)
 		return
 	}
 	user := users[0]
-	_, _ = s.ChannelMessageSendEmbed(m.ChannelID, &discordgo.MessageEmbed{
+	ctx.reply(&discordgo.MessageEmbed{
 		Title: user.DisplayName,
 		URL:   fmt.Sprintf("https://twitch.tv/%s", user.DisplayName),
 		Thumbnail: &discordgo.MessageEmbedThumbnail{
@@ -388,17 +1256,19 @@ func (tt *TenseiTwitch) discordGetUserTwitchID(s *discordgo.Session, m *discordg
 	})
 }
 
-func (tt *TenseiTwitch) discordGetUserTwitchName(s *discordgo.Session, m *discordgo.MessageCreate, id string) {
-	users, err := tt.GetUsers([]string{id}, nil)
+func (tt *TenseiTwitch) discordGetUserTwitchName(ctx *commandContext, id string) {
+	users, err := tt.GetUsers(priorityUser, []string{id}, nil)
 	if err != nil {
 		log.Warn(err)
+		ctx.notice("failed looking up twitch user %s", id)
 		return
 	}
 	if len(users) < 1 {
+		ctx.notice("couldn't find twitch user %s", id)
 		return
 	}
 	user := users[0]
-	_, _ = s.ChannelMessageSendEmbed(m.ChannelID, &discordgo.MessageEmbed{
+	ctx.reply(&discordgo.MessageEmbed{
 		Title: user.ID,
 		URL:   fmt.Sprintf("https://twitch.tv/%s", user.DisplayName),
 		Thumbnail: &discordgo.MessageEmbedThumbnail{
@@ -413,25 +1283,109 @@ func (tt *TenseiTwitch) discordGetUserTwitchName(s *discordgo.Session, m *discor
 	})
 }
 
-func discordTenseiBot(tb *TenseiBot) func(s *discordgo.Session, m *discordgo.MessageCreate, command string) {
-	return func(s *discordgo.Session, m *discordgo.MessageCreate, command string) {
-		guild, _ := s.Guild(m.GuildID)
-		if m.Author.ID != guild.OwnerID && !tb.isOwner(m.Author.ID) {
+func discordSetAdminRole(tb *TenseiBot) commandFunc {
+	return func(ctx *commandContext) {
+		set := tb.GetGuildSettingsFromDB(ctx.guildID)
+		role := ctx.str("role")
+		ctx.reply(&discordgo.MessageEmbed{
+			Description: fmt.Sprintf("updating admin_role_id from '%s' to '%s'", set.AdminRoleID, role),
+		})
+		set.AdminRoleID = role
+		tb.UpdateGuildSettings(set)
+	}
+}
+
+func discordSetPrefix(tb *TenseiBot) commandFunc {
+	return func(ctx *commandContext) {
+		prefix := ctx.str("prefix")
+		if len(prefix) > 5 {
+			ctx.error("the prefix can't be longer than 5 characters")
 			return
 		}
-		set := tb.GetGuildSettingsFromDB(m.GuildID)
-		args := strings.Split(m.Content, " ")[1:]
-		switch args[0] {
-		case "set":
-			switch args[1] {
-			case "adminrole":
-				_, _ = s.ChannelMessageSendEmbed(m.ChannelID, &discordgo.MessageEmbed{
-					Description: fmt.Sprintf("updating admin_role_id from '%s' to '%s'", set.AdminRoleID, args[2]),
-				})
-				set.AdminRoleID = args[2]
-				tb.UpdateGuildSettings(set)
-				return
-			}
+		set := tb.GetGuildSettingsFromDB(ctx.guildID)
+		ctx.reply(&discordgo.MessageEmbed{
+			Description: fmt.Sprintf("updating prefix from '%s' to '%s'", tb.guildPrefix(ctx.guildID), prefix),
+		})
+		set.Prefix = prefix
+		tb.UpdateGuildSettings(set)
+		tb.Discord.setGuildPrefix(ctx.guildID, prefix)
+	}
+}
+
+func discordSetTimezone(tb *TenseiBot) commandFunc {
+	return func(ctx *commandContext) {
+		timezone := ctx.str("timezone")
+		if _, err := time.LoadLocation(timezone); err != nil {
+			ctx.error("unknown timezone '%s', use a name like Europe/Berlin", timezone)
+			return
+		}
+		set := tb.GetGuildSettingsFromDB(ctx.guildID)
+		ctx.reply(&discordgo.MessageEmbed{
+			Description: fmt.Sprintf("updating timezone from '%s' to '%s'", set.Timezone, timezone),
+		})
+		set.Timezone = timezone
+		tb.UpdateGuildSettings(set)
+	}
+}
+
+func discordSetDateFormat(tb *TenseiBot) commandFunc {
+	return func(ctx *commandContext) {
+		format := ctx.str("format")
+		set := tb.GetGuildSettingsFromDB(ctx.guildID)
+		example := newTimeFormat(set.Timezone, format).format(time.Now())
+		ctx.reply(&discordgo.MessageEmbed{
+			Description: fmt.Sprintf("updating date format from '%s' to '%s', dates will look like %s", set.DateFormat, format, example),
+		})
+		set.DateFormat = format
+		tb.UpdateGuildSettings(set)
+	}
+}
+
+func discordSetTranslator(tb *TenseiBot) commandFunc {
+	return func(ctx *commandContext) {
+		translator := strings.ToLower(ctx.str("translator"))
+		if translator != translatorAuto && !contains(tb.Translate.names(), translator) {
+			ctx.error("unknown translator '%s', use %s", translator, strings.Join(append([]string{translatorAuto}, tb.Translate.names()...), ", "))
+			return
+		}
+		set := tb.GetGuildSettingsFromDB(ctx.guildID)
+		previous := set.Translator
+		if previous == "" {
+			previous = translatorAuto
+		}
+		ctx.reply(&discordgo.MessageEmbed{
+			Description: fmt.Sprintf("updating translator from '%s' to '%s'", previous, translator),
+		})
+		if translator == translatorAuto {
+			translator = ""
+		}
+		set.Translator = translator
+		tb.UpdateGuildSettings(set)
+	}
+}
+
+func discordSetFlags(tb *TenseiBot) commandFunc {
+	return func(ctx *commandContext) {
+		mode := strings.ToLower(ctx.str("mode"))
+		if mode != "on" && mode != "off" {
+			ctx.error("unknown mode '%s', use on or off", mode)
+			return
+		}
+		set := tb.GetGuildSettingsFromDB(ctx.guildID)
+		ctx.reply(&discordgo.MessageEmbed{
+			Description: fmt.Sprintf("turning flag reaction translations %s", mode),
+		})
+		set.FlagTranslate = mode == "on"
+		tb.UpdateGuildSettings(set)
+	}
+}
+
+func completeOnOff(value string) []string {
+	var modes []string
+	for _, mode := range []string{"on", "off"} {
+		if strings.HasPrefix(mode, strings.ToLower(value)) {
+			modes = append(modes, mode)
 		}
 	}
+	return modes
 }
@@ -19,8 +19,7 @@ func (tb *TenseiBot) GuildCreate(s *discordgo.Session, m *discordgo.GuildCreate)
 func (tb *TenseiBot) GuildMemberAdd(s *discordgo.Session, m *discordgo.GuildMemberAdd) {
 	// add guild to db
 	guild, _ := s.Guild(m.GuildID)
-	joined, _ := m.JoinedAt.Parse()
-	log.Infof("[MEMBER_JOIN] guild: %s(%s), member: %s(%s), account_created: %s, account_age: %s", guild.Name, guild.ID, m.User.String(), m.User.ID, m.JoinedAt, time.Since(joined))
+	log.Infof("[MEMBER_JOIN] guild: %s(%s), member: %s(%s), account_created: %s, account_age: %s", guild.Name, guild.ID, m.User.String(), m.User.ID, m.JoinedAt, time.Since(m.JoinedAt))
 }
 
 // GuildMemberRemove handles guild member remove events
@@ -37,8 +36,24 @@ func (tb *TenseiBot) GuildMemberUpdate(s *discordgo.Session, m *discordgo.GuildM
 	log.Infof("[MEMBER_UPDATE] guild: %s(%s), member: %s(%s)", guild.Name, guild.ID, m.User.String(), m.User.ID)
 }
 
+// MessageCreate handles message create events
+func (tb *TenseiBot) MessageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
+	go tb.mirrorMessage(s, m.Message)
+}
+
+// MessageUpdate handles message edit events
+func (tb *TenseiBot) MessageUpdate(s *discordgo.Session, m *discordgo.MessageUpdate) {
+	// updates without edit timestamp only add link previews
+	if m.EditedTimestamp == nil {
+		return
+	}
+	go tb.mirrorEdit(s, m.Message)
+}
+
 // MessageDelete handles message delete events
 func (tb *TenseiBot) MessageDelete(s *discordgo.Session, m *discordgo.MessageDelete) {
+	go tb.mirrorDelete(s, m.ID)
+
 	// add guild to db
 	guild, _ := s.Guild(m.GuildID)
 	tb.Discord.msgCacheMutex.Lock()
@@ -53,3 +68,12 @@ func (tb *TenseiBot) MessageDelete(s *discordgo.Session, m *discordgo.MessageDel
 	}
 	log.Infof("[MESSAGE_DELETE] guild: %s(%s), message out of cache, rip", guild.Name, guild.ID)
 }
+
+// MessageReactionAdd handles reaction add events
+func (tb *TenseiBot) MessageReactionAdd(s *discordgo.Session, r *discordgo.MessageReactionAdd) {
+	if r.UserID == s.State.User.ID {
+		return
+	}
+	tb.Discord.flipPage(r.MessageReaction)
+	go tb.translateReaction(s, r)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const helpCommandsPerPage = 8
+
+func discordHelp(tb *TenseiBot) commandFunc {
+	return func(ctx *commandContext) {
+		if name := ctx.str("command"); name != "" {
+			tb.Discord.discordHelpCommand(ctx, name)
+			return
+		}
+
+		var fields []*discordgo.MessageEmbedField
+		for _, root := range tb.Discord.commandList {
+			root.walk(ctx.prefix+root.name, permEveryone, func(c *command, path string, perm permLevel) {
+				if perm > ctx.level {
+					return
+				}
+				fields = append(fields, &discordgo.MessageEmbedField{
+					Name:  c.usage(path),
+					Value: c.description,
+				})
+			})
+		}
+
+		var pages []*discordgo.MessageEmbed
+		for len(fields) > 0 {
+			n := helpCommandsPerPage
+			if n > len(fields) {
+				n = len(fields)
+			}
+			pages = append(pages, &discordgo.MessageEmbed{
+				Title:       "Commands",
+				Description: fmt.Sprintf("use `%shelp <command>` for details", ctx.prefix),
+				Fields:      fields[:n],
+			})
+			fields = fields[n:]
+		}
+		ctx.replyPages(pages)
+	}
+}
+
+// discordHelpCommand sends details about a single command or subcommand
+func (td *TenseiDiscord) discordHelpCommand(ctx *commandContext, name string) {
+	tokens := tokenize(strings.TrimPrefix(name, ctx.prefix))
+	if len(tokens) < 1 {
+		ctx.error("unknown command `%s`", name)
+		return
+	}
+	c, ok := td.commands[strings.ToLower(tokens[0].value)]
+	if !ok || c.perm > ctx.level {
+		ctx.error("unknown command `%s`", tokens[0].value)
+		return
+	}
+	path := ctx.prefix + c.name
+	for _, t := range tokens[1:] {
+		sub := c.subcommand(t.value)
+		if sub == nil || sub.perm > ctx.level {
+			break
+		}
+		c = sub
+		path += " " + sub.name
+	}
+
+	fields := []*discordgo.MessageEmbedField{
+		{
+			Name:  "Usage",
+			Value: fmt.Sprintf("`%s`", c.usage(path)),
+		},
+	}
+	if len(c.aliases) > 0 {
+		fields = append(fields, &discordgo.MessageEmbedField{
+			Name:  "Aliases",
+			Value: strings.Join(c.aliases, ", "),
+		})
+	}
+	if c.perm > permEveryone {
+		fields = append(fields, &discordgo.MessageEmbedField{
+			Name:  "Permission",
+			Value: c.perm.String(),
+		})
+	}
+	if len(c.examples) > 0 {
+		var sb strings.Builder
+		for _, e := range c.examples {
+			sb.WriteString(fmt.Sprintf("`%s%s`\n", ctx.prefix, e))
+		}
+		fields = append(fields, &discordgo.MessageEmbedField{
+			Name:  "Examples",
+			Value: sb.String(),
+		})
+	}
+	var subs []string
+	for _, sub := range c.subcommands {
+		if sub.perm > ctx.level {
+			continue
+		}
+		subs = append(subs, fmt.Sprintf("`%s` %s", sub.usage(path+" "+sub.name), sub.description))
+	}
+	if len(subs) > 0 {
+		fields = append(fields, &discordgo.MessageEmbedField{
+			Name:  "Subcommands",
+			Value: strings.Join(subs, "\n"),
+		})
+	}
+
+	ctx.reply(&discordgo.MessageEmbed{
+		Title:       path,
+		Description: c.description,
+		Fields:      fields,
+	})
+}
+
+// writeFeatures rewrites the command table in file from the command definitions
+func (td *TenseiDiscord) writeFeatures(file string) error {
+	rows := [][2]string{{"Command", "Output"}}
+	for _, root := range td.commandList {
+		root.walk(td.prefix+root.name, permEveryone, func(c *command, path string, perm permLevel) {
+			output := c.description
+			if perm > permEveryone {
+				output += fmt.Sprintf(" (%s only)", perm)
+			}
+			rows = append(rows, [2]string{escapeMarkdown(c.usage(path)), output})
+		})
+	}
+
+	var width [2]int
+	for _, row := range rows {
+		for i, col := range row {
+			if len(col) > width[i] {
+				width[i] = len(col)
+			}
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString("## Commands\n\n")
+	sb.WriteString("variables in `[brackets]` are optional, every command is also available as a slash command\n\n")
+	for i, row := range rows {
+		sb.WriteString(fmt.Sprintf("| %-*s | %-*s |\n", width[0], row[0], width[1], row[1]))
+		if i == 0 {
+			sb.WriteString(fmt.Sprintf("| %s | :%s |\n", strings.Repeat("-", width[0]), strings.Repeat("-", width[1]-1)))
+		}
+	}
+	return ioutil.WriteFile(file, []byte(sb.String()), 0644)
+}
+
+func escapeMarkdown(s string) string {
+	return strings.NewReplacer("<", "\\<", ">", "\\>", "|", "\\|").Replace(s)
+}
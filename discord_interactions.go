@@ -0,0 +1,290 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxAutocompleteChoices discord rejects responses with more choices
+const maxAutocompleteChoices = 25
+
+// RegisterApplicationCommands registers every command as a global slash command
+func (tb *TenseiBot) RegisterApplicationCommands() {
+	var commands []*discordgo.ApplicationCommand
+	dmPermission := false
+	for _, c := range tb.Discord.commandList {
+		commands = append(commands, &discordgo.ApplicationCommand{
+			Name:         c.name,
+			Description:  c.shortDescription(),
+			Options:      c.applicationCommandOptions(),
+			DMPermission: &dmPermission,
+		})
+	}
+
+	_, err := tb.Discord.c.ApplicationCommandBulkOverwrite(tb.Discord.c.State.User.ID, "", commands)
+	if err != nil {
+		log.Errorf("[DISCORD] failed registering application commands: %v", err)
+		return
+	}
+	log.Infof("[DISCORD] registered %d application commands", len(commands))
+}
+
+func (c *command) shortDescription() string {
+	if c.description == "" {
+		return c.name
+	}
+	if len(c.description) > 100 {
+		return c.description[:97] + "..."
+	}
+	return c.description
+}
+
+func (c *command) applicationCommandOptions() []*discordgo.ApplicationCommandOption {
+	var options []*discordgo.ApplicationCommandOption
+	if len(c.subcommands) > 0 && c.f != nil {
+		options = append(options, &discordgo.ApplicationCommandOption{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        c.slashName,
+			Description: c.shortDescription(),
+			Options:     c.argOptions(),
+		})
+	}
+	for _, sub := range c.subcommands {
+		typ := discordgo.ApplicationCommandOptionSubCommand
+		if len(sub.subcommands) > 0 {
+			typ = discordgo.ApplicationCommandOptionSubCommandGroup
+		}
+		options = append(options, &discordgo.ApplicationCommandOption{
+			Type:        typ,
+			Name:        sub.name,
+			Description: sub.shortDescription(),
+			Options:     sub.applicationCommandOptions(),
+		})
+	}
+	if len(c.subcommands) > 0 {
+		return options
+	}
+	return append(options, c.argOptions()...)
+}
+
+func (c *command) argOptions() []*discordgo.ApplicationCommandOption {
+	var options []*discordgo.ApplicationCommandOption
+	for _, arg := range c.args {
+		option := &discordgo.ApplicationCommandOption{
+			Type:         discordgo.ApplicationCommandOptionString,
+			Name:         arg.name,
+			Description:  arg.name,
+			Required:     !arg.optional,
+			Autocomplete: arg.complete != nil,
+		}
+		switch arg.typ {
+		case argInt:
+			option.Type = discordgo.ApplicationCommandOptionInteger
+		case argUser:
+			option.Type = discordgo.ApplicationCommandOptionUser
+		case argRole:
+			option.Type = discordgo.ApplicationCommandOptionRole
+		case argChannel:
+			option.Type = discordgo.ApplicationCommandOptionChannel
+			option.ChannelTypes = []discordgo.ChannelType{discordgo.ChannelTypeGuildText, discordgo.ChannelTypeGuildNews}
+		}
+		options = append(options, option)
+	}
+	return options
+}
+
+// InteractionCreate handles slash commands and their autocomplete requests
+func (tb *TenseiBot) InteractionCreate(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	switch i.Type {
+	case discordgo.InteractionApplicationCommand:
+		go tb.runInteraction(s, i)
+	case discordgo.InteractionApplicationCommandAutocomplete:
+		tb.autocomplete(s, i)
+	}
+}
+
+// resolveInteraction finds the command and the options of the leaf
+func (td *TenseiDiscord) resolveInteraction(data discordgo.ApplicationCommandInteractionData) (*command, []*command, []*discordgo.ApplicationCommandInteractionDataOption) {
+	root, ok := td.commands[data.Name]
+	if !ok {
+		return nil, nil, nil
+	}
+	cmd := root
+	path := []*command{root}
+	options := data.Options
+	for len(options) > 0 {
+		opt := options[0]
+		if opt.Type != discordgo.ApplicationCommandOptionSubCommand && opt.Type != discordgo.ApplicationCommandOptionSubCommandGroup {
+			break
+		}
+		sub := cmd.subcommand(opt.Name)
+		if sub == nil {
+			if cmd.f != nil && opt.Name == cmd.slashName {
+				options = opt.Options
+			}
+			break
+		}
+		cmd = sub
+		path = append(path, sub)
+		options = opt.Options
+	}
+	return cmd, path, options
+}
+
+func (tb *TenseiBot) runInteraction(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if i.Member == nil {
+		return
+	}
+	// a panicking command must not take the whole bot down
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("[COMMAND] /%s panicked: %v", i.ApplicationCommandData().Name, r)
+		}
+	}()
+	cmd, path, options := tb.Discord.resolveInteraction(i.ApplicationCommandData())
+	if cmd == nil || cmd.f == nil {
+		return
+	}
+
+	ctx := &commandContext{
+		td:        tb.Discord,
+		s:         s,
+		i:         i,
+		guildID:   i.GuildID,
+		channelID: i.ChannelID,
+		author:    i.Member.User,
+		member:    i.Member,
+		level:     tb.memberPermLevel(i.GuildID, i.Member),
+		prefix:    tb.guildPrefix(i.GuildID),
+		root:      path[0],
+		path:      "/" + path[0].name,
+		args:      make(map[string]interface{}),
+	}
+	for _, c := range path[1:] {
+		ctx.path += " " + c.name
+	}
+	log.Infof("[COMMAND] %s used in server: %s, user: %s(%s) ", ctx.path, i.GuildID, ctx.author.String(), ctx.author.ID)
+
+	for _, c := range path {
+		if !ctx.allowed(c) {
+			return
+		}
+	}
+
+	for _, opt := range options {
+		for _, arg := range cmd.args {
+			if arg.name != opt.Name {
+				continue
+			}
+			var v interface{}
+			var err error
+			switch arg.typ {
+			case argInt:
+				v = int(opt.IntValue())
+			case argUser, argRole, argChannel:
+				// user, role and channel options hold the id, StringValue panics on them
+				id, _ := opt.Value.(string)
+				v = id
+			case argText:
+				v = strings.TrimSpace(opt.StringValue())
+			default:
+				v, err = parseArg(arg.typ, opt.StringValue())
+			}
+			if err != nil {
+				ctx.error("invalid argument `%s`: %v\nusage: `%s`", arg.name, err, cmd.usage(ctx.path))
+				return
+			}
+			ctx.args[arg.name] = v
+		}
+	}
+
+	// discord fails interactions that aren't answered within 3 seconds, commands waiting
+	// for helix or the database would miss that
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	})
+	if err != nil {
+		log.Errorf("[DISCORD] error deferring response to %s, err: %v", ctx.path, err)
+		return
+	}
+	ctx.deferred = true
+
+	cmd.f(ctx)
+
+	// every interaction needs a response or discord shows it as failed
+	if !ctx.replied {
+		ctx.error("`%s` didn't return anything", ctx.path)
+	}
+}
+
+func (tb *TenseiBot) autocomplete(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	cmd, _, options := tb.Discord.resolveInteraction(i.ApplicationCommandData())
+	if cmd == nil {
+		return
+	}
+
+	var suggestions []string
+	for _, opt := range options {
+		if !opt.Focused {
+			continue
+		}
+		for _, arg := range cmd.args {
+			if arg.name == opt.Name && arg.complete != nil {
+				suggestions = arg.complete(opt.StringValue())
+			}
+		}
+	}
+
+	choices := make([]*discordgo.ApplicationCommandOptionChoice, 0, maxAutocompleteChoices)
+	for _, suggestion := range suggestions {
+		if len(choices) == maxAutocompleteChoices {
+			break
+		}
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{
+			Name:  suggestion,
+			Value: suggestion,
+		})
+	}
+
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionApplicationCommandAutocompleteResult,
+		Data: &discordgo.InteractionResponseData{
+			Choices: choices,
+		},
+	})
+	if err != nil {
+		log.Warnf("[DISCORD] failed sending autocomplete for %s: %v", cmd.name, err)
+	}
+}
+
+// completeLanguages suggests names of the languages the translators support,
+// for from:to pairs the target is completed
+func (tb *TenseiBot) completeLanguages(value string) []string {
+	var from string
+	if i := strings.Index(value, ":"); i >= 0 {
+		from, value = value[:i+1], value[i+1:]
+	}
+	var names []string
+	for _, l := range tb.Translate.languages {
+		if strings.HasPrefix(strings.ToLower(l.name), strings.ToLower(value)) || strings.EqualFold(l.code, value) {
+			names = append(names, from+l.name)
+		}
+	}
+	return names
+}
+
+// completeStreamers suggests names of the tracked streamers
+func (tb *TenseiBot) completeStreamers(value string) []string {
+	tb.Twitch.TwitchStreamerMutex.RLock()
+	defer tb.Twitch.TwitchStreamerMutex.RUnlock()
+
+	var names []string
+	for _, streamer := range tb.Twitch.TwitchStreamers {
+		if strings.HasPrefix(streamer.Name, strings.ToLower(value)) {
+			names = append(names, streamer.Name)
+		}
+	}
+	return names
+}
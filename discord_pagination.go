@@ -0,0 +1,80 @@
+package main
+
+import (
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	paginationPrev    = "⬅"
+	paginationNext    = "➡"
+	paginationTimeout = 5 * time.Minute
+)
+
+// paginatedMessage embed pages the user can flip through with reactions
+type paginatedMessage struct {
+	pages   []*discordgo.MessageEmbed
+	page    int
+	userID  string
+	expires time.Time
+}
+
+// addPagination lets the user flip through the pages of msg with reactions
+func (td *TenseiDiscord) addPagination(msg *discordgo.Message, userID string, pages []*discordgo.MessageEmbed) {
+	td.paginationsMutex.Lock()
+	td.paginations[msg.ID] = &paginatedMessage{
+		pages:   pages,
+		userID:  userID,
+		expires: time.Now().Add(paginationTimeout),
+	}
+	td.paginationsMutex.Unlock()
+
+	_ = td.c.MessageReactionAdd(msg.ChannelID, msg.ID, paginationPrev)
+	_ = td.c.MessageReactionAdd(msg.ChannelID, msg.ID, paginationNext)
+}
+
+// flipPage handles a reaction on a paginated message, returns false if the message isn't paginated
+func (td *TenseiDiscord) flipPage(r *discordgo.MessageReaction) bool {
+	td.paginationsMutex.Lock()
+	defer td.paginationsMutex.Unlock()
+
+	for id, p := range td.paginations {
+		if time.Now().After(p.expires) {
+			delete(td.paginations, id)
+		}
+	}
+
+	p, ok := td.paginations[r.MessageID]
+	if !ok {
+		return false
+	}
+	if r.UserID != p.userID {
+		return true
+	}
+
+	switch r.Emoji.Name {
+	case paginationPrev:
+		if p.page == 0 {
+			return true
+		}
+		p.page--
+	case paginationNext:
+		if p.page == len(p.pages)-1 {
+			return true
+		}
+		p.page++
+	default:
+		return true
+	}
+	p.expires = time.Now().Add(paginationTimeout)
+
+	_, err := td.c.ChannelMessageEditEmbed(r.ChannelID, r.MessageID, p.pages[p.page])
+	if err != nil {
+		log.Errorf("[DISCORD] error flipping page of message %s, err: %v", r.MessageID, err)
+	}
+	// needs manage messages, the page flips either way
+	_ = td.c.MessageReactionRemove(r.ChannelID, r.MessageID, r.Emoji.Name, r.UserID)
+	return true
+}
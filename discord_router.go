@@ -0,0 +1,462 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+)
+
+type argType int
+
+const (
+	// argWord a single word or a "quoted string"
+	argWord argType = iota
+	// argText everything left in the message
+	argText
+	argInt
+	argDuration
+	argUser
+	argRole
+	argChannel
+)
+
+var (
+	userMentionRe    = regexp.MustCompile(`^<@!?(\d+)>$`)
+	roleMentionRe    = regexp.MustCompile(`^<@&(\d+)>$`)
+	channelMentionRe = regexp.MustCompile(`^<#(\d+)>$`)
+	snowflakeRe      = regexp.MustCompile(`^\d+$`)
+)
+
+type commandFunc func(ctx *commandContext)
+
+// permLevel who is allowed to run a command, higher levels include the lower ones
+type permLevel int
+
+const (
+	permEveryone permLevel = iota
+	// permAdmin members with the guilds admin role
+	permAdmin
+	permGuildOwner
+	permBotOwner
+)
+
+func (p permLevel) String() string {
+	switch p {
+	case permAdmin:
+		return "server admin"
+	case permGuildOwner:
+		return "server owner"
+	case permBotOwner:
+		return "bot owner"
+	}
+	return "everyone"
+}
+
+// command is a node in the command tree, inner nodes dispatch to their
+// subcommands while leafs parse their args and call f
+type command struct {
+	name        string
+	aliases     []string
+	args        []commandArg
+	subcommands []*command
+	f           commandFunc
+	// slashName subcommand slash commands call f with when the command has subcommands too,
+	// discord doesn't allow mixing subcommands and arguments
+	slashName string
+
+	description string
+	examples    []string
+	perm        permLevel
+
+	// cooldowns per channel, only used on root commands
+	cds map[string]time.Time
+}
+
+// commandArg describes a positional argument of a command
+type commandArg struct {
+	name     string
+	typ      argType
+	optional bool
+	// complete returns autocomplete suggestions for slash commands
+	complete func(value string) []string
+}
+
+// commandContext is passed to every command handler, either m or i is set
+// depending on whether the command was a prefix or a slash command
+type commandContext struct {
+	td *TenseiDiscord
+	s  *discordgo.Session
+	m  *discordgo.MessageCreate
+	i  *discordgo.InteractionCreate
+
+	guildID   string
+	channelID string
+	author    *discordgo.User
+	member    *discordgo.Member
+	level     permLevel
+	prefix    string
+	root      *command
+	path      string
+	args      map[string]interface{}
+
+	// deferred is set when the interaction was acknowledged before running the command
+	deferred bool
+	replied  bool
+}
+
+// token is a single parsed word, raw holds the unparsed input starting at the token
+type token struct {
+	value string
+	raw   string
+}
+
+func (c *command) matches(name string) bool {
+	return strings.EqualFold(c.name, name) || contains(c.aliases, name)
+}
+
+func (c *command) subcommand(name string) *command {
+	for _, sub := range c.subcommands {
+		if sub.matches(name) {
+			return sub
+		}
+	}
+	return nil
+}
+
+func (c *command) usage(path string) string {
+	var sb strings.Builder
+	sb.WriteString(path)
+	// commands with a handler take their own arguments when no subcommand matches
+	if len(c.subcommands) > 0 && c.f == nil {
+		names := make([]string, 0, len(c.subcommands))
+		for _, sub := range c.subcommands {
+			names = append(names, sub.name)
+		}
+		sb.WriteString(fmt.Sprintf(" <%s>", strings.Join(names, "|")))
+	}
+	for _, arg := range c.args {
+		if arg.optional {
+			sb.WriteString(fmt.Sprintf(" [%s]", arg.name))
+		} else {
+			sb.WriteString(fmt.Sprintf(" <%s>", arg.name))
+		}
+	}
+	return sb.String()
+}
+
+func (c *command) parseArgs(tokens []token) (map[string]interface{}, error) {
+	args := make(map[string]interface{})
+	for i, arg := range c.args {
+		if i >= len(tokens) {
+			if arg.optional {
+				break
+			}
+			return nil, fmt.Errorf("missing argument `%s`", arg.name)
+		}
+		if arg.typ == argText {
+			args[arg.name] = strings.TrimSpace(tokens[i].raw)
+			return args, nil
+		}
+		v, err := parseArg(arg.typ, tokens[i].value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid argument `%s`: %v", arg.name, err)
+		}
+		args[arg.name] = v
+	}
+	if len(tokens) > len(c.args) {
+		return nil, errors.New("too many arguments")
+	}
+	return args, nil
+}
+
+func parseArg(typ argType, value string) (interface{}, error) {
+	switch typ {
+	case argInt:
+		return strconv.Atoi(value)
+	case argDuration:
+		// plain numbers are seconds
+		if n, err := strconv.Atoi(value); err == nil {
+			return time.Duration(n) * time.Second, nil
+		}
+		return time.ParseDuration(value)
+	case argUser:
+		return parseMention(userMentionRe, value, "user")
+	case argRole:
+		return parseMention(roleMentionRe, value, "role")
+	case argChannel:
+		return parseMention(channelMentionRe, value, "channel")
+	}
+	return value, nil
+}
+
+// parseMention returns the id of a mention, raw ids are accepted as well
+func parseMention(re *regexp.Regexp, value, kind string) (string, error) {
+	if snowflakeRe.MatchString(value) {
+		return value, nil
+	}
+	match := re.FindStringSubmatch(value)
+	if match == nil {
+		return "", fmt.Errorf("'%s' is not a %s", value, kind)
+	}
+	return match[1], nil
+}
+
+// tokenize splits the input on whitespace, "double quoted" parts are kept together
+func tokenize(input string) []token {
+	var tokens []token
+	i := 0
+	for i < len(input) {
+		if input[i] == ' ' || input[i] == '\t' || input[i] == '\n' {
+			i++
+			continue
+		}
+		start := i
+		if input[i] == '"' {
+			end := strings.IndexByte(input[i+1:], '"')
+			if end >= 0 {
+				tokens = append(tokens, token{value: input[i+1 : i+1+end], raw: input[start:]})
+				i += end + 2
+				continue
+			}
+		}
+		for i < len(input) && input[i] != ' ' && input[i] != '\t' && input[i] != '\n' {
+			i++
+		}
+		tokens = append(tokens, token{value: input[start:i], raw: input[start:]})
+	}
+	return tokens
+}
+
+// walk calls fn for every command in the tree that has a handler, perm is the
+// highest permission level required on the way down
+func (c *command) walk(path string, perm permLevel, fn func(c *command, path string, perm permLevel)) {
+	if c.perm > perm {
+		perm = c.perm
+	}
+	if c.f != nil {
+		fn(c, path, perm)
+	}
+	for _, sub := range c.subcommands {
+		sub.walk(path+" "+sub.name, perm, fn)
+	}
+}
+
+// memberPermLevel returns the highest permission level of the member
+func (tb *TenseiBot) memberPermLevel(guildID string, member *discordgo.Member) permLevel {
+	if member == nil {
+		return permEveryone
+	}
+	if tb.isOwner(member.User.ID) {
+		return permBotOwner
+	}
+	guild, err := tb.Discord.c.Guild(guildID)
+	if err == nil && guild.OwnerID == member.User.ID {
+		return permGuildOwner
+	}
+	if isServerAdmin(tb.GetGuildSettingsFromDB(guildID), member) {
+		return permAdmin
+	}
+	return permEveryone
+}
+
+// allowed checks if the member can run c, slash commands get an ephemeral error
+func (ctx *commandContext) allowed(c *command) bool {
+	if c.perm <= ctx.level {
+		return true
+	}
+	log.Debugf("[COMMAND] %s(%s) is missing permission %s for %s", ctx.author.String(), ctx.author.ID, c.perm, ctx.path)
+	ctx.notice("you need to be %s to use this command", c.perm)
+	return false
+}
+
+// runCommand walks the subcommand tree, parses the arguments and calls the handler
+func (tb *TenseiBot) runCommand(s *discordgo.Session, m *discordgo.MessageCreate, root *command, tokens []token) {
+	member, _ := s.GuildMember(m.GuildID, m.Author.ID)
+	ctx := &commandContext{
+		td:        tb.Discord,
+		s:         s,
+		m:         m,
+		guildID:   m.GuildID,
+		channelID: m.ChannelID,
+		author:    m.Author,
+		member:    member,
+		level:     tb.memberPermLevel(m.GuildID, member),
+		prefix:    tb.guildPrefix(m.GuildID),
+		root:      root,
+	}
+	ctx.path = ctx.prefix + root.name
+
+	cmd := root
+	for {
+		if !ctx.allowed(cmd) {
+			return
+		}
+		if len(cmd.subcommands) == 0 {
+			break
+		}
+		var sub *command
+		if len(tokens) > 0 {
+			sub = cmd.subcommand(tokens[0].value)
+		}
+		if sub == nil {
+			if cmd.f != nil {
+				break
+			}
+			ctx.error("usage: `%s`", cmd.usage(ctx.path))
+			return
+		}
+		cmd = sub
+		ctx.path += " " + sub.name
+		tokens = tokens[1:]
+	}
+
+	args, err := cmd.parseArgs(tokens)
+	if err != nil {
+		ctx.error("%v\nusage: `%s`", err, cmd.usage(ctx.path))
+		return
+	}
+	ctx.args = args
+	cmd.f(ctx)
+}
+
+// reply sends the embed to the channel or as the interaction response
+func (ctx *commandContext) reply(embed *discordgo.MessageEmbed) *discordgo.Message {
+	return ctx.send(embed, false)
+}
+
+// success sends a green embed
+func (ctx *commandContext) success(message string, args ...interface{}) {
+	ctx.send(&discordgo.MessageEmbed{
+		Description: fmt.Sprintf(message, args...),
+		Color:       0x00ff00,
+	}, false)
+}
+
+// error sends a red embed, only visible to the author for slash commands
+func (ctx *commandContext) error(message string, args ...interface{}) {
+	ctx.send(&discordgo.MessageEmbed{
+		Description: fmt.Sprintf(message, args...),
+		Color:       0xff0000,
+	}, true)
+}
+
+// notice sends an ephemeral error for slash commands, prefix commands stay silent
+// to not spam the channel
+func (ctx *commandContext) notice(message string, args ...interface{}) {
+	if ctx.i != nil {
+		ctx.error(message, args...)
+	}
+}
+
+// replyPages sends the first page and lets the author flip through the rest
+func (ctx *commandContext) replyPages(pages []*discordgo.MessageEmbed) {
+	if len(pages) < 1 {
+		return
+	}
+	if len(pages) > 1 {
+		for i, page := range pages {
+			page.Footer = &discordgo.MessageEmbedFooter{
+				Text: fmt.Sprintf("Page %d/%d", i+1, len(pages)),
+			}
+		}
+	}
+	msg := ctx.reply(pages[0])
+	if msg == nil || len(pages) == 1 {
+		return
+	}
+	ctx.td.addPagination(msg, ctx.author.ID, pages)
+}
+
+func (ctx *commandContext) send(embed *discordgo.MessageEmbed, ephemeral bool) *discordgo.Message {
+	if ctx.i == nil {
+		msg, err := ctx.s.ChannelMessageSendEmbed(ctx.channelID, embed)
+		if err != nil {
+			log.Errorf("[DISCORD] error sending message to channel %s, err: %v", ctx.channelID, err)
+		}
+		return msg
+	}
+
+	var flags discordgo.MessageFlags
+	if ephemeral {
+		flags = discordgo.MessageFlagsEphemeral
+	}
+	if ctx.replied {
+		msg, err := ctx.s.FollowupMessageCreate(ctx.i.Interaction, true, &discordgo.WebhookParams{
+			Embeds: []*discordgo.MessageEmbed{embed},
+			Flags:  flags,
+		})
+		if err != nil {
+			log.Errorf("[DISCORD] error sending followup for %s, err: %v", ctx.path, err)
+		}
+		return msg
+	}
+
+	ctx.replied = true
+	if ctx.deferred {
+		return ctx.editDeferred(embed, ephemeral)
+	}
+	err := ctx.s.InteractionRespond(ctx.i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Embeds: []*discordgo.MessageEmbed{embed},
+			Flags:  flags,
+		},
+	})
+	if err != nil {
+		log.Errorf("[DISCORD] error responding to %s, err: %v", ctx.path, err)
+		return nil
+	}
+	msg, _ := ctx.s.InteractionResponse(ctx.i.Interaction)
+	return msg
+}
+
+// editDeferred replaces the thinking message of the deferred interaction with the embed, its visibility
+// was set when deferring, so ephemeral embeds replace it with a followup
+func (ctx *commandContext) editDeferred(embed *discordgo.MessageEmbed, ephemeral bool) *discordgo.Message {
+	if ephemeral {
+		if err := ctx.s.InteractionResponseDelete(ctx.i.Interaction); err != nil {
+			log.Errorf("[DISCORD] error deleting response to %s, err: %v", ctx.path, err)
+		}
+		msg, err := ctx.s.FollowupMessageCreate(ctx.i.Interaction, true, &discordgo.WebhookParams{
+			Embeds: []*discordgo.MessageEmbed{embed},
+			Flags:  discordgo.MessageFlagsEphemeral,
+		})
+		if err != nil {
+			log.Errorf("[DISCORD] error sending followup for %s, err: %v", ctx.path, err)
+		}
+		return msg
+	}
+	msg, err := ctx.s.InteractionResponseEdit(ctx.i.Interaction, &discordgo.WebhookEdit{
+		Embeds: &[]*discordgo.MessageEmbed{embed},
+	})
+	if err != nil {
+		log.Errorf("[DISCORD] error responding to %s, err: %v", ctx.path, err)
+	}
+	return msg
+}
+
+func (ctx *commandContext) has(name string) bool {
+	_, ok := ctx.args[name]
+	return ok
+}
+
+func (ctx *commandContext) str(name string) string {
+	v, _ := ctx.args[name].(string)
+	return v
+}
+
+func (ctx *commandContext) integer(name string) int {
+	v, _ := ctx.args[name].(int)
+	return v
+}
+
+func (ctx *commandContext) duration(name string) time.Duration {
+	v, _ := ctx.args[name].(time.Duration)
+	return v
+}
@@ -0,0 +1,478 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	feedPollInterval = 10 * time.Minute
+	feedUserAgent    = "TenseiBot feed reader"
+	// feedMaxPosts entries posted per poll, older unseen ones are skipped after a long downtime
+	feedMaxPosts = 5
+	// feedParallel feeds fetched at the same time
+	feedParallel = 5
+	feedMaxSize  = 5 << 20
+)
+
+// TenseiFeeds rss and atom part of the bot
+type TenseiFeeds struct {
+	httpClient *http.Client
+
+	subscriptions []*FeedSubscription
+	mutex         sync.Mutex
+}
+
+// errFeedAddress feeds are added by server admins, they must not reach the network of the bot
+var errFeedAddress = errors.New("address is not public")
+
+// cgnatNet shared address space, not covered by IsPrivate
+var cgnatNet = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// newFeedClient returns a client that only connects to public addresses, the address is checked
+// after resolving so redirects and dns records pointing inside are refused too
+func newFeedClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+				return errFeedAddress
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: 15 * time.Second,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 10 * time.Second,
+			MaxIdleConns:        10,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}
+}
+
+// isPublicIP returns false for loopback, private, link-local and other internal addresses
+func isPublicIP(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() && !ip.IsMulticast() && !ip.IsUnspecified() && !cgnatNet.Contains(ip)
+}
+
+// NewFeeds loads the feed subscriptions and starts polling
+func (tb *TenseiBot) NewFeeds() {
+	tb.Feeds.httpClient = newFeedClient()
+	tb.Feeds.subscriptions = tb.GetFeedSubscriptions()
+
+	interval := feedPollInterval
+	if tb.Config.Feeds.Interval != "" {
+		d, err := time.ParseDuration(tb.Config.Feeds.Interval)
+		if err != nil || d < time.Minute {
+			log.Warnf("[FEEDS] invalid interval %s, using %s", tb.Config.Feeds.Interval, feedPollInterval)
+		} else {
+			interval = d
+		}
+	}
+	go tb.startFeedJobs(interval)
+
+	log.Info("[MODULE] feeds loaded")
+}
+
+func (tb *TenseiBot) startFeedJobs(interval time.Duration) {
+	log.Infof("[FEEDS] starting %d FEED_JOBS", len(tb.Feeds.subscriptions))
+	ticker := time.NewTicker(interval)
+	for range ticker.C {
+		tb.pollFeeds()
+	}
+}
+
+// pollFeeds checks every subscription, a few feeds at a time
+func (tb *TenseiBot) pollFeeds() {
+	// feeds are fetched without holding the mutex, the commands would wait for slow feeds otherwise
+	tb.Feeds.mutex.Lock()
+	subs := make([]*FeedSubscription, len(tb.Feeds.subscriptions))
+	copy(subs, tb.Feeds.subscriptions)
+	tb.Feeds.mutex.Unlock()
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, feedParallel)
+	for _, sub := range subs {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(sub *FeedSubscription) {
+			defer wg.Done()
+			tb.runFeedJob(sub)
+			<-slots
+		}(sub)
+	}
+	wg.Wait()
+}
+
+// runFeedJob posts the entries that weren't seen before, oldest first
+func (tb *TenseiBot) runFeedJob(sub *FeedSubscription) {
+	tb.Feeds.mutex.Lock()
+	req := *sub
+	tb.Feeds.mutex.Unlock()
+
+	f, err := tb.Feeds.fetch(&req, true)
+	if err != nil {
+		log.Warnf("[FEED_JOB] %s: %v", sub.URL, err)
+		return
+	}
+	// not modified since the last poll
+	if f == nil {
+		return
+	}
+
+	embeds := tb.updateFeedEntries(sub, &req, f)
+	for _, embed := range embeds {
+		if _, err := tb.Discord.c.ChannelMessageSendEmbed(sub.ChannelID, embed); err != nil {
+			log.Errorf("[FEED_JOB] failed sending entry to channel: %s, feed: %s: %v", sub.ChannelID, sub.URL, err)
+		}
+	}
+}
+
+// updateFeedEntries stores the fetched state of the feed and returns the embeds of the new entries,
+// nothing is stored for subscriptions that were removed during the fetch
+func (tb *TenseiBot) updateFeedEntries(sub, req *FeedSubscription, f *feed) []*discordgo.MessageEmbed {
+	tb.Feeds.mutex.Lock()
+	defer tb.Feeds.mutex.Unlock()
+	if tb.Feeds.feedSubscription(sub.ChannelID, sub.URL) != sub {
+		return nil
+	}
+	sub.ETag = req.ETag
+	sub.LastModified = req.LastModified
+
+	seen := make(map[string]*FeedEntry)
+	for _, entry := range tb.GetFeedEntries(sub) {
+		seen[entry.GUID] = entry
+	}
+	current := make(map[string]bool)
+	var unseen []*feedItem
+	for _, item := range f.items {
+		if current[item.guid] {
+			continue
+		}
+		current[item.guid] = true
+		if seen[item.guid] == nil {
+			unseen = append(unseen, item)
+		}
+	}
+
+	if f.title != "" {
+		sub.Title = f.title
+	}
+	var guids []string
+	var embeds []*discordgo.MessageEmbed
+	for i := len(unseen) - 1; i >= 0; i-- {
+		item := unseen[i]
+		guids = append(guids, item.guid)
+		if i >= feedMaxPosts || !sub.matches(item) {
+			continue
+		}
+		log.Infof("[FEED_JOB] new entry %s in %s", item.guid, sub.URL)
+		embeds = append(embeds, sub.embed(item))
+	}
+	tb.AddFeedEntries(sub, guids)
+
+	// forget entries that dropped out of the feed, the table only has to know the current ones
+	var stale []uint
+	for guid, entry := range seen {
+		if !current[guid] {
+			stale = append(stale, entry.ID)
+		}
+	}
+	if len(f.items) > 0 {
+		tb.RemoveFeedEntries(stale)
+	}
+	tb.UpdateFeedSubscription(sub)
+	return embeds
+}
+
+// fetch downloads and parses the feed, with conditional it returns nil when
+// the feed didn't change since the last request
+func (tf *TenseiFeeds) fetch(sub *FeedSubscription, conditional bool) (*feed, error) {
+	req, err := http.NewRequest(http.MethodGet, sub.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", feedUserAgent)
+	req.Header.Set("Accept", "application/rss+xml, application/atom+xml, application/xml;q=0.9, */*;q=0.8")
+	if conditional && sub.ETag != "" {
+		req.Header.Set("If-None-Match", sub.ETag)
+	}
+	if conditional && sub.LastModified != "" {
+		req.Header.Set("If-Modified-Since", sub.LastModified)
+	}
+
+	resp, err := tf.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if conditional && resp.StatusCode == http.StatusNotModified {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, feedMaxSize))
+	if err != nil {
+		return nil, err
+	}
+	f, err := parseFeed(data)
+	if err != nil {
+		return nil, err
+	}
+	sub.ETag = resp.Header.Get("ETag")
+	sub.LastModified = resp.Header.Get("Last-Modified")
+	return f, nil
+}
+
+// KeywordList ...
+func (sub *FeedSubscription) KeywordList() []string {
+	return splitKeywords(sub.Keywords)
+}
+
+func splitKeywords(s string) []string {
+	var keywords []string
+	for _, keyword := range strings.Split(s, ",") {
+		if keyword = strings.TrimSpace(keyword); keyword != "" {
+			keywords = append(keywords, keyword)
+		}
+	}
+	return keywords
+}
+
+// matches returns true when the entry has one of the keywords and none of the -keywords
+func (sub *FeedSubscription) matches(item *feedItem) bool {
+	text := strings.ToLower(item.title + " " + item.summary)
+	included, hasIncludes := false, false
+	for _, keyword := range sub.KeywordList() {
+		if strings.HasPrefix(keyword, "-") {
+			if strings.Contains(text, strings.ToLower(keyword[1:])) {
+				return false
+			}
+			continue
+		}
+		hasIncludes = true
+		if strings.Contains(text, strings.ToLower(keyword)) {
+			included = true
+		}
+	}
+	return included || !hasIncludes
+}
+
+// feedPlaceholders {title}, {link}, {summary}, {author}, {feed} and {published}
+func (sub *FeedSubscription) feedPlaceholders(item *feedItem) map[string]string {
+	published := ""
+	if !item.published.IsZero() {
+		published = fmt.Sprintf("<t:%d:f>", item.published.Unix())
+	}
+	return map[string]string{
+		"title":     item.title,
+		"link":      item.link,
+		"summary":   item.summary,
+		"author":    item.author,
+		"feed":      sub.Title,
+		"published": published,
+	}
+}
+
+// embed renders the entry with the template of the subscription
+func (sub *FeedSubscription) embed(item *feedItem) *discordgo.MessageEmbed {
+	description := item.summary
+	if sub.Template != "" {
+		var replacements []string
+		for key, value := range sub.feedPlaceholders(item) {
+			replacements = append(replacements, "{"+key+"}", value)
+		}
+		description = strings.NewReplacer(replacements...).Replace(sub.Template)
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Author: &discordgo.MessageEmbedAuthor{
+			Name: sub.Title,
+		},
+		Title:       item.title,
+		URL:         item.link,
+		Description: description,
+		Color:       0xF26522,
+	}
+	if feedURL, err := url.Parse(sub.URL); err == nil {
+		embed.Author.URL = fmt.Sprintf("%s://%s", feedURL.Scheme, feedURL.Host)
+	}
+	if item.author != "" {
+		embed.Footer = &discordgo.MessageEmbedFooter{Text: item.author}
+	}
+	if !item.published.IsZero() {
+		embed.Timestamp = item.published.Format(time.RFC3339)
+	}
+	return embed
+}
+
+// parseFeedOptions splits keywords: and template: from the command, the template takes the rest of the text
+func parseFeedOptions(text string) (string, string) {
+	var keywords, template string
+	if i := strings.Index(strings.ToLower(text), "template:"); i >= 0 {
+		template = strings.TrimSpace(text[i+len("template:"):])
+		text = text[:i]
+	}
+	for _, word := range strings.Fields(text) {
+		if i := strings.Index(word, ":"); i > 0 && contains([]string{"keyword", "keywords"}, word[:i]) {
+			keywords = strings.Trim(keywords+","+word[i+1:], ",")
+		}
+	}
+	return keywords, template
+}
+
+// feedSubscription returns the subscription of the channel to the url, the caller has to hold mutex
+func (tf *TenseiFeeds) feedSubscription(channelID, feedURL string) *FeedSubscription {
+	for _, sub := range tf.subscriptions {
+		if sub.ChannelID == channelID && sub.URL == feedURL {
+			return sub
+		}
+	}
+	return nil
+}
+
+func discordFeedAdd(tb *TenseiBot) commandFunc {
+	return func(ctx *commandContext) {
+		channelID := ctx.str("channel")
+		if err := guildChannel(ctx.s, ctx.guildID, channelID); err != nil {
+			ctx.error("%v", err)
+			return
+		}
+		feedURL := ctx.str("url")
+		if u, err := url.Parse(feedURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			ctx.error("'%s' is not a http or https url", feedURL)
+			return
+		}
+		keywords, template := parseFeedOptions(ctx.str("options"))
+		sub := &FeedSubscription{
+			ChannelID: channelID,
+			GuildID:   ctx.guildID,
+			URL:       feedURL,
+			Keywords:  keywords,
+			Template:  template,
+		}
+
+		f, err := tb.Feeds.fetch(sub, false)
+		if err != nil {
+			log.Warnf("[FEEDS] failed adding %s: %v", feedURL, err)
+			// the error could tell what the bot can reach
+			ctx.error("couldn't read feed %s", feedURL)
+			return
+		}
+		sub.Title = f.title
+		if sub.Title == "" {
+			sub.Title = feedURL
+		}
+
+		tb.Feeds.mutex.Lock()
+		defer tb.Feeds.mutex.Unlock()
+		if tb.Feeds.feedSubscription(channelID, feedURL) != nil {
+			ctx.error("%s is already posted in <#%s>", feedURL, channelID)
+			return
+		}
+		tb.AddFeedSubscription(sub)
+		// only entries published from now on are posted
+		var guids []string
+		for _, item := range f.items {
+			guids = append(guids, item.guid)
+		}
+		tb.AddFeedEntries(sub, guids)
+		tb.Feeds.subscriptions = append(tb.Feeds.subscriptions, sub)
+		ctx.success("added feed %s to <#%s>, %s", escapeDiscord(sub.Title), channelID, sub.filterSummary())
+	}
+}
+
+func discordFeedRemove(tb *TenseiBot) commandFunc {
+	return func(ctx *commandContext) {
+		channelID := ctx.str("channel")
+		feedURL := ctx.str("url")
+
+		tb.Feeds.mutex.Lock()
+		defer tb.Feeds.mutex.Unlock()
+		sub := tb.Feeds.feedSubscription(channelID, feedURL)
+		if sub == nil || sub.GuildID != ctx.guildID {
+			ctx.error("%s isn't posted in <#%s>", feedURL, channelID)
+			return
+		}
+		tb.RemoveFeedSubscription(sub)
+		for i, s := range tb.Feeds.subscriptions {
+			if s == sub {
+				tb.Feeds.subscriptions = append(tb.Feeds.subscriptions[:i], tb.Feeds.subscriptions[i+1:]...)
+				break
+			}
+		}
+		ctx.success("removed feed %s from <#%s>", escapeDiscord(sub.Title), channelID)
+	}
+}
+
+// filterSummary describes the keywords and template of the subscription
+func (sub *FeedSubscription) filterSummary() string {
+	var parts []string
+	if keywords := sub.KeywordList(); len(keywords) > 0 {
+		parts = append(parts, fmt.Sprintf("keywords %s", strings.Join(keywords, ", ")))
+	} else {
+		parts = append(parts, "every entry")
+	}
+	if sub.Template != "" {
+		parts = append(parts, fmt.Sprintf("template `%s`", strings.ReplaceAll(sub.Template, "`", "'")))
+	}
+	return strings.Join(parts, ", ")
+}
+
+const feedListPerPage = 10
+
+func discordFeedList(tb *TenseiBot) commandFunc {
+	return func(ctx *commandContext) {
+		var fields []*discordgo.MessageEmbedField
+		tb.Feeds.mutex.Lock()
+		for _, sub := range tb.Feeds.subscriptions {
+			if sub.GuildID != ctx.guildID {
+				continue
+			}
+			fields = append(fields, &discordgo.MessageEmbedField{
+				Name:  fmt.Sprintf("%s in #%s", sub.Title, channelName(ctx.s, sub.ChannelID)),
+				Value: fmt.Sprintf("%s\n%s", sub.URL, sub.filterSummary()),
+			})
+		}
+		tb.Feeds.mutex.Unlock()
+
+		if len(fields) < 1 {
+			ctx.error("there are no feeds on this server")
+			return
+		}
+
+		var pages []*discordgo.MessageEmbed
+		for len(fields) > 0 {
+			n := feedListPerPage
+			if n > len(fields) {
+				n = len(fields)
+			}
+			pages = append(pages, &discordgo.MessageEmbed{
+				Title:  "Feeds",
+				Fields: fields[:n],
+			})
+			fields = fields[n:]
+		}
+		ctx.replyPages(pages)
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// feed rss or atom feed reduced to what the embeds show
+type feed struct {
+	title string
+	// items newest first, like most feeds list them
+	items []*feedItem
+}
+
+type feedItem struct {
+	guid      string
+	title     string
+	link      string
+	summary   string
+	author    string
+	published time.Time
+}
+
+// feedDocument matches rss 2.0, rss 1.0 (rdf) and atom, elements match by local name
+type feedDocument struct {
+	XMLName xml.Name
+	// Title of atom feeds
+	Title   string `xml:"title"`
+	Channel struct {
+		Title string    `xml:"title"`
+		Items []rssItem `xml:"item"`
+	} `xml:"channel"`
+	// Items of rss 1.0, they are next to the channel
+	Items   []rssItem   `xml:"item"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type rssItem struct {
+	GUID        string `xml:"guid"`
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	Description string `xml:"description"`
+	Author      string `xml:"author"`
+	Creator     string `xml:"creator"`
+	PubDate     string `xml:"pubDate"`
+	Date        string `xml:"date"`
+}
+
+type atomEntry struct {
+	ID        string     `xml:"id"`
+	Title     string     `xml:"title"`
+	Links     []atomLink `xml:"link"`
+	Summary   string     `xml:"summary"`
+	Content   string     `xml:"content"`
+	Published string     `xml:"published"`
+	Updated   string     `xml:"updated"`
+	Author    struct {
+		Name string `xml:"name"`
+	} `xml:"author"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+}
+
+var feedDateLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	time.RFC3339,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700",
+	"2006-01-02T15:04:05",
+}
+
+const (
+	// feedTitleLength is the embed title limit
+	feedTitleLength   = 256
+	feedSummaryLength = 300
+)
+
+var (
+	htmlTagRe    = regexp.MustCompile(`<[^>]*>`)
+	whitespaceRe = regexp.MustCompile(`\s+`)
+)
+
+// parseFeed parses an rss or atom document
+func parseFeed(data []byte) (*feed, error) {
+	var doc feedDocument
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	// the decoder only knows utf-8, most other feeds are latin-1 or windows-1252 and mostly ascii
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+	decoder.Strict = false
+	decoder.Entity = xml.HTMLEntity
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("not an rss or atom feed: %v", err)
+	}
+
+	f := &feed{}
+	switch strings.ToLower(doc.XMLName.Local) {
+	case "rss", "rdf":
+		f.title = doc.Channel.Title
+		for _, item := range append(doc.Channel.Items, doc.Items...) {
+			f.items = append(f.items, item.feedItem())
+		}
+	case "feed":
+		f.title = doc.Title
+		for _, entry := range doc.Entries {
+			f.items = append(f.items, entry.feedItem())
+		}
+	default:
+		return nil, fmt.Errorf("not an rss or atom feed: root element %s", doc.XMLName.Local)
+	}
+	f.title = strings.TrimSpace(f.title)
+	return f, nil
+}
+
+func (item rssItem) feedItem() *feedItem {
+	fi := &feedItem{
+		guid:      strings.TrimSpace(item.GUID),
+		title:     cleanFeedText(item.Title, feedTitleLength),
+		link:      strings.TrimSpace(item.Link),
+		summary:   cleanFeedText(item.Description, feedSummaryLength),
+		author:    strings.TrimSpace(item.Author),
+		published: parseFeedDate(item.PubDate),
+	}
+	if fi.author == "" {
+		fi.author = strings.TrimSpace(item.Creator)
+	}
+	if fi.published.IsZero() {
+		fi.published = parseFeedDate(item.Date)
+	}
+	fi.fillGUID()
+	return fi
+}
+
+func (entry atomEntry) feedItem() *feedItem {
+	fi := &feedItem{
+		guid:      strings.TrimSpace(entry.ID),
+		title:     cleanFeedText(entry.Title, feedTitleLength),
+		summary:   cleanFeedText(entry.Summary, feedSummaryLength),
+		author:    strings.TrimSpace(entry.Author.Name),
+		published: parseFeedDate(entry.Published),
+	}
+	for _, link := range entry.Links {
+		if link.Rel == "" || link.Rel == "alternate" {
+			fi.link = strings.TrimSpace(link.Href)
+			break
+		}
+	}
+	if fi.summary == "" {
+		fi.summary = cleanFeedText(entry.Content, feedSummaryLength)
+	}
+	if fi.published.IsZero() {
+		fi.published = parseFeedDate(entry.Updated)
+	}
+	fi.fillGUID()
+	return fi
+}
+
+// fillGUID falls back to the link or title for feeds without ids
+func (item *feedItem) fillGUID() {
+	if item.guid == "" {
+		item.guid = item.link
+	}
+	if item.guid == "" {
+		item.guid = item.title
+	}
+}
+
+func parseFeedDate(value string) time.Time {
+	value = strings.TrimSpace(value)
+	for _, layout := range feedDateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// cleanFeedText strips html from titles and summaries and shortens them to length runes
+func cleanFeedText(s string, length int) string {
+	s = htmlTagRe.ReplaceAllString(s, " ")
+	s = html.UnescapeString(s)
+	s = strings.TrimSpace(whitespaceRe.ReplaceAllString(s, " "))
+	if runes := []rune(s); len(runes) > length {
+		s = strings.TrimSpace(string(runes[:length-1])) + "…"
+	}
+	return s
+}
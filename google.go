@@ -2,10 +2,13 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"net/http"
 
 	"cloud.google.com/go/translate"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/text/language"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
 )
 
@@ -19,8 +22,8 @@ type TenseiGoogle struct {
 
 // NewGoogle creates all google clients
 func (tb *TenseiBot) NewGoogle() {
-	tb.Google.NewTranslateClientWithKey(tb.Config.Google.APIKey)
 	tb.Google.ctx, tb.Google.ctxCancelFunc = context.WithCancel(context.Background())
+	tb.Google.NewTranslateClientWithKey(tb.Config.Google.APIKey)
 }
 
 // NewTranslateClientWithKey ...
@@ -34,15 +37,79 @@ func (tg *TenseiGoogle) NewTranslateClientWithKey(apiKey string) {
 	log.Info("[MODULE] translate loaded")
 }
 
-// Translate translates text to the target language
-func (tg *TenseiGoogle) Translate(text, targetLanguage string) (string, error) {
-	lang, err := language.Parse(targetLanguage)
+// Name ...
+func (tg *TenseiGoogle) Name() string {
+	return translatorGoogle
+}
+
+// Translate translates text to the target language, source is detected when empty
+func (tg *TenseiGoogle) Translate(text, source, target string) (*translation, error) {
+	lang, err := language.Parse(target)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	opts := &translate.Options{Format: translate.Text}
+	if source != "" {
+		if opts.Source, err = language.Parse(source); err != nil {
+			return nil, err
+		}
 	}
-	resp, err := tg.TranslateClient.Translate(tg.ctx, []string{text}, lang, nil)
+	resp, err := tg.TranslateClient.Translate(tg.ctx, []string{text}, lang, opts)
+	if err != nil {
+		return nil, googleError(err)
+	}
+	if len(resp) < 1 {
+		return nil, fmt.Errorf("empty response")
+	}
+	result := &translation{text: resp[0].Text, source: source}
+	if source == "" {
+		result.source = resp[0].Source.String()
+	}
+	return result, nil
+}
+
+// Detect ...
+func (tg *TenseiGoogle) Detect(text string) (*detection, error) {
+	resp, err := tg.TranslateClient.DetectLanguage(tg.ctx, []string{text})
 	if err != nil {
-		return "", err
+		return nil, googleError(err)
+	}
+	if len(resp) < 1 || len(resp[0]) < 1 {
+		return nil, fmt.Errorf("no language detected")
+	}
+	return &detection{language: resp[0][0].Language.String(), confidence: resp[0][0].Confidence}, nil
+}
+
+// Languages ...
+func (tg *TenseiGoogle) Languages() ([]translateLanguage, error) {
+	if len(tg.supportedLanguages) < 1 {
+		languages, err := tg.TranslateClient.SupportedLanguages(tg.ctx, language.English)
+		if err != nil {
+			return nil, googleError(err)
+		}
+		tg.supportedLanguages = languages
+	}
+	languages := make([]translateLanguage, 0, len(tg.supportedLanguages))
+	for _, l := range tg.supportedLanguages {
+		languages = append(languages, translateLanguage{code: l.Tag.String(), name: l.Name})
+	}
+	return languages, nil
+}
+
+// googleError marks errors of exceeded quotas and rate limits
+func googleError(err error) error {
+	apiErr, ok := err.(*googleapi.Error)
+	if !ok {
+		return err
+	}
+	if apiErr.Code == http.StatusTooManyRequests {
+		return fmt.Errorf("%w: %v", errTranslateQuota, err)
+	}
+	for _, item := range apiErr.Errors {
+		switch item.Reason {
+		case "dailyLimitExceeded", "userRateLimitExceeded", "rateLimitExceeded", "quotaExceeded":
+			return fmt.Errorf("%w: %v", errTranslateQuota, err)
+		}
 	}
-	return resp[0].Text, nil
+	return err
 }
@@ -3,174 +3,333 @@ package main
 import (
 	"fmt"
 	"github.com/bwmarrin/discordgo"
-	"github.com/nicklaw5/helix"
 	log "github.com/sirupsen/logrus"
 	"strings"
-	"sync"
 	"time"
 )
 
-// TwitchJob ...
-func (tb *TenseiBot) NewTwitchJob(streamer *TwitchStreamer, wg *sync.WaitGroup) {
-	defer wg.Done()
-	var stream *helix.Stream
-	streams, err := tb.Twitch.getStream([]string{streamer.ChannelID}, nil)
-	if err != nil {
-		log.Warn(err)
-		return
-	}
-	if len(streams) > 0 {
-		stream = &streams[0]
+// liveEndGrace streams that ended less than this ago are ignored, they often come back after a disconnect
+const liveEndGrace = 3 * time.Minute
+
+// startLiveJobs runs the jobs of every channel of the source
+func (tb *TenseiBot) startLiveJobs(source LiveSource) {
+	source.Poll(func(channel LiveChannel, stream LiveStream) {
+		tb.runLiveJob(source, channel, stream)
+	})
+}
+
+// beginLiveJob marks a job of the channel as running, it returns false while
+// another job of the channel runs or when the channel isn't tracked anymore
+func beginLiveJob(source LiveSource, channel LiveChannel) bool {
+	source.Lock()
+	defer source.Unlock()
+	state := channel.State()
+	if state.job || !source.Tracked(channel) {
+		return false
 	}
+	state.job = true
+	return true
+}
 
-	// wait 3minutes
-	if time.Now().UTC().Sub(streamer.StreamEndTime) < time.Duration(time.Minute*3) {
+// endLiveJob lets the next job of the channel run
+func endLiveJob(source LiveSource, channel LiveChannel) {
+	source.Lock()
+	defer source.Unlock()
+	channel.State().job = false
+}
+
+// runLiveJob compares the polled stream with the stored state of the channel,
+// stream is nil when the channel is offline. The caller must not hold the lock of the
+// source, the job only takes it to read and apply the state and calls discord without it
+func (tb *TenseiBot) runLiveJob(source LiveSource, channel LiveChannel, stream LiveStream) {
+	tag := fmt.Sprintf("[%s_JOB]", strings.ToUpper(source.Name()))
+	if !beginLiveJob(source, channel) {
 		return
 	}
+	defer endLiveJob(source, channel)
 
+	source.Lock()
+	state := channel.State()
+	live := state.IsLive()
+	switch {
+	// wait 3minutes
+	case time.Now().UTC().Sub(state.StreamEndTime) < liveEndGrace:
+		source.Unlock()
+		return
 	// stream is offline
-	if !isStreaming(stream) && streamer.StreamLength() >= 0 {
+	case stream == nil && !live:
+		source.Unlock()
+		return
+	// helix keeps listing a stream for a few minutes after eventsub reported it offline
+	case stream != nil && !live && stream.StartTime().UTC().Equal(state.StreamStartTime.UTC()):
+		log.Debugf("%s %s stream that ended is still listed", tag, channel.DisplayName())
+		source.Unlock()
 		return
 	}
 
+	var messages []*liveMessage
+	switch {
 	// stream started
-	if isStreaming(stream) && streamer.StreamLength() >= 0 {
-		log.Infof("[TWITCH_JOB] streamer %s started streaming %s", streamer.Name, stream.StartedAt.UTC().Format("15:04:05 MST"))
-		streamer.StreamStartTime = stream.StartedAt.UTC()
-		users, _ := tb.Twitch.GetUsers([]string{streamer.ChannelID}, nil)
-		if len(users) > 0 {
-			streamer.ProfileImageURL = users[0].ProfileImageURL
+	case stream != nil && !live:
+		log.Infof("%s %s started streaming %s", tag, channel.DisplayName(), stream.StartTime().UTC().Format("15:04:05 MST"))
+		state.StreamStartTime = stream.StartTime().UTC()
+		source.StreamStarted(channel, stream)
+		for _, alert := range channel.Alerts() {
+			msg := tb.newLiveMessage(source, channel, stream, alert)
+			// the message of the last stream stays as it is
+			msg.messageID = ""
+			messages = append(messages, msg)
+		}
+		source.SaveChannel(channel)
+		source.Unlock()
+		tb.postLiveMessages(source, channel, messages, "stream start")
+
+	// update the embed
+	case stream != nil:
+		log.Debugf("%s updating embeds for %s", tag, channel.DisplayName())
+		source.StreamUpdated(channel, stream)
+		for _, alert := range channel.Alerts() {
+			messages = append(messages, tb.newLiveMessage(source, channel, stream, alert))
 		}
-		embed := tb.Twitch.createLiveEmbed(stream, streamer)
-		for _, alerts := range streamer.TwitchAlertSubscriptions {
-			msg, err := tb.Discord.c.ChannelMessageSendEmbed(alerts.ChannelID, embed)
+		source.Unlock()
+		tb.postLiveMessages(source, channel, messages, "stream update")
+
+	// stream went offline
+	default:
+		state.StreamEndTime = time.Now().UTC().Add(-liveEndGrace)
+		log.Infof("%s %s stopped streaming length %s", tag, channel.DisplayName(), state.StreamLength())
+		alerts := channel.Alerts()
+		source.Unlock()
+		tb.endLiveAlerts(source, channel, alerts)
+	}
+}
+
+// liveMessage is an alert message that is rendered under the lock of the source and sent
+// or edited without it
+type liveMessage struct {
+	alert     LiveAlert
+	channelID string
+	// previous message id of the alert, messageID the one to edit, empty to send a new one
+	previous  string
+	messageID string
+	send      *discordgo.MessageSend
+	edit      *discordgo.MessageEmbed
+}
+
+// newLiveMessage renders the live alert, the caller has to hold the lock of the source
+func (tb *TenseiBot) newLiveMessage(source LiveSource, channel LiveChannel, stream LiveStream, alert LiveAlert) *liveMessage {
+	settings := alert.Settings()
+	embed := source.LiveEmbed(channel, stream, tb.alertTimeFormat(settings))
+	return &liveMessage{
+		alert:     alert,
+		channelID: settings.ChannelID,
+		previous:  settings.MessageID,
+		messageID: settings.MessageID,
+		send:      settings.liveAlertMessage(stream, embed),
+		edit:      settings.alertEmbed(embed),
+	}
+}
+
+// postLiveMessages sends or edits the messages and stores the ids of the sent ones,
+// alerts that were removed or got another message in the meantime are left alone
+func (tb *TenseiBot) postLiveMessages(source LiveSource, channel LiveChannel, messages []*liveMessage, action string) {
+	tag := fmt.Sprintf("[%s_JOB]", strings.ToUpper(source.Name()))
+	sent := make(map[*liveMessage]string)
+	var deleted []*liveMessage
+	for _, m := range messages {
+		if m.messageID == "" {
+			msg, err := tb.Discord.c.ChannelMessageSendComplex(m.channelID, m.send)
 			if err != nil {
-				log.Errorf("[TWITCH_JOB] (stream start) failed sending embed to channel: %s, streamer: %s", alerts.ChannelID, streamer.Name)
+				log.Errorf("%s (%s) failed sending embed to channel: %s, streamer: %s", tag, action, m.channelID, channel.DisplayName())
 			} else {
-				alerts.MessageID = msg.ID
+				sent[m] = msg.ID
 			}
+			continue
 		}
-		tb.UpdateStreamer(streamer)
+		_, err := tb.Discord.c.ChannelMessageEditEmbed(m.channelID, m.messageID, m.edit)
+		if isDiscordNotFound(err) {
+			// the message got deleted, send a new one with the next poll
+			log.Warnf("%s (%s) embed in channel: %s was deleted, streamer: %s", tag, action, m.channelID, channel.DisplayName())
+			deleted = append(deleted, m)
+		} else if err != nil {
+			log.Errorf("%s (%s) failed editing embed in channel: %s, streamer: %s", tag, action, m.channelID, channel.DisplayName())
+		}
+	}
+	if len(sent) < 1 && len(deleted) < 1 {
 		return
 	}
 
-	// update the embed
-	if isStreaming(stream) && streamer.StreamLength() <= 0 {
-		// update embed
-		log.Debugf("[TWITCH_JOB] updating embeds for streamer %s", streamer.Name)
-		embed := tb.Twitch.createLiveEmbed(stream, streamer)
-		for _, alerts := range streamer.TwitchAlertSubscriptions {
-			if alerts.MessageID == "" {
-				msg, err := tb.Discord.c.ChannelMessageSendEmbed(alerts.ChannelID, embed)
-				if err != nil {
-					log.Errorf("[TWITCH_JOB] (stream update) failed sending embed to channel: %s, streamer: %s", alerts.ChannelID, streamer.Name)
-				} else {
-					alerts.MessageID = msg.ID
-					tb.UpdateStreamer(streamer)
-				}
-			} else {
-				_, err = tb.Discord.c.ChannelMessageEditEmbed(alerts.ChannelID, alerts.MessageID, embed)
-				if err != nil {
-					log.Errorf("[TWITCH_JOB] (stream update) failed editing embed in channel: %s, streamer: %s", alerts.ChannelID, streamer.Name)
-				}
-			}
+	source.Lock()
+	defer source.Unlock()
+	for m, id := range sent {
+		if hasLiveAlert(source, channel, m.alert) && m.alert.Settings().MessageID == m.previous {
+			m.alert.Settings().MessageID = id
+			source.SaveAlert(m.alert)
 		}
 	}
+	for _, m := range deleted {
+		if hasLiveAlert(source, channel, m.alert) && m.alert.Settings().MessageID == m.previous {
+			m.alert.Settings().MessageID = ""
+			source.SaveAlert(m.alert)
+		}
+	}
+}
 
-	// stream went offline
-	if !isStreaming(stream) && streamer.StreamLength() <= 0 {
-		streamer.StreamEndTime = time.Now().UTC().Add(-(time.Minute * 3))
-		log.Infof("[TWITCH_JOB] streamer %s stopped streaming length %s", streamer.Name, streamer.StreamLength())
-		embed := createEndEmbed(streamer)
-		for _, alerts := range streamer.TwitchAlertSubscriptions {
-			_, err := tb.Discord.c.Channel(alerts.ChannelID)
-			if err != nil {
-				log.Warnf("[TWITCH_JOB] error getting channel: %s")
-			}
-			_, err = tb.Discord.c.ChannelMessageEditEmbed(alerts.ChannelID, alerts.MessageID, embed)
-			if err != nil {
-				log.Errorf("[TWITCH_JOB] (stream end) failed editing embed in channel: %s, streamer: %s", alerts.ChannelID, streamer.Name)
-			}
+// hasLiveAlert returns true while the alert belongs to the tracked channel, the caller has to hold the lock
+func hasLiveAlert(source LiveSource, channel LiveChannel, alert LiveAlert) bool {
+	if !source.Tracked(channel) {
+		return false
+	}
+	for _, a := range channel.Alerts() {
+		if a == alert {
+			return true
+		}
+	}
+	return false
+}
+
+// endLiveAlerts turns the live embeds of the alerts into end embeds, StreamEndTime has to be set and the
+// caller has to run the job of the channel without holding the lock, alerts may be ones that were just removed
+func (tb *TenseiBot) endLiveAlerts(source LiveSource, channel LiveChannel, alerts []LiveAlert) {
+	tag := fmt.Sprintf("[%s_JOB]", strings.ToUpper(source.Name()))
+	source.StreamEnded(channel)
+
+	source.Lock()
+	var messages []*liveMessage
+	for _, alert := range alerts {
+		settings := alert.Settings()
+		if settings.MessageID == "" {
+			continue
 		}
-		tb.UpdateStreamer(streamer)
-	}
-}
-
-// StreamLength return how long a stream was online
-func (s *TwitchStreamer) StreamLength() time.Duration {
-	return s.StreamEndTime.Sub(s.StreamStartTime)
-}
-
-func (tt *TenseiTwitch) createLiveEmbed(stream *helix.Stream, streamer *TwitchStreamer) *discordgo.MessageEmbed {
-	channelURL := fmt.Sprintf("https://twitch.tv/%s", streamer.Name)
-	thumbnailURL := fmt.Sprintf("https://static-cdn.jtvnw.net/previews-ttv/live_user_%s-1920x1080.jpg?t=%s", streamer.Name, time.Now().Unix())
-	liveFor := time.Now().UTC().Sub(streamer.StreamStartTime)
-
-	gameName := "???"
-	game, err := tt.getGameByID(stream.GameID)
-	if err == nil {
-		gameName = game.Name
-	}
-
-	return &discordgo.MessageEmbed{
-		Author: &discordgo.MessageEmbedAuthor{
-			Name: fmt.Sprintf("%s", strings.Title(streamer.Name)),
-			URL:  channelURL,
-		},
-		Title: stream.Title,
-		URL:   channelURL,
-		Image: &discordgo.MessageEmbedImage{
-			URL: thumbnailURL,
-		},
-		Fields: []*discordgo.MessageEmbedField{
-			{
-				Name:   "Category",
-				Value:  gameName,
-				Inline: true,
-			},
-			{
-				Name:   "Viewers",
-				Value:  fmt.Sprintf("%d", stream.ViewerCount),
-				Inline: true,
-			},
-		},
-		Thumbnail: &discordgo.MessageEmbedThumbnail{
-			URL: streamer.ProfileImageURL,
-		},
-		Footer: &discordgo.MessageEmbedFooter{
-			Text: fmt.Sprintf("Live for %s", humanizeDuration(liveFor)),
-		},
-		Color: 0xFF0000,
-	}
-}
-
-func createEndEmbed(streamer *TwitchStreamer) *discordgo.MessageEmbed {
-	channelURL := fmt.Sprintf("https://twitch.tv/%s", streamer.Name)
-
-	endTime := streamer.StreamEndTime.UTC().Format(time.RFC822)
-	startTime := streamer.StreamStartTime.UTC().Format(time.RFC822)
-
-	if streamer.ChannelID == "11249217" {
-		loc, _ := time.LoadLocation("Asia/Tokyo")
-		jaFormat := "2006/02/01 15:04:05 MS"
-		endTime = streamer.StreamEndTime.In(loc).Format(jaFormat)
-		startTime = streamer.StreamStartTime.In(loc).Format(jaFormat)
-	}
-
-	var sb strings.Builder
-	sb.WriteString(fmt.Sprintf("**Started at:** %s\n", startTime))
-	sb.WriteString(fmt.Sprintf("__**Ended at:** %s__\n", endTime))
-	sb.WriteString(fmt.Sprintf("**Total Time:** %s", humanizeDuration(streamer.StreamLength())))
-
-	return &discordgo.MessageEmbed{
-		Author: &discordgo.MessageEmbedAuthor{
-			Name: fmt.Sprintf("%s was Live", strings.Title(streamer.Name)),
-			URL:  channelURL,
-		},
-		Thumbnail: &discordgo.MessageEmbedThumbnail{
-			URL: streamer.ProfileImageURL,
-		},
-		URL:         channelURL,
-		Description: sb.String(),
+		messages = append(messages, &liveMessage{
+			alert:     alert,
+			channelID: settings.ChannelID,
+			previous:  settings.MessageID,
+			messageID: settings.MessageID,
+			edit:      source.EndEmbed(channel, tb.alertTimeFormat(settings)),
+		})
+	}
+	// saving a removed channel would store it again
+	if source.Tracked(channel) {
+		source.SaveChannel(channel)
+	}
+	source.Unlock()
+
+	var ended []LiveAlert
+	for _, m := range messages {
+		_, err := tb.Discord.c.ChannelMessageEditEmbed(m.channelID, m.messageID, m.edit)
+		if isDiscordNotFound(err) {
+			log.Warnf("%s (stream end) embed in channel: %s was deleted, streamer: %s", tag, m.channelID, channel.DisplayName())
+		} else if err != nil {
+			log.Errorf("%s (stream end) failed editing embed in channel: %s, streamer: %s", tag, m.channelID, channel.DisplayName())
+		} else {
+			ended = append(ended, m.alert)
+		}
+	}
+
+	source.Lock()
+	defer source.Unlock()
+	source.AlertsEnded(channel, ended)
+}
+
+// sendLiveAlert sends the live embed with the message and mention of the subscription
+func (tb *TenseiBot) sendLiveAlert(alert *AlertSubscription, stream LiveStream, embed *discordgo.MessageEmbed) (*discordgo.Message, error) {
+	return tb.Discord.c.ChannelMessageSendComplex(alert.ChannelID, alert.liveAlertMessage(stream, embed))
+}
+
+// liveAlertMessage renders the live embed with the message and mention of the subscription
+func (alert *AlertSubscription) liveAlertMessage(stream LiveStream, embed *discordgo.MessageEmbed) *discordgo.MessageSend {
+	msg := &discordgo.MessageSend{
+		Content:         alert.alertMessage(stream.Placeholders()),
+		Embeds:          []*discordgo.MessageEmbed{alert.alertEmbed(embed)},
+		AllowedMentions: &discordgo.MessageAllowedMentions{},
+	}
+	switch alert.MentionRoleID {
+	case "":
+	case mentionEveryone:
+		msg.AllowedMentions.Parse = []discordgo.AllowedMentionType{discordgo.AllowedMentionTypeEveryone}
+	default:
+		msg.AllowedMentions.Roles = []string{alert.MentionRoleID}
+	}
+	return msg
+}
+
+// alertMessage renders the message template and mention of the subscription
+func (alert *AlertSubscription) alertMessage(placeholders map[string]string) string {
+	var parts []string
+	switch alert.MentionRoleID {
+	case "":
+	case mentionEveryone:
+		parts = append(parts, "@everyone")
+	default:
+		parts = append(parts, fmt.Sprintf("<@&%s>", alert.MentionRoleID))
+	}
+	if alert.Message != "" {
+		var replacements []string
+		for key, value := range placeholders {
+			replacements = append(replacements, "{"+key+"}", value)
+		}
+		parts = append(parts, strings.NewReplacer(replacements...).Replace(alert.Message))
+	}
+	return strings.Join(parts, " ")
+}
+
+// alertEmbed returns a copy of the live embed with the colour and thumbnail settings of the subscription
+func (alert *AlertSubscription) alertEmbed(embed *discordgo.MessageEmbed) *discordgo.MessageEmbed {
+	e := *embed
+	if alert.Color != nil {
+		e.Color = *alert.Color
+	}
+	if alert.HideThumbnail {
+		e.Image = nil
+	}
+	return &e
+}
+
+// dateFormatDiscord shows dates as discord timestamps in the local time of every reader
+const dateFormatDiscord = "discord"
+
+// timeFormat how dates are shown in alert embeds
+type timeFormat struct {
+	loc    *time.Location
+	layout string
+}
+
+func (tf timeFormat) format(t time.Time) string {
+	if tf.layout == dateFormatDiscord {
+		return fmt.Sprintf("<t:%d:F>", t.Unix())
+	}
+	return t.In(tf.loc).Format(tf.layout)
+}
+
+// newTimeFormat falls back to UTC and RFC822 for empty or invalid settings
+func newTimeFormat(timezone, layout string) timeFormat {
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		log.Warnf("[LIVE_JOB] invalid timezone %s: %v", timezone, err)
+		loc = time.UTC
+	}
+	if layout == "" {
+		layout = time.RFC822
+	}
+	return timeFormat{loc: loc, layout: layout}
+}
+
+// guildTimeFormat returns the date settings of the guild
+func (tb *TenseiBot) guildTimeFormat(guildID string) timeFormat {
+	guild := tb.GetGuildSettingsFromDB(guildID)
+	return newTimeFormat(guild.Timezone, guild.DateFormat)
+}
+
+// alertTimeFormat returns the date settings of the subscription, unset ones come from the guild
+func (tb *TenseiBot) alertTimeFormat(alert *AlertSubscription) timeFormat {
+	guild := tb.GetGuildSettingsFromDB(alert.GuildID)
+	timezone, layout := guild.Timezone, guild.DateFormat
+	if alert.Timezone != "" {
+		timezone = alert.Timezone
+	}
+	if alert.DateFormat != "" {
+		layout = alert.DateFormat
 	}
+	return newTimeFormat(timezone, layout)
 }
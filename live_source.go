@@ -0,0 +1,70 @@
+package main
+
+import (
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// LiveSource is a platform with live streams, runLiveJob runs the start, update and end
+// state machine for its channels and manages the discord messages of their alerts
+type LiveSource interface {
+	// Name of the platform, used for logs
+	Name() string
+	// Poll checks every channel of the source and calls job with its stream,
+	// nil while it is offline, it returns once all jobs are done
+	Poll(job func(channel LiveChannel, stream LiveStream))
+
+	// LiveEmbed renders the alert of a running stream, EndEmbed the one of an ended stream
+	LiveEmbed(channel LiveChannel, stream LiveStream, tf timeFormat) *discordgo.MessageEmbed
+	EndEmbed(channel LiveChannel, tf timeFormat) *discordgo.MessageEmbed
+
+	// StreamStarted, StreamUpdated and StreamEnded are called before the alerts are sent or edited,
+	// StreamEnded without the lock so it can look the stream up, it takes the lock to store the result
+	StreamStarted(channel LiveChannel, stream LiveStream)
+	StreamUpdated(channel LiveChannel, stream LiveStream)
+	StreamEnded(channel LiveChannel)
+	// AlertsEnded is called with the alerts that got their end embed
+	AlertsEnded(channel LiveChannel, alerts []LiveAlert)
+
+	// SaveChannel and SaveAlert store the state after a transition
+	SaveChannel(channel LiveChannel)
+	SaveAlert(alert LiveAlert)
+
+	// Lock and Unlock guard the channels of the source and their state, the other methods
+	// except Poll and StreamEnded are called with the lock held
+	Lock()
+	Unlock()
+	// Tracked returns true while the channel is tracked
+	Tracked(channel LiveChannel) bool
+}
+
+// LiveChannel is a channel tracked on a LiveSource
+type LiveChannel interface {
+	DisplayName() string
+	State() *LiveState
+	Alerts() []LiveAlert
+}
+
+// LiveAlert is a subscription of a discord channel to a LiveChannel
+type LiveAlert interface {
+	Settings() *AlertSubscription
+}
+
+// LiveStream is the polled state of a running stream
+type LiveStream interface {
+	StreamID() string
+	StartTime() time.Time
+	// Placeholders values for the message template of the alert, {title} is replaced by Placeholders()["title"]
+	Placeholders() map[string]string
+}
+
+// StreamLength return how long a stream was online
+func (s *LiveState) StreamLength() time.Duration {
+	return s.StreamEndTime.Sub(s.StreamStartTime)
+}
+
+// IsLive returns true while a stream is running
+func (s *LiveState) IsLive() bool {
+	return s.StreamLength() < 0
+}
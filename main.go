@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"os"
 	"os/signal"
 	"time"
@@ -11,11 +12,14 @@ import (
 
 // TenseiBot ...
 type TenseiBot struct {
-	db      *gorm.DB
-	Discord *TenseiDiscord
-	Google  *TenseiGoogle
-	Twitch  *TenseiTwitch
-	Config  *TenseiConfig
+	db        *gorm.DB
+	Discord   *TenseiDiscord
+	Google    *TenseiGoogle
+	Translate *TenseiTranslate
+	Twitch    *TenseiTwitch
+	YouTube   *TenseiYouTube
+	Feeds     *TenseiFeeds
+	Config    *TenseiConfig
 
 	started time.Time
 }
@@ -28,8 +32,21 @@ func init() {
 	// log.SetLevel(log.DebugLevel)
 }
 
+//go:generate go run . -features FEATURES.md
+
 func main() {
+	features := flag.String("features", "", "write the command table to the given file and exit")
+	flag.Parse()
+
 	tb := NewTenseiBot()
+	if *features != "" {
+		tb.Discord.prefix = "!"
+		tb.SetupDiscordCommands()
+		if err := tb.Discord.writeFeatures(*features); err != nil {
+			log.Fatalf("[FEATURES] failed writing %s: %v", *features, err)
+		}
+		return
+	}
 
 	defer tb.Close()
 	tb.Config.Load("config.json")
@@ -37,7 +54,10 @@ func main() {
 	tb.NewDatabase()
 	tb.NewDiscord()
 	tb.NewGoogle()
+	tb.NewTranslate()
 	tb.NewTwitch()
+	tb.NewYouTube()
+	tb.NewFeeds()
 
 	c := make(chan os.Signal, 1)
 	// We'll accept graceful shutdowns when quit via SIGINT (Ctrl+C)
@@ -51,11 +71,14 @@ func main() {
 // NewTenseiBot ...
 func NewTenseiBot() *TenseiBot {
 	return &TenseiBot{
-		Discord: new(TenseiDiscord),
-		Google:  new(TenseiGoogle),
-		Twitch:  new(TenseiTwitch),
-		Config:  new(TenseiConfig),
-		started: time.Now(),
+		Discord:   new(TenseiDiscord),
+		Google:    new(TenseiGoogle),
+		Translate: new(TenseiTranslate),
+		Twitch:    new(TenseiTwitch),
+		YouTube:   new(TenseiYouTube),
+		Feeds:     new(TenseiFeeds),
+		Config:    new(TenseiConfig),
+		started:   time.Now(),
 	}
 }
 
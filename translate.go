@@ -0,0 +1,311 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	translatorGoogle = "google"
+	translatorDeepL  = "deepl"
+	translatorLibre  = "libretranslate"
+	// translatorAuto guild setting that uses the configured order
+	translatorAuto = "auto"
+
+	// translateQuotaBackoff a provider that ran out of quota is skipped for this long
+	translateQuotaBackoff = time.Hour
+)
+
+var (
+	errTranslateQuota       = errors.New("quota exceeded")
+	errTranslateUnsupported = errors.New("not supported")
+	// errTranslateBudget the guild translated its monthly characters
+	errTranslateBudget = errors.New("monthly translation budget exceeded")
+)
+
+// translatorTitles names shown in the footer of translations
+var translatorTitles = map[string]string{
+	translatorGoogle: "Google Cloud Translate",
+	translatorDeepL:  "DeepL",
+	translatorLibre:  "LibreTranslate",
+}
+
+// Translator is a translation backend, errors wrapping errTranslateQuota
+// make the bot skip it for a while
+type Translator interface {
+	// Name key of the provider used in the config and guild settings
+	Name() string
+	// Translate translates text to the target language, source is detected when empty
+	Translate(text, source, target string) (*translation, error)
+	Detect(text string) (*detection, error)
+	Languages() ([]translateLanguage, error)
+}
+
+type translation struct {
+	text string
+	// source language code, the detected one when none was given
+	source string
+	// confidence of the detected source from 0 to 1, 0 when the provider doesn't tell
+	confidence float64
+	// provider name of the translator that answered
+	provider string
+}
+
+type detection struct {
+	language string
+	// confidence from 0 to 1, 0 when the provider doesn't tell
+	confidence float64
+	provider   string
+}
+
+type translateLanguage struct {
+	code string
+	name string
+}
+
+// TenseiTranslate picks the translator for a request and falls back to the others
+type TenseiTranslate struct {
+	// translators in fallback order
+	translators []Translator
+	// languages of every translator, the first name of a code wins
+	languages []translateLanguage
+
+	cache *translationCache
+	// monthlyCharacters each guild can translate per month, 0 is unlimited
+	monthlyCharacters int
+
+	mutex sync.Mutex
+	// exhausted translators and when they are tried again
+	exhausted map[string]time.Time
+
+	linksMutex sync.Mutex
+	// links channels whose messages are translated into their partner channel
+	links []*TranslateLink
+	// mirrored translations posted for source messages, mirroredOrder oldest first
+	mirrored      map[string][]mirroredMessage
+	mirroredOrder []string
+}
+
+// NewTranslate sets up the configured translators
+func (tb *TenseiBot) NewTranslate() {
+	cfg := tb.Config.Translate
+	available := map[string]Translator{translatorGoogle: tb.Google}
+	if cfg.DeepL.APIKey != "" {
+		available[translatorDeepL] = newDeepLTranslator(cfg.DeepL.APIURL, cfg.DeepL.APIKey)
+	}
+	if cfg.LibreTranslate.URL != "" {
+		available[translatorLibre] = newLibreTranslator(cfg.LibreTranslate.URL, cfg.LibreTranslate.APIKey)
+	}
+
+	order := cfg.Order
+	if len(order) < 1 {
+		order = []string{translatorGoogle, translatorDeepL, translatorLibre}
+	}
+	tb.Translate.exhausted = make(map[string]time.Time)
+	tb.Translate.cache = tb.newTranslationCache()
+	tb.Translate.monthlyCharacters = cfg.MonthlyCharacters
+	for _, name := range order {
+		translator, ok := available[strings.ToLower(name)]
+		if !ok {
+			continue
+		}
+		delete(available, strings.ToLower(name))
+		tb.Translate.translators = append(tb.Translate.translators, translator)
+	}
+
+	seen := make(map[string]bool)
+	for _, translator := range tb.Translate.translators {
+		languages, err := translator.Languages()
+		if err != nil {
+			log.Warnf("[TRANSLATE] failed getting languages of %s: %v", translator.Name(), err)
+			continue
+		}
+		for _, l := range languages {
+			if !seen[strings.ToLower(l.code)] {
+				seen[strings.ToLower(l.code)] = true
+				tb.Translate.languages = append(tb.Translate.languages, l)
+			}
+		}
+	}
+	log.Infof("[TRANSLATE] using %s", strings.Join(tb.Translate.names(), ", "))
+	tb.loadTranslateLinks()
+}
+
+func (tt *TenseiTranslate) names() []string {
+	names := make([]string, 0, len(tt.translators))
+	for _, translator := range tt.translators {
+		names = append(names, translator.Name())
+	}
+	return names
+}
+
+// guildTranslators returns the translators in the order they are tried for the guild,
+// the one selected by the guild first
+func (tb *TenseiBot) guildTranslators(guildID string) []Translator {
+	selected := tb.GetGuildSettingsFromDB(guildID).Translator
+	translators := make([]Translator, 0, len(tb.Translate.translators))
+	for _, translator := range tb.Translate.translators {
+		if translator.Name() == selected {
+			translators = append([]Translator{translator}, translators...)
+		} else {
+			translators = append(translators, translator)
+		}
+	}
+	return translators
+}
+
+// available returns false while the translator is out of quota
+func (tt *TenseiTranslate) available(translator Translator) bool {
+	tt.mutex.Lock()
+	defer tt.mutex.Unlock()
+	return time.Now().After(tt.exhausted[translator.Name()])
+}
+
+// failed remembers translators that ran out of quota
+func (tt *TenseiTranslate) failed(translator Translator, err error) {
+	log.Warnf("[TRANSLATE] %s failed: %v", translator.Name(), err)
+	if !errors.Is(err, errTranslateQuota) {
+		return
+	}
+	tt.mutex.Lock()
+	defer tt.mutex.Unlock()
+	tt.exhausted[translator.Name()] = time.Now().Add(translateQuotaBackoff)
+	log.Warnf("[TRANSLATE] skipping %s for %s", translator.Name(), translateQuotaBackoff)
+}
+
+// translateText returns a cached translation or asks the translators of the guild until one answers,
+// only translations that weren't cached count towards the monthly budget
+func (tb *TenseiBot) translateText(guildID, text, source, target string) (*translation, error) {
+	translators := tb.guildTranslators(guildID)
+	for _, translator := range translators {
+		if result := tb.Translate.cache.get(translator.Name(), text, source, target); result != nil {
+			tb.Translate.cache.count(true)
+			return result, nil
+		}
+	}
+	tb.Translate.cache.count(false)
+
+	characters := utf8.RuneCountInString(text)
+	if used, limit := tb.translateBudget(guildID); limit > 0 && used+characters > limit {
+		return nil, errTranslateBudget
+	}
+
+	var errs []string
+	for _, translator := range translators {
+		if !tb.Translate.available(translator) {
+			continue
+		}
+		result, err := translator.Translate(text, source, target)
+		if err != nil {
+			tb.Translate.failed(translator, err)
+			errs = append(errs, fmt.Sprintf("%s: %v", translator.Name(), err))
+			continue
+		}
+		result.provider = translator.Name()
+		tb.Translate.cache.put(translator.Name(), text, source, target, result)
+		tb.AddTranslationUsage(guildID, translateMonth(), characters)
+		return result, nil
+	}
+	return nil, fmt.Errorf("no translator answered: %s", strings.Join(errs, "; "))
+}
+
+// translateMonth month the translated characters count towards
+func translateMonth() string {
+	return time.Now().UTC().Format("2006-01")
+}
+
+// translateBudget returns the characters the guild translated this month and its limit, 0 is unlimited
+func (tb *TenseiBot) translateBudget(guildID string) (int, int) {
+	return tb.GetTranslationUsage(guildID, translateMonth()), tb.Translate.monthlyCharacters
+}
+
+// detectLanguage asks the translators of the guild until one detects the language
+func (tb *TenseiBot) detectLanguage(guildID, text string) (*detection, error) {
+	var errs []string
+	for _, translator := range tb.guildTranslators(guildID) {
+		if !tb.Translate.available(translator) {
+			continue
+		}
+		result, err := translator.Detect(text)
+		if errors.Is(err, errTranslateUnsupported) {
+			continue
+		}
+		if err != nil {
+			tb.Translate.failed(translator, err)
+			errs = append(errs, fmt.Sprintf("%s: %v", translator.Name(), err))
+			continue
+		}
+		result.provider = translator.Name()
+		return result, nil
+	}
+	return nil, fmt.Errorf("no translator answered: %s", strings.Join(errs, "; "))
+}
+
+// resolveLanguage returns the code of a language given by code or english name
+func (tt *TenseiTranslate) resolveLanguage(value string) string {
+	for _, l := range tt.languages {
+		if strings.EqualFold(value, l.code) {
+			return l.code
+		}
+	}
+	for _, l := range tt.languages {
+		if strings.EqualFold(value, l.name) {
+			return l.code
+		}
+	}
+	return value
+}
+
+// languagePair resolves a target language or a from:to pair, source is empty
+// when it should be detected
+func (tt *TenseiTranslate) languagePair(value string) (string, string) {
+	parts := strings.SplitN(value, ":", 2)
+	if len(parts) < 2 || parts[0] == "" {
+		return "", tt.resolveLanguage(strings.TrimPrefix(value, ":"))
+	}
+	return tt.resolveLanguage(parts[0]), tt.resolveLanguage(parts[1])
+}
+
+// supported returns true when one of the translators knows the language code
+func (tt *TenseiTranslate) supported(code string) bool {
+	for _, l := range tt.languages {
+		if strings.EqualFold(code, l.code) {
+			return true
+		}
+	}
+	return false
+}
+
+// languageName returns the english name of the language code
+func (tt *TenseiTranslate) languageName(code string) string {
+	for _, l := range tt.languages {
+		if strings.EqualFold(code, l.code) {
+			return l.name
+		}
+	}
+	return code
+}
+
+func translatorTitle(name string) string {
+	if title, ok := translatorTitles[name]; ok {
+		return title
+	}
+	return name
+}
+
+// completeTranslators suggests the translators guilds can select
+func (tb *TenseiBot) completeTranslators(value string) []string {
+	var names []string
+	for _, name := range append([]string{translatorAuto}, tb.Translate.names()...) {
+		if strings.HasPrefix(name, strings.ToLower(value)) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
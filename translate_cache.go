@@ -0,0 +1,165 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	translateCacheSize = 1000
+	translateCacheTTL  = 24 * time.Hour
+	// translateCachePruneInterval expired translations are deleted from the database this often
+	translateCachePruneInterval = time.Hour
+)
+
+// translationCache least recently used translations, optionally backed by the database
+type translationCache struct {
+	size int
+	ttl  time.Duration
+	// load and store read and write the database, nil without database
+	load  func(hash string, since time.Time) (*TranslationCacheEntry, error)
+	store func(entry *TranslationCacheEntry)
+
+	mutex sync.Mutex
+	// order most recently used first, entries the elements by hash
+	order   *list.List
+	entries map[string]*list.Element
+	hits    int
+	misses  int
+}
+
+type cachedTranslation struct {
+	hash   string
+	source string
+	// confidence isn't stored in the database, translations loaded from it have none
+	confidence float64
+	text       string
+	created    time.Time
+}
+
+// newTranslationCache applies the cache settings of the config
+func (tb *TenseiBot) newTranslationCache() *translationCache {
+	cfg := tb.Config.Translate.Cache
+	cache := &translationCache{
+		size:    cfg.Size,
+		ttl:     translateCacheTTL,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+	if cache.size <= 0 {
+		cache.size = translateCacheSize
+	}
+	if cfg.TTL != "" {
+		ttl, err := time.ParseDuration(cfg.TTL)
+		if err != nil || ttl <= 0 {
+			log.Warnf("[TRANSLATE] invalid cache ttl %s, using %s", cfg.TTL, translateCacheTTL)
+		} else {
+			cache.ttl = ttl
+		}
+	}
+	if cfg.Database {
+		cache.load = tb.GetTranslationCacheEntry
+		cache.store = tb.AddTranslationCacheEntry
+		go func() {
+			tb.RemoveExpiredTranslations(time.Now().Add(-cache.ttl))
+			ticker := time.NewTicker(translateCachePruneInterval)
+			for range ticker.C {
+				tb.RemoveExpiredTranslations(time.Now().Add(-cache.ttl))
+			}
+		}()
+	}
+	return cache
+}
+
+// translationHash identifies a translation, texts that only differ in whitespace share it
+func translationHash(provider, text, source, target string) string {
+	if source == "" {
+		source = "auto"
+	}
+	text = strings.Join(strings.Fields(text), " ")
+	sum := sha256.Sum256([]byte(strings.Join([]string{provider, strings.ToLower(source), strings.ToLower(target), text}, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+// get returns the cached translation of the provider, nil when there is none
+func (c *translationCache) get(provider, text, source, target string) *translation {
+	hash := translationHash(provider, text, source, target)
+	c.mutex.Lock()
+	if element, ok := c.entries[hash]; ok {
+		entry := element.Value.(*cachedTranslation)
+		if time.Since(entry.created) < c.ttl {
+			c.order.MoveToFront(element)
+			c.mutex.Unlock()
+			return &translation{text: entry.text, source: entry.source, confidence: entry.confidence, provider: provider}
+		}
+		c.remove(element)
+	}
+	c.mutex.Unlock()
+
+	if c.load == nil {
+		return nil
+	}
+	stored, err := c.load(hash, time.Now().Add(-c.ttl))
+	if err != nil {
+		return nil
+	}
+	c.add(&cachedTranslation{hash: hash, source: stored.Source, text: stored.Text, created: stored.CreatedAt})
+	return &translation{text: stored.Text, source: stored.Source, provider: provider}
+}
+
+// put caches the translation of the provider
+func (c *translationCache) put(provider, text, source, target string, result *translation) {
+	entry := &cachedTranslation{
+		hash:       translationHash(provider, text, source, target),
+		source:     result.source,
+		confidence: result.confidence,
+		text:       result.text,
+		created:    time.Now(),
+	}
+	c.add(entry)
+	if c.store != nil {
+		c.store(&TranslationCacheEntry{Hash: entry.hash, Source: entry.source, Text: entry.text})
+	}
+}
+
+func (c *translationCache) add(entry *cachedTranslation) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if element, ok := c.entries[entry.hash]; ok {
+		c.remove(element)
+	}
+	c.entries[entry.hash] = c.order.PushFront(entry)
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+// remove drops the element, the caller has to hold mutex
+func (c *translationCache) remove(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.entries, element.Value.(*cachedTranslation).hash)
+}
+
+// count records if a request was answered from the cache
+func (c *translationCache) count(hit bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if hit {
+		c.hits++
+	} else {
+		c.misses++
+	}
+}
+
+// stats returns the hits, misses and cached translations in memory
+func (c *translationCache) stats() (int, int, int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.hits, c.misses, c.order.Len()
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"container/list"
+	"testing"
+	"time"
+)
+
+func TestTranslationHash(t *testing.T) {
+	base := translationHash(translatorDeepL, "hello there", "", "de")
+	for _, test := range []struct {
+		name     string
+		provider string
+		text     string
+		source   string
+		target   string
+		same     bool
+	}{
+		{"same request", translatorDeepL, "hello there", "", "de", true},
+		{"auto source", translatorDeepL, "hello there", "auto", "de", true},
+		{"surrounding whitespace", translatorDeepL, "  hello there\n", "", "de", true},
+		{"inner whitespace", translatorDeepL, "hello \t\n there", "", "de", true},
+		{"target case", translatorDeepL, "hello there", "", "DE", true},
+		{"other provider", translatorLibre, "hello there", "", "de", false},
+		{"other text", translatorDeepL, "hello there!", "", "de", false},
+		{"text case", translatorDeepL, "Hello there", "", "de", false},
+		{"given source", translatorDeepL, "hello there", "en", "de", false},
+		{"other target", translatorDeepL, "hello there", "", "fr", false},
+	} {
+		if same := translationHash(test.provider, test.text, test.source, test.target) == base; same != test.same {
+			t.Errorf("%s: got same hash %t, want %t", test.name, same, test.same)
+		}
+	}
+}
+
+func newTestTranslationCache(size int, ttl time.Duration) *translationCache {
+	return &translationCache{size: size, ttl: ttl, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+func TestTranslationCacheEviction(t *testing.T) {
+	cache := newTestTranslationCache(2, time.Hour)
+	for _, text := range []string{"one", "two"} {
+		cache.put(translatorGoogle, text, "", "de", &translation{text: text + " de", source: "en"})
+	}
+	// reading one makes two the least recently used
+	if result := cache.get(translatorGoogle, "one", "", "de"); result == nil || result.text != "one de" {
+		t.Fatalf("got %+v, want the cached translation of one", result)
+	}
+	cache.put(translatorGoogle, "three", "", "de", &translation{text: "three de", source: "en"})
+
+	if result := cache.get(translatorGoogle, "two", "", "de"); result != nil {
+		t.Fatalf("least recently used translation wasn't evicted, got %+v", result)
+	}
+	for _, text := range []string{"one", "three"} {
+		if result := cache.get(translatorGoogle, text, "", "de"); result == nil || result.provider != translatorGoogle {
+			t.Fatalf("translation of %s was evicted, got %+v", text, result)
+		}
+	}
+	if _, _, cached := cache.stats(); cached != 2 {
+		t.Fatalf("got %d cached translations, want 2", cached)
+	}
+}
+
+func TestTranslationCacheExpiry(t *testing.T) {
+	cache := newTestTranslationCache(10, time.Hour)
+	cache.add(&cachedTranslation{
+		hash:    translationHash(translatorGoogle, "old", "", "de"),
+		text:    "alt",
+		created: time.Now().Add(-2 * time.Hour),
+	})
+	cache.put(translatorGoogle, "new", "", "de", &translation{text: "neu"})
+
+	if result := cache.get(translatorGoogle, "old", "", "de"); result != nil {
+		t.Fatalf("got expired translation %+v", result)
+	}
+	if result := cache.get(translatorGoogle, "new", "", "de"); result == nil || result.text != "neu" {
+		t.Fatalf("got %+v, want the fresh translation", result)
+	}
+	// expired translations are dropped when they are read
+	if _, _, cached := cache.stats(); cached != 1 {
+		t.Fatalf("got %d cached translations, want 1", cached)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	deeplAPIURL     = "https://api.deepl.com/v2"
+	deeplFreeAPIURL = "https://api-free.deepl.com/v2"
+	// deeplQuotaExceeded status deepl answers with when the character limit is reached
+	deeplQuotaExceeded = 456
+)
+
+// deeplTranslator translates with the deepl api
+type deeplTranslator struct {
+	apiURL     string
+	apiKey     string
+	httpClient *http.Client
+}
+
+// newDeepLTranslator uses the free api for free keys when apiURL is empty
+func newDeepLTranslator(apiURL, apiKey string) *deeplTranslator {
+	if apiURL == "" {
+		apiURL = deeplAPIURL
+		if strings.HasSuffix(apiKey, ":fx") {
+			apiURL = deeplFreeAPIURL
+		}
+	}
+	return &deeplTranslator{
+		apiURL:     strings.TrimSuffix(apiURL, "/"),
+		apiKey:     apiKey,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Name ...
+func (d *deeplTranslator) Name() string {
+	return translatorDeepL
+}
+
+func (d *deeplTranslator) do(req *http.Request, v interface{}) error {
+	req.Header.Set("Authorization", "DeepL-Auth-Key "+d.apiKey)
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == deeplQuotaExceeded || resp.StatusCode == http.StatusTooManyRequests:
+		return fmt.Errorf("%w: status %d", errTranslateQuota, resp.StatusCode)
+	case resp.StatusCode != http.StatusOK:
+		var body struct {
+			Message string `json:"message"`
+		}
+		data, _ := ioutil.ReadAll(resp.Body)
+		_ = json.Unmarshal(data, &body)
+		return fmt.Errorf("status %d: %s", resp.StatusCode, body.Message)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// deeplTargets target codes deepl uses for the codes of the other translators,
+// plain en and pt are deprecated and deepl only knows chinese scripts, not regions
+var deeplTargets = map[string]string{
+	"en":    "EN-US",
+	"pt":    "PT-BR",
+	"zh":    "ZH-HANS",
+	"zh-cn": "ZH-HANS",
+	"zh-sg": "ZH-HANS",
+	"zh-tw": "ZH-HANT",
+	"zh-hk": "ZH-HANT",
+}
+
+// deeplTarget returns the deepl target code of the language
+func deeplTarget(code string) string {
+	if target, ok := deeplTargets[strings.ToLower(code)]; ok {
+		return target
+	}
+	return strings.ToUpper(code)
+}
+
+// Translate deepl wants upper case codes and source languages without region
+func (d *deeplTranslator) Translate(text, source, target string) (*translation, error) {
+	form := url.Values{
+		"text":        {text},
+		"target_lang": {deeplTarget(target)},
+	}
+	if source != "" {
+		form.Set("source_lang", strings.ToUpper(strings.SplitN(source, "-", 2)[0]))
+	}
+	req, err := http.NewRequest(http.MethodPost, d.apiURL+"/translate", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var resp struct {
+		Translations []struct {
+			DetectedSourceLanguage string `json:"detected_source_language"`
+			Text                   string `json:"text"`
+		} `json:"translations"`
+	}
+	if err := d.do(req, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Translations) < 1 {
+		return nil, fmt.Errorf("empty response")
+	}
+	result := &translation{text: resp.Translations[0].Text, source: source}
+	if source == "" {
+		result.source = strings.ToLower(resp.Translations[0].DetectedSourceLanguage)
+	}
+	return result, nil
+}
+
+// Detect deepl has no detection without translating
+func (d *deeplTranslator) Detect(text string) (*detection, error) {
+	return nil, errTranslateUnsupported
+}
+
+// Languages returns the target languages, they include the source languages
+func (d *deeplTranslator) Languages() ([]translateLanguage, error) {
+	req, err := http.NewRequest(http.MethodGet, d.apiURL+"/languages?type=target", nil)
+	if err != nil {
+		return nil, err
+	}
+	var resp []struct {
+		Language string `json:"language"`
+		Name     string `json:"name"`
+	}
+	if err := d.do(req, &resp); err != nil {
+		return nil, err
+	}
+	languages := make([]translateLanguage, 0, len(resp))
+	for _, l := range resp {
+		languages = append(languages, translateLanguage{code: strings.ToLower(l.Language), name: l.Name})
+	}
+	return languages, nil
+}
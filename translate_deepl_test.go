@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestDeepLTarget(t *testing.T) {
+	for code, want := range map[string]string{
+		"en":    "EN-US",
+		"EN":    "EN-US",
+		"en-GB": "EN-GB",
+		"pt":    "PT-BR",
+		"pt-PT": "PT-PT",
+		"zh":    "ZH-HANS",
+		"zh-CN": "ZH-HANS",
+		"zh-TW": "ZH-HANT",
+		"zh-HK": "ZH-HANT",
+		"de":    "DE",
+		"ja":    "JA",
+	} {
+		if got := deeplTarget(code); got != want {
+			t.Errorf("deeplTarget(%q) = %q, want %q", code, got, want)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/text/language"
+)
+
+const (
+	// regional indicator symbols, a flag is the pair of its country code
+	regionalIndicatorA = 0x1F1E6
+	regionalIndicatorZ = 0x1F1FF
+)
+
+// flagLanguages flags whose likely language isn't the code translators use
+var flagLanguages = map[string]string{
+	"CN": "zh-CN",
+	"TW": "zh-TW",
+	"HK": "zh-TW",
+	"MO": "zh-TW",
+}
+
+// flagLanguage returns the language most likely spoken in the country of a flag emoji
+func flagLanguage(emoji string) (string, bool) {
+	runes := []rune(emoji)
+	if len(runes) != 2 {
+		return "", false
+	}
+	var code strings.Builder
+	for _, r := range runes {
+		if r < regionalIndicatorA || r > regionalIndicatorZ {
+			return "", false
+		}
+		code.WriteRune('A' + r - regionalIndicatorA)
+	}
+	if lang, ok := flagLanguages[code.String()]; ok {
+		return lang, true
+	}
+	region, err := language.ParseRegion(code.String())
+	if err != nil {
+		return "", false
+	}
+	tag, err := language.Compose(language.Und, region)
+	if err != nil {
+		return "", false
+	}
+	base, confidence := tag.Base()
+	if confidence == language.No {
+		return "", false
+	}
+	return base.String(), true
+}
+
+// translateReaction replies with the translation of a message someone reacted to with a flag
+func (tb *TenseiBot) translateReaction(s *discordgo.Session, r *discordgo.MessageReactionAdd) {
+	if r.GuildID == "" || r.Emoji.ID != "" || r.Member == nil || r.Member.User == nil || r.Member.User.Bot {
+		return
+	}
+	target, ok := flagLanguage(r.Emoji.Name)
+	if !ok {
+		return
+	}
+	if !tb.Translate.supported(target) {
+		// zh-TW and co. fall back to the language without region
+		target = strings.SplitN(target, "-", 2)[0]
+		if !tb.Translate.supported(target) {
+			return
+		}
+	}
+	set := tb.GetGuildSettingsFromDB(r.GuildID)
+	if !set.FlagTranslate {
+		return
+	}
+
+	m, err := tb.Discord.cachedMessage(r.ChannelID, r.MessageID)
+	if err != nil {
+		log.Warnf("[TRANSLATE] failed getting message %s for flag reaction: %v", r.MessageID, err)
+		return
+	}
+	text := strings.TrimSpace(m.Content)
+	if text == "" {
+		return
+	}
+	if tb.isDiscordCommandOnCD("tr", r.ChannelID, r.Member, *set.TranslateCooldown, set) {
+		log.Debugf("[TRANSLATE] flag reaction in channel %s is on cd", r.ChannelID)
+		return
+	}
+
+	output, err := tb.translateText(r.GuildID, text, "", target)
+	if errors.Is(err, errTranslateBudget) {
+		log.Debugf("[TRANSLATE] guild %s is out of budget, ignoring flag reaction", r.GuildID)
+		return
+	}
+	if err != nil {
+		log.Infof("[TRANSLATE] failed translating '%s', error: %v", text, err)
+		return
+	}
+	log.Infof("[TRANSLATE] flag %s used in server: %s, user: %s(%s)", r.Emoji.Name, r.GuildID, r.Member.User.String(), r.Member.User.ID)
+
+	_, err = s.ChannelMessageSendComplex(r.ChannelID, &discordgo.MessageSend{
+		Embeds:          []*discordgo.MessageEmbed{tb.translationEmbed(text, target, output)},
+		Reference:       m.Reference(),
+		AllowedMentions: &discordgo.MessageAllowedMentions{},
+	})
+	if err != nil {
+		log.Errorf("[DISCORD] error sending message to channel %s, err: %v", r.ChannelID, err)
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestFlagLanguage(t *testing.T) {
+	for _, test := range []struct {
+		emoji string
+		want  string
+		ok    bool
+	}{
+		{"🇯🇵", "ja", true},
+		{"🇩🇪", "de", true},
+		{"🇧🇷", "pt", true},
+		{"🇺🇸", "en", true},
+		{"🇨🇳", "zh-CN", true},
+		{"🇹🇼", "zh-TW", true},
+		{"🇭🇰", "zh-TW", true},
+		// not a flag
+		{"👍", "", false},
+		{"🇯", "", false},
+		{"🇯🇵🇯🇵", "", false},
+		{"JP", "", false},
+		{"", "", false},
+	} {
+		got, ok := flagLanguage(test.emoji)
+		if got != test.want || ok != test.ok {
+			t.Errorf("flagLanguage(%q) = %q, %t, want %q, %t", test.emoji, got, ok, test.want, test.ok)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// libreTranslator translates with a self-hosted libretranslate server
+type libreTranslator struct {
+	url        string
+	apiKey     string
+	httpClient *http.Client
+}
+
+func newLibreTranslator(url, apiKey string) *libreTranslator {
+	return &libreTranslator{
+		url:        strings.TrimSuffix(url, "/"),
+		apiKey:     apiKey,
+		httpClient: &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+// Name ...
+func (l *libreTranslator) Name() string {
+	return translatorLibre
+}
+
+// do sends the request with the api key, body is nil for GET requests
+func (l *libreTranslator) do(path string, body map[string]string, v interface{}) error {
+	var resp *http.Response
+	var err error
+	if body == nil {
+		resp, err = l.httpClient.Get(l.url + path)
+	} else {
+		if l.apiKey != "" {
+			body["api_key"] = l.apiKey
+		}
+		data, _ := json.Marshal(body)
+		resp, err = l.httpClient.Post(l.url+path, "application/json", bytes.NewReader(data))
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error string `json:"error"`
+		}
+		data, _ := ioutil.ReadAll(resp.Body)
+		_ = json.Unmarshal(data, &e)
+		if resp.StatusCode == http.StatusTooManyRequests {
+			return fmt.Errorf("%w: %s", errTranslateQuota, e.Error)
+		}
+		return fmt.Errorf("status %d: %s", resp.StatusCode, e.Error)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// Translate ...
+func (l *libreTranslator) Translate(text, source, target string) (*translation, error) {
+	body := map[string]string{
+		"q":      text,
+		"source": "auto",
+		"target": strings.ToLower(target),
+		"format": "text",
+	}
+	if source != "" {
+		body["source"] = strings.ToLower(source)
+	}
+	var resp struct {
+		TranslatedText   string `json:"translatedText"`
+		DetectedLanguage struct {
+			Language   string  `json:"language"`
+			Confidence float64 `json:"confidence"`
+		} `json:"detectedLanguage"`
+	}
+	if err := l.do("/translate", body, &resp); err != nil {
+		return nil, err
+	}
+	result := &translation{text: resp.TranslatedText, source: source}
+	if source == "" {
+		result.source = resp.DetectedLanguage.Language
+		result.confidence = resp.DetectedLanguage.Confidence / 100
+	}
+	return result, nil
+}
+
+// Detect libretranslate reports confidence from 0 to 100
+func (l *libreTranslator) Detect(text string) (*detection, error) {
+	var resp []struct {
+		Language   string  `json:"language"`
+		Confidence float64 `json:"confidence"`
+	}
+	if err := l.do("/detect", map[string]string{"q": text}, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp) < 1 {
+		return nil, fmt.Errorf("no language detected")
+	}
+	return &detection{language: resp[0].Language, confidence: resp[0].Confidence / 100}, nil
+}
+
+// Languages ...
+func (l *libreTranslator) Languages() ([]translateLanguage, error) {
+	var resp []struct {
+		Code string `json:"code"`
+		Name string `json:"name"`
+	}
+	if err := l.do("/languages", nil, &resp); err != nil {
+		return nil, err
+	}
+	languages := make([]translateLanguage, 0, len(resp))
+	for _, lang := range resp {
+		languages = append(languages, translateLanguage{code: lang.Code, name: lang.Name})
+	}
+	return languages, nil
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// translateLinkWebhookName name of the webhooks translations are posted with
+	translateLinkWebhookName = "Tensei Translate"
+	// translateLinkMessages source messages remembered for edits and deletes
+	translateLinkMessages = 1000
+	translateLinksPerPage = 10
+)
+
+// mirroredMessage translation posted for a source message, link is a copy
+// so it stays usable after the link changed
+type mirroredMessage struct {
+	link      TranslateLink
+	messageID string
+}
+
+// loadTranslateLinks loads the linked channels from the database
+func (tb *TenseiBot) loadTranslateLinks() {
+	tb.Translate.linksMutex.Lock()
+	defer tb.Translate.linksMutex.Unlock()
+	tb.Translate.mirrored = make(map[string][]mirroredMessage)
+	tb.Translate.links = tb.GetTranslateLinks()
+	log.Infof("[TRANSLATE] loaded %d channel links", len(tb.Translate.links))
+}
+
+// linksFrom returns copies of the links mirroring the channel
+func (tt *TenseiTranslate) linksFrom(channelID string) []TranslateLink {
+	tt.linksMutex.Lock()
+	defer tt.linksMutex.Unlock()
+	var links []TranslateLink
+	for _, link := range tt.links {
+		if link.ChannelID == channelID {
+			links = append(links, *link)
+		}
+	}
+	return links
+}
+
+// link returns the link from channelID to targetChannelID, the caller has to hold linksMutex
+func (tt *TenseiTranslate) link(channelID, targetChannelID string) *TranslateLink {
+	for _, link := range tt.links {
+		if link.ChannelID == channelID && link.TargetChannelID == targetChannelID {
+			return link
+		}
+	}
+	return nil
+}
+
+// linkWebhook returns the webhook posting into the channel, links to the same channel share it,
+// the caller has to hold linksMutex
+func (tt *TenseiTranslate) linkWebhook(s *discordgo.Session, channelID string) (string, string, error) {
+	for _, link := range tt.links {
+		if link.TargetChannelID == channelID {
+			return link.WebhookID, link.WebhookToken, nil
+		}
+	}
+	webhook, err := s.WebhookCreate(channelID, translateLinkWebhookName, "")
+	if err != nil {
+		return "", "", err
+	}
+	return webhook.ID, webhook.Token, nil
+}
+
+// deleteUnusedWebhook deletes the webhook of the link unless another link still uses it,
+// the caller has to hold linksMutex
+func (tt *TenseiTranslate) deleteUnusedWebhook(s *discordgo.Session, link *TranslateLink) {
+	for _, l := range tt.links {
+		if l.WebhookID == link.WebhookID {
+			return
+		}
+	}
+	if err := s.WebhookDelete(link.WebhookID); err != nil && !isDiscordNotFound(err) {
+		log.Warnf("[TRANSLATE] failed deleting webhook of channel %s: %v", link.TargetChannelID, err)
+	}
+}
+
+// renewLinkWebhook replaces a webhook someone deleted and returns the link with the new one
+func (tb *TenseiBot) renewLinkWebhook(s *discordgo.Session, link TranslateLink) (TranslateLink, error) {
+	tb.Translate.linksMutex.Lock()
+	defer tb.Translate.linksMutex.Unlock()
+	webhook, err := s.WebhookCreate(link.TargetChannelID, translateLinkWebhookName, "")
+	if err != nil {
+		return link, err
+	}
+	log.Infof("[TRANSLATE] replaced deleted webhook of channel %s", link.TargetChannelID)
+	for _, l := range tb.Translate.links {
+		if l.TargetChannelID == link.TargetChannelID {
+			l.WebhookID, l.WebhookToken = webhook.ID, webhook.Token
+			tb.UpdateTranslateLink(l)
+		}
+	}
+	link.WebhookID, link.WebhookToken = webhook.ID, webhook.Token
+	return link, nil
+}
+
+// isMirrorable returns false for messages that aren't relayed to linked channels
+func (tb *TenseiBot) isMirrorable(s *discordgo.Session, m *discordgo.Message) bool {
+	// webhook messages include our own translations, relaying them would loop
+	if m.Author == nil || m.Author.Bot || m.WebhookID != "" {
+		return false
+	}
+	if m.Type != discordgo.MessageTypeDefault && m.Type != discordgo.MessageTypeReply {
+		return false
+	}
+	if _, ok := tb.trimPrefix(s, &discordgo.MessageCreate{Message: m}); ok {
+		return false
+	}
+	return strings.TrimSpace(m.Content) != "" || len(m.Attachments) > 0
+}
+
+// linkedContent translates the message to the language of the link, text already
+// in that language and attachments are passed on as they are
+func (tb *TenseiBot) linkedContent(m *discordgo.Message, link TranslateLink) (string, error) {
+	var lines []string
+	if text := strings.TrimSpace(m.Content); text != "" {
+		result, err := tb.translateText(link.GuildID, text, "", link.Language)
+		if err != nil {
+			return "", err
+		}
+		if sameLanguage(result.source, link.Language) {
+			lines = append(lines, text)
+		} else {
+			lines = append(lines, result.text)
+		}
+	}
+	for _, attachment := range m.Attachments {
+		lines = append(lines, attachment.URL)
+	}
+	content := strings.Join(lines, "\n")
+	if utf8.RuneCountInString(content) > discordMessageLimit {
+		content = string([]rune(content)[:discordMessageLimit-3]) + "..."
+	}
+	return content, nil
+}
+
+// sameLanguage compares language codes, a code without region matches all regions
+func sameLanguage(a, b string) bool {
+	if strings.EqualFold(a, b) {
+		return true
+	}
+	baseA, baseB := strings.SplitN(a, "-", 2), strings.SplitN(b, "-", 2)
+	return (len(baseA) == 1 || len(baseB) == 1) && strings.EqualFold(baseA[0], baseB[0])
+}
+
+// authorName name the author is shown with in the linked channel
+func authorName(m *discordgo.Message) string {
+	if m.Member != nil && m.Member.Nick != "" {
+		return m.Member.Nick
+	}
+	return m.Author.Username
+}
+
+// mirrorMessage posts the translations of a new message into the linked channels
+func (tb *TenseiBot) mirrorMessage(s *discordgo.Session, m *discordgo.Message) {
+	links := tb.Translate.linksFrom(m.ChannelID)
+	if len(links) < 1 || !tb.isMirrorable(s, m) {
+		return
+	}
+
+	var mirrored []mirroredMessage
+	for _, link := range links {
+		content, err := tb.linkedContent(m, link)
+		if errors.Is(err, errTranslateBudget) {
+			log.Debugf("[TRANSLATE] guild %s is out of budget, not mirroring message %s", link.GuildID, m.ID)
+			return
+		}
+		if err != nil {
+			log.Warnf("[TRANSLATE] failed translating message %s for channel %s: %v", m.ID, link.TargetChannelID, err)
+			continue
+		}
+
+		params := &discordgo.WebhookParams{
+			Content:         content,
+			Username:        authorName(m),
+			AvatarURL:       m.Author.AvatarURL(""),
+			AllowedMentions: &discordgo.MessageAllowedMentions{},
+		}
+		msg, err := s.WebhookExecute(link.WebhookID, link.WebhookToken, true, params)
+		if isDiscordNotFound(err) {
+			if link, err = tb.renewLinkWebhook(s, link); err == nil {
+				msg, err = s.WebhookExecute(link.WebhookID, link.WebhookToken, true, params)
+			}
+		}
+		if err != nil {
+			log.Errorf("[TRANSLATE] failed posting message %s in channel %s: %v", m.ID, link.TargetChannelID, err)
+			continue
+		}
+		mirrored = append(mirrored, mirroredMessage{link: link, messageID: msg.ID})
+	}
+	if len(mirrored) > 0 {
+		tb.Translate.rememberMirrored(m.ID, mirrored)
+	}
+}
+
+// rememberMirrored keeps the translations of the last translateLinkMessages messages
+func (tt *TenseiTranslate) rememberMirrored(messageID string, mirrored []mirroredMessage) {
+	tt.linksMutex.Lock()
+	defer tt.linksMutex.Unlock()
+	if len(tt.mirroredOrder) >= translateLinkMessages {
+		delete(tt.mirrored, tt.mirroredOrder[0])
+		tt.mirroredOrder = tt.mirroredOrder[1:]
+	}
+	tt.mirrored[messageID] = mirrored
+	tt.mirroredOrder = append(tt.mirroredOrder, messageID)
+}
+
+// mirroredMessages returns the translations posted for the message
+func (tt *TenseiTranslate) mirroredMessages(messageID string) []mirroredMessage {
+	tt.linksMutex.Lock()
+	defer tt.linksMutex.Unlock()
+	return tt.mirrored[messageID]
+}
+
+// mirrorEdit edits the translations of an edited message
+func (tb *TenseiBot) mirrorEdit(s *discordgo.Session, m *discordgo.Message) {
+	mirrored := tb.Translate.mirroredMessages(m.ID)
+	if len(mirrored) < 1 || m.Author == nil {
+		return
+	}
+	for _, mm := range mirrored {
+		content, err := tb.linkedContent(m, mm.link)
+		if err != nil {
+			log.Warnf("[TRANSLATE] failed translating edit of message %s for channel %s: %v", m.ID, mm.link.TargetChannelID, err)
+			continue
+		}
+		_, err = s.WebhookMessageEdit(mm.link.WebhookID, mm.link.WebhookToken, mm.messageID, &discordgo.WebhookEdit{
+			Content:         &content,
+			AllowedMentions: &discordgo.MessageAllowedMentions{},
+		})
+		if err != nil && !isDiscordNotFound(err) {
+			log.Errorf("[TRANSLATE] failed editing message %s in channel %s: %v", mm.messageID, mm.link.TargetChannelID, err)
+		}
+	}
+}
+
+// mirrorDelete deletes the translations of a deleted message
+func (tb *TenseiBot) mirrorDelete(s *discordgo.Session, messageID string) {
+	tb.Translate.linksMutex.Lock()
+	mirrored := tb.Translate.mirrored[messageID]
+	delete(tb.Translate.mirrored, messageID)
+	tb.Translate.linksMutex.Unlock()
+
+	for _, mm := range mirrored {
+		err := s.WebhookMessageDelete(mm.link.WebhookID, mm.link.WebhookToken, mm.messageID)
+		if err != nil && !isDiscordNotFound(err) {
+			log.Errorf("[TRANSLATE] failed deleting message %s in channel %s: %v", mm.messageID, mm.link.TargetChannelID, err)
+		}
+	}
+}
+
+func discordTranslateLink(tb *TenseiBot) commandFunc {
+	return func(ctx *commandContext) {
+		channelID, partnerID := ctx.str("channel"), ctx.str("partner")
+		language := tb.Translate.resolveLanguage(ctx.str("language"))
+		partnerLanguage := tb.Translate.resolveLanguage(ctx.str("partner_language"))
+		if channelID == partnerID {
+			ctx.error("a channel can't be linked with itself")
+			return
+		}
+		for _, id := range []string{channelID, partnerID} {
+			if err := guildChannel(ctx.s, ctx.guildID, id); err != nil {
+				ctx.error("%v", err)
+				return
+			}
+		}
+		for _, lang := range []string{language, partnerLanguage} {
+			if !tb.Translate.supported(lang) {
+				ctx.error("'%s' isn't a supported language", lang)
+				return
+			}
+		}
+
+		tb.Translate.linksMutex.Lock()
+		defer tb.Translate.linksMutex.Unlock()
+		if tb.Translate.link(channelID, partnerID) != nil || tb.Translate.link(partnerID, channelID) != nil {
+			ctx.error("<#%s> and <#%s> are already linked", channelID, partnerID)
+			return
+		}
+
+		// every message of one channel is posted in the other one in its language
+		links := []*TranslateLink{
+			{GuildID: ctx.guildID, ChannelID: channelID, TargetChannelID: partnerID, Language: partnerLanguage},
+			{GuildID: ctx.guildID, ChannelID: partnerID, TargetChannelID: channelID, Language: language},
+		}
+		for i, link := range links {
+			var err error
+			link.WebhookID, link.WebhookToken, err = tb.Translate.linkWebhook(ctx.s, link.TargetChannelID)
+			if err != nil {
+				log.Warnf("[TRANSLATE] failed creating webhook in channel %s: %v", link.TargetChannelID, err)
+				// the links aren't added, so webhooks created for them are unused
+				for _, created := range links[:i] {
+					tb.Translate.deleteUnusedWebhook(ctx.s, created)
+				}
+				ctx.error("couldn't create a webhook in <#%s>, the bot needs the manage webhooks permission", link.TargetChannelID)
+				return
+			}
+		}
+		for _, link := range links {
+			tb.AddTranslateLink(link)
+			tb.Translate.links = append(tb.Translate.links, link)
+		}
+		ctx.success("linked <#%s> (%s) with <#%s> (%s), messages are translated into the other channel",
+			channelID, tb.Translate.languageName(language), partnerID, tb.Translate.languageName(partnerLanguage))
+	}
+}
+
+func discordTranslateUnlink(tb *TenseiBot) commandFunc {
+	return func(ctx *commandContext) {
+		channelID, partnerID := ctx.str("channel"), ctx.str("partner")
+
+		tb.Translate.linksMutex.Lock()
+		defer tb.Translate.linksMutex.Unlock()
+		var removed []*TranslateLink
+		for _, link := range []*TranslateLink{tb.Translate.link(channelID, partnerID), tb.Translate.link(partnerID, channelID)} {
+			if link != nil && link.GuildID == ctx.guildID {
+				removed = append(removed, link)
+			}
+		}
+		if len(removed) < 1 {
+			ctx.error("<#%s> and <#%s> aren't linked", channelID, partnerID)
+			return
+		}
+
+		for _, link := range removed {
+			tb.RemoveTranslateLink(link)
+			for i, l := range tb.Translate.links {
+				if l == link {
+					tb.Translate.links = append(tb.Translate.links[:i], tb.Translate.links[i+1:]...)
+					break
+				}
+			}
+		}
+		for _, link := range removed {
+			tb.Translate.deleteUnusedWebhook(ctx.s, link)
+		}
+		ctx.success("unlinked <#%s> and <#%s>", channelID, partnerID)
+	}
+}
+
+func discordTranslateLinks(tb *TenseiBot) commandFunc {
+	return func(ctx *commandContext) {
+		var lines []string
+		tb.Translate.linksMutex.Lock()
+		for _, link := range tb.Translate.links {
+			if link.GuildID != ctx.guildID {
+				continue
+			}
+			reverse := tb.Translate.link(link.TargetChannelID, link.ChannelID)
+			// pairs are listed once, by the link created first
+			if reverse != nil && reverse.ID < link.ID {
+				continue
+			}
+			partnerLanguage := "?"
+			if reverse != nil {
+				partnerLanguage = tb.Translate.languageName(reverse.Language)
+			}
+			lines = append(lines, fmt.Sprintf("<#%s> (%s) ⇄ <#%s> (%s)",
+				link.ChannelID, partnerLanguage, link.TargetChannelID, tb.Translate.languageName(link.Language)))
+		}
+		tb.Translate.linksMutex.Unlock()
+
+		if len(lines) < 1 {
+			ctx.error("there are no linked channels on this server")
+			return
+		}
+
+		var pages []*discordgo.MessageEmbed
+		for len(lines) > 0 {
+			n := translateLinksPerPage
+			if n > len(lines) {
+				n = len(lines)
+			}
+			pages = append(pages, &discordgo.MessageEmbed{
+				Title:       "Linked channels",
+				Description: strings.Join(lines[:n], "\n"),
+			})
+			lines = lines[n:]
+		}
+		ctx.replyPages(pages)
+	}
+}
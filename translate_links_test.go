@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestSameLanguage(t *testing.T) {
+	for _, test := range []struct {
+		a, b string
+		want bool
+	}{
+		{"en", "en", true},
+		{"EN", "en", true},
+		{"en", "en-US", true},
+		{"pt-BR", "pt", true},
+		{"zh-TW", "zh-tw", true},
+		{"zh-TW", "zh-CN", false},
+		{"en-GB", "en-US", false},
+		{"de", "en", false},
+		{"de", "de-AT", true},
+		{"", "en", false},
+	} {
+		if got := sameLanguage(test.a, test.b); got != test.want {
+			t.Errorf("sameLanguage(%q, %q) = %t, want %t", test.a, test.b, got, test.want)
+		}
+		if got := sameLanguage(test.b, test.a); got != test.want {
+			t.Errorf("sameLanguage(%q, %q) = %t, want %t", test.b, test.a, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeTranslator answers every request and counts them
+type fakeTranslator struct {
+	calls int
+}
+
+func (f *fakeTranslator) Name() string {
+	return translatorLibre
+}
+
+func (f *fakeTranslator) Translate(text, source, target string) (*translation, error) {
+	f.calls++
+	return &translation{text: target + ": " + text, source: "en"}, nil
+}
+
+func (f *fakeTranslator) Detect(text string) (*detection, error) {
+	return nil, errTranslateUnsupported
+}
+
+func (f *fakeTranslator) Languages() ([]translateLanguage, error) {
+	return nil, nil
+}
+
+// translateBot returns a bot with a sqlite database and the fake as only translator
+func translateBot(t *testing.T, monthlyCharacters int) (*TenseiBot, *fakeTranslator) {
+	tb := &TenseiBot{Config: &TenseiConfig{}}
+	tb.Config.Database.Dialect = "sqlite3"
+	tb.Config.Database.ConnectionString = filepath.Join(t.TempDir(), "tensei.db")
+	tb.NewDatabase()
+	t.Cleanup(func() { tb.db.Close() })
+
+	fake := &fakeTranslator{}
+	tb.Translate = &TenseiTranslate{
+		translators:       []Translator{fake},
+		cache:             newTestTranslationCache(translateCacheSize, time.Hour),
+		monthlyCharacters: monthlyCharacters,
+		exhausted:         make(map[string]time.Time),
+	}
+	return tb, fake
+}
+
+func TestTranslateTextBudget(t *testing.T) {
+	tb, fake := translateBot(t, 10)
+	tb.AddTranslationUsage("guild", translateMonth(), 8)
+
+	if _, err := tb.translateText("guild", "hello", "", "de"); !errors.Is(err, errTranslateBudget) {
+		t.Fatalf("got %v, want %v", err, errTranslateBudget)
+	}
+	if fake.calls != 0 {
+		t.Fatalf("translator was asked %d times over the budget", fake.calls)
+	}
+	// other guilds have their own budget
+	if _, err := tb.translateText("other", "hello", "", "de"); err != nil {
+		t.Fatal(err)
+	}
+	if used, _ := tb.translateBudget("other"); used != 5 {
+		t.Fatalf("got %d used characters, want 5", used)
+	}
+	if used, _ := tb.translateBudget("guild"); used != 8 {
+		t.Fatalf("refused translation counted, got %d used characters, want 8", used)
+	}
+}
+
+func TestTranslateTextCachedOverBudget(t *testing.T) {
+	tb, fake := translateBot(t, 10)
+	if _, err := tb.translateText("guild", "hello", "", "de"); err != nil {
+		t.Fatal(err)
+	}
+	tb.AddTranslationUsage("guild", translateMonth(), 10)
+
+	// cached translations don't cost anything, so they are still answered
+	result, err := tb.translateText("guild", "hello", "", "de")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.text != "de: hello" || fake.calls != 1 {
+		t.Fatalf("got %q after %d calls, want the cached translation", result.text, fake.calls)
+	}
+}
+
+func TestLanguagePair(t *testing.T) {
+	tt := &TenseiTranslate{languages: []translateLanguage{
+		{code: "en", name: "English"},
+		{code: "de", name: "German"},
+		{code: "ja", name: "Japanese"},
+		{code: "zh-TW", name: "Chinese (Traditional)"},
+	}}
+	for _, test := range []struct {
+		value          string
+		source, target string
+	}{
+		{"de", "", "de"},
+		{"German", "", "de"},
+		{"japanese", "", "ja"},
+		{"ZH-tw", "", "zh-TW"},
+		{"en:de", "en", "de"},
+		{"English:Japanese", "en", "ja"},
+		{":de", "", "de"},
+		// unknown languages are passed on for the translators to reject
+		{"klingon", "", "klingon"},
+		{"en:klingon", "en", "klingon"},
+	} {
+		source, target := tt.languagePair(test.value)
+		if source != test.source || target != test.target {
+			t.Errorf("languagePair(%q) = %q, %q, want %q, %q", test.value, source, target, test.source, test.target)
+		}
+	}
+}
@@ -4,84 +4,299 @@ import (
 	"fmt"
 	"github.com/nicklaw5/helix"
 	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strings"
 	"sync"
 	"time"
 )
 
+const (
+	// helixMaxIDs is the most ids helix accepts in one request
+	helixMaxIDs     = 100
+	profileImageTTL = 24 * time.Hour
+)
+
 // TenseiTwitch ...
 type TenseiTwitch struct {
-	helix *helix.Client
+	helix      *helix.Client
+	httpClient *http.Client
+
+	clientID        string
+	clientSecret    string
+	tokenURL        string
+	appTokenExpires time.Time
+	// appTokenLifetime expires_in of the current token
+	appTokenLifetime time.Duration
+	appTokenErr      error
+	appTokenMutex    sync.Mutex
 
 	TwitchStreamers     []*TwitchStreamer
 	TwitchStreamerMutex sync.RWMutex
 
-	RateLimit          int
-	RateLimitRemaining int
-	RateLimitReset     time.Time
-	RateLimitMutex     sync.RWMutex
+	games      map[string]*helix.Game
+	gamesMutex sync.RWMutex
+
+	profileImages      map[string]*profileImage
+	profileImagesMutex sync.Mutex
+
+	groupSubscriptions []*TwitchGroupSubscription
+	groupAlerts        []*TwitchGroupAlert
+	teamMembers        map[string]*teamMembers
+	groupMutex         sync.Mutex
+
+	chat        *ircClient
+	chatBridges []*chatBridge
+	chatBuffers map[string]*chatBuffer
+	chatMutex   sync.Mutex
+
+	RateLimit           int
+	RateLimitRemaining  int
+	RateLimitReset      time.Time
+	RateLimitMutex      sync.RWMutex
+	waitingUserRequests int
+}
+
+type profileImage struct {
+	url     string
+	fetched time.Time
 }
 
 // NewTwitch creates twitch client
 func (tb *TenseiBot) NewTwitch() {
 	var err error
 	tb.Twitch.helix, err = helix.NewClient(&helix.Options{
-		ClientID: tb.Config.Twitch.ClientID,
+		ClientID:   tb.Config.Twitch.ClientID,
+		APIBaseURL: tb.Config.Twitch.APIURL,
 	})
 	if err != nil {
 		log.Fatalf("[TWITCH] failed creating client: %v", err)
 	}
 
+	tb.Twitch.httpClient = &http.Client{Timeout: 10 * time.Second}
+	tb.Twitch.clientID = tb.Config.Twitch.ClientID
+	tb.Twitch.clientSecret = tb.Config.Twitch.ClientSecret
+	tb.Twitch.tokenURL = tb.Config.Twitch.TokenURL
+	if tb.Twitch.tokenURL == "" {
+		tb.Twitch.tokenURL = twitchTokenURL
+	}
+	if tb.Twitch.clientSecret == "" {
+		log.Warn("[TWITCH_AUTH] missing client_secret in config file, helix requires an app access token")
+	} else {
+		_ = tb.Twitch.refreshAppAccessToken()
+		go tb.Twitch.keepAppAccessToken()
+	}
+
+	tb.Twitch.games = make(map[string]*helix.Game)
+	tb.Twitch.profileImages = make(map[string]*profileImage)
 	tb.Twitch.TwitchStreamers = tb.GetStreamers()
+	tb.Twitch.teamMembers = make(map[string]*teamMembers)
+	tb.Twitch.groupSubscriptions = tb.GetGroupSubscriptions()
+	tb.Twitch.groupAlerts = tb.GetLiveGroupAlerts()
+	tb.startTwitchChat()
 	go tb.startTwitchJobs()
+	if tb.Config.Twitch.EventSub.Listen != "" {
+		go tb.startEventSub()
+	}
 
 	log.Info("[MODULE] twitch loaded")
 }
 
 // GetUsers ...
-func (tt *TenseiTwitch) GetUsers(ids []string, logins []string) ([]helix.User, error) {
-	resp, err := tt.helix.GetUsers(&helix.UsersParams{
-		IDs:    ids,
-		Logins: logins,
+func (tt *TenseiTwitch) GetUsers(priority helixPriority, ids []string, logins []string) ([]helix.User, error) {
+	var resp *helix.UsersResponse
+	err := tt.doHelix(priority, func() (*helix.ResponseCommon, error) {
+		var err error
+		resp, err = tt.helix.GetUsers(&helix.UsersParams{
+			IDs:    ids,
+			Logins: logins,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &resp.ResponseCommon, nil
 	})
 	if err != nil {
 		return nil, fmt.Errorf("[TWITCH] failed getting users: %v", err)
 	}
-
-	tt.updateRateLimit(resp.GetRateLimit(), resp.GetRateLimitRemaining(), resp.GetRateLimitReset())
-
 	return resp.Data.Users, nil
 }
 
-func (tt *TenseiTwitch) getStream(ids []string, logins []string) ([]helix.Stream, error) {
-	resp, err := tt.helix.GetStreams(&helix.StreamsParams{
-		UserIDs:    ids,
-		UserLogins: logins,
+func (tt *TenseiTwitch) getStream(priority helixPriority, ids []string, logins []string) ([]helix.Stream, error) {
+	var resp *helix.StreamsResponse
+	err := tt.doHelix(priority, func() (*helix.ResponseCommon, error) {
+		var err error
+		resp, err = tt.helix.GetStreams(&helix.StreamsParams{
+			First:      helixMaxIDs,
+			UserIDs:    ids,
+			UserLogins: logins,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &resp.ResponseCommon, nil
 	})
 	if err != nil {
 		return nil, fmt.Errorf("[TWITCH] failed checking if user is live ids: %s, logins: %s err: %v", ids, logins, err)
 	}
-
-	defer tt.updateRateLimit(resp.GetRateLimit(), resp.GetRateLimitRemaining(), resp.GetRateLimitReset())
 	return resp.Data.Streams, nil
 }
 
+// getStreamsByUserID returns the live streams of the users keyed by user id
+func (tt *TenseiTwitch) getStreamsByUserID(ids []string) (map[string]*helix.Stream, error) {
+	streams, err := tt.getStream(priorityBackground, ids, nil)
+	if err != nil {
+		return nil, err
+	}
+	byUser := make(map[string]*helix.Stream, len(streams))
+	for i := range streams {
+		byUser[streams[i].UserID] = &streams[i]
+	}
+	return byUser, nil
+}
+
 func (tt *TenseiTwitch) getGameByID(id string) (*helix.Game, error) {
-	resp, err := tt.helix.GetGames(&helix.GamesParams{
-		IDs: []string{id},
+	tt.gamesMutex.RLock()
+	game, ok := tt.games[id]
+	tt.gamesMutex.RUnlock()
+	if ok {
+		return game, nil
+	}
+
+	if err := tt.cacheGames([]string{id}); err != nil {
+		return nil, err
+	}
+
+	tt.gamesMutex.RLock()
+	defer tt.gamesMutex.RUnlock()
+	if game, ok := tt.games[id]; ok {
+		return game, nil
+	}
+	return nil, fmt.Errorf("[TWITCH] no game found for id: %s", id)
+}
+
+// gameName returns the name of the game or ??? if it can't be found
+func (tt *TenseiTwitch) gameName(id string) string {
+	game, err := tt.getGameByID(id)
+	if err != nil {
+		return "???"
+	}
+	return game.Name
+}
+
+// cacheGames fetches the games that aren't cached yet in one request
+func (tt *TenseiTwitch) cacheGames(ids []string) error {
+	var missing []string
+	tt.gamesMutex.RLock()
+	for _, id := range ids {
+		if _, ok := tt.games[id]; !ok && id != "" && !contains(missing, id) {
+			missing = append(missing, id)
+		}
+	}
+	tt.gamesMutex.RUnlock()
+	if len(missing) < 1 {
+		return nil
+	}
+	if len(missing) > helixMaxIDs {
+		missing = missing[:helixMaxIDs]
+	}
+
+	var resp *helix.GamesResponse
+	err := tt.doHelix(priorityBackground, func() (*helix.ResponseCommon, error) {
+		var err error
+		resp, err = tt.helix.GetGames(&helix.GamesParams{
+			IDs: missing,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &resp.ResponseCommon, nil
 	})
-	if err != nil || len(resp.Data.Games) < 1 {
-		return nil, fmt.Errorf("[TWITCH] failed getting game for id: %s, err: %v", id, err)
+	if err != nil {
+		return fmt.Errorf("[TWITCH] failed getting games for ids: %s, err: %v", missing, err)
+	}
+
+	tt.gamesMutex.Lock()
+	defer tt.gamesMutex.Unlock()
+	for i := range resp.Data.Games {
+		tt.games[resp.Data.Games[i].ID] = &resp.Data.Games[i]
+	}
+	return nil
+}
+
+// fetchProfileImages caches the profile images of the channels that weren't fetched recently
+func (tt *TenseiTwitch) fetchProfileImages(ids []string) {
+	tt.profileImagesMutex.Lock()
+	defer tt.profileImagesMutex.Unlock()
+
+	var stale []string
+	for _, id := range ids {
+		image, ok := tt.profileImages[id]
+		if !ok || time.Since(image.fetched) > profileImageTTL {
+			stale = append(stale, id)
+		}
+	}
+
+	for len(stale) > 0 {
+		n := helixMaxIDs
+		if n > len(stale) {
+			n = len(stale)
+		}
+		users, err := tt.GetUsers(priorityBackground, stale[:n], nil)
+		if err != nil {
+			log.Warn(err)
+			break
+		}
+		for _, user := range users {
+			tt.profileImages[user.ID] = &profileImage{url: user.ProfileImageURL, fetched: time.Now()}
+		}
+		stale = stale[n:]
 	}
+}
 
-	defer tt.updateRateLimit(resp.GetRateLimit(), resp.GetRateLimitRemaining(), resp.GetRateLimitReset())
-	return &resp.Data.Games[0], nil
+// setProfileImage sets the cached profile image of the streamer
+func (tt *TenseiTwitch) setProfileImage(streamer *TwitchStreamer) {
+	tt.profileImagesMutex.Lock()
+	defer tt.profileImagesMutex.Unlock()
+	if image, ok := tt.profileImages[streamer.ChannelID]; ok {
+		streamer.ProfileImageURL = image.url
+	}
+}
+
+// trackedStreamer returns the streamer with the name, the caller has to hold TwitchStreamerMutex
+func (tt *TenseiTwitch) trackedStreamer(name string) *TwitchStreamer {
+	for _, streamer := range tt.TwitchStreamers {
+		if strings.EqualFold(streamer.Name, name) {
+			return streamer
+		}
+	}
+	return nil
+}
+
+// hasGuildAlert returns true when the streamer has an alert in the guild, the caller has to hold TwitchStreamerMutex
+func (s *TwitchStreamer) hasGuildAlert(guildID string) bool {
+	for _, alert := range s.TwitchAlertSubscriptions {
+		if alert.GuildID == guildID {
+			return true
+		}
+	}
+	return false
+}
+
+// untrackStreamer removes the streamer from TwitchStreamers, the caller has to hold TwitchStreamerMutex
+func (tt *TenseiTwitch) untrackStreamer(streamer *TwitchStreamer) {
+	for i, s := range tt.TwitchStreamers {
+		if s == streamer {
+			tt.TwitchStreamers = append(tt.TwitchStreamers[:i], tt.TwitchStreamers[i+1:]...)
+			return
+		}
+	}
 }
 
 func isStreaming(stream *helix.Stream) bool {
 	return stream != nil && stream.ID != "" && stream.Type == "live"
 }
 
-// ignore ratelimits for now
+// updateRateLimit stores the rate limit helix returned, doHelix schedules requests with it
 func (tt *TenseiTwitch) updateRateLimit(limit, limitRemaining, limitReset int) {
 	tt.RateLimitMutex.Lock()
 	defer tt.RateLimitMutex.Unlock()
@@ -94,16 +309,11 @@ func (tt *TenseiTwitch) updateRateLimit(limit, limitRemaining, limitReset int) {
 }
 
 func (tb *TenseiBot) startTwitchJobs() {
+	tb.reconcileTwitchStreams()
 	log.Infof("[TWITCH] starting %d TWITCH_JOBS", len(tb.Twitch.TwitchStreamers))
 	ticker := time.NewTicker(time.Minute)
 	for range ticker.C {
-		var wg sync.WaitGroup
-		wg.Add(len(tb.Twitch.TwitchStreamers))
-		tb.Twitch.TwitchStreamerMutex.Lock()
-		for _, streamer := range tb.Twitch.TwitchStreamers {
-			go tb.NewTwitchJob(streamer, &wg)
-		}
-		wg.Wait()
-		tb.Twitch.TwitchStreamerMutex.Unlock()
+		tb.startLiveJobs(tb.twitchSource())
+		tb.pollTwitchGroups()
 	}
 }
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	twitchTokenURL = "https://id.twitch.tv/oauth2/token"
+	// appTokenRefreshMargin refresh the token this long before it expires
+	appTokenRefreshMargin = 10 * time.Minute
+	// appTokenMinRefreshWait keeps tokens with a short or no lifetime from being refreshed in a loop
+	appTokenMinRefreshWait = time.Minute
+	appTokenRetryDelay     = time.Minute
+)
+
+type appAccessTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+	TokenType   string `json:"token_type"`
+	Message     string `json:"message"`
+}
+
+// requestAppAccessToken gets a new app access token with the client credentials flow
+func (tt *TenseiTwitch) requestAppAccessToken() (*appAccessTokenResponse, error) {
+	resp, err := tt.httpClient.PostForm(tt.tokenURL, url.Values{
+		"client_id":     {tt.clientID},
+		"client_secret": {tt.clientSecret},
+		"grant_type":    {"client_credentials"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var token appAccessTokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return nil, fmt.Errorf("failed decoding response with status %d: %v", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK || token.AccessToken == "" {
+		return nil, fmt.Errorf("status %d: %s", resp.StatusCode, token.Message)
+	}
+	return &token, nil
+}
+
+// refreshAppAccessToken requests a new token and hands it to the helix client
+func (tt *TenseiTwitch) refreshAppAccessToken() error {
+	tt.appTokenMutex.Lock()
+	defer tt.appTokenMutex.Unlock()
+
+	token, err := tt.requestAppAccessToken()
+	if err != nil {
+		tt.appTokenErr = err
+		log.Errorf("[TWITCH_AUTH] failed getting app access token: %v", err)
+		return err
+	}
+	tt.appTokenErr = nil
+	tt.appTokenLifetime = time.Duration(token.ExpiresIn) * time.Second
+	tt.appTokenExpires = time.Now().Add(tt.appTokenLifetime)
+	tt.helix.SetAppAccessToken(token.AccessToken)
+	log.Infof("[TWITCH_AUTH] got app access token, expires %s", tt.appTokenExpires.Format(time.RFC822))
+	return nil
+}
+
+// keepAppAccessToken refreshes the token before it expires
+func (tt *TenseiTwitch) keepAppAccessToken() {
+	for {
+		tt.appTokenMutex.Lock()
+		wait := appTokenRefreshWait(time.Until(tt.appTokenExpires), tt.appTokenLifetime)
+		if tt.appTokenErr != nil {
+			wait = appTokenRetryDelay
+		}
+		tt.appTokenMutex.Unlock()
+
+		time.Sleep(wait)
+		_ = tt.refreshAppAccessToken()
+	}
+}
+
+// appTokenRefreshWait returns how long to wait before refreshing a token that expires in until,
+// tokens lasting less than twice the margin are refreshed after half their lifetime
+func appTokenRefreshWait(until, lifetime time.Duration) time.Duration {
+	margin := appTokenRefreshMargin
+	if margin > lifetime/2 {
+		margin = lifetime / 2
+	}
+	wait := until - margin
+	if wait < appTokenMinRefreshWait {
+		wait = appTokenMinRefreshWait
+	}
+	return wait
+}
+
+// AppAccessTokenStatus describes the state of the app access token for !stats
+func (tt *TenseiTwitch) AppAccessTokenStatus() string {
+	if tt.clientSecret == "" {
+		return "no client_secret configured"
+	}
+
+	tt.appTokenMutex.Lock()
+	defer tt.appTokenMutex.Unlock()
+
+	if tt.appTokenErr != nil {
+		return fmt.Sprintf("failed: %v", tt.appTokenErr)
+	}
+	return fmt.Sprintf("ok, expires in %s", humanizeDuration(time.Until(tt.appTokenExpires)))
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nicklaw5/helix"
+)
+
+// twitchAuthFake stands in for the twitch token endpoint and helix, helix only
+// accepts the token that was issued last
+type twitchAuthFake struct {
+	token *httptest.Server
+	helix *httptest.Server
+
+	mutex sync.Mutex
+	// expiresIn of the issued tokens in order, the last one is repeated
+	expiresIn     []int
+	tokenRequests int
+	helixRequests int
+	valid         string
+	// rejectAll makes helix answer 401 to every token
+	rejectAll bool
+}
+
+func newTwitchAuthFake(t *testing.T, expiresIn ...int) *twitchAuthFake {
+	fake := &twitchAuthFake{expiresIn: expiresIn}
+	fake.token = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("grant_type") != "client_credentials" || r.FormValue("client_id") != "id" || r.FormValue("client_secret") != "secret" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"message": "invalid client"}`))
+			return
+		}
+		fake.mutex.Lock()
+		fake.tokenRequests++
+		fake.valid = fmt.Sprintf("token-%d", fake.tokenRequests)
+		expires := fake.expiresIn[len(fake.expiresIn)-1]
+		if fake.tokenRequests <= len(fake.expiresIn) {
+			expires = fake.expiresIn[fake.tokenRequests-1]
+		}
+		resp := appAccessTokenResponse{AccessToken: fake.valid, ExpiresIn: expires, TokenType: "bearer"}
+		fake.mutex.Unlock()
+		_ = json.NewEncoder(w).Encode(resp)
+	}))
+	fake.helix = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fake.mutex.Lock()
+		defer fake.mutex.Unlock()
+		fake.helixRequests++
+		if fake.rejectAll || fake.valid == "" || r.Header.Get("Authorization") != "Bearer "+fake.valid {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"data": []}`))
+	}))
+	t.Cleanup(fake.token.Close)
+	t.Cleanup(fake.helix.Close)
+	return fake
+}
+
+// twitch returns a client using the stand-ins like NewTwitch does with token_url and api_url
+func (fake *twitchAuthFake) twitch(t *testing.T) *TenseiTwitch {
+	client, err := helix.NewClient(&helix.Options{ClientID: "id", APIBaseURL: fake.helix.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &TenseiTwitch{
+		helix:        client,
+		httpClient:   &http.Client{Timeout: time.Second},
+		clientID:     "id",
+		clientSecret: "secret",
+		tokenURL:     fake.token.URL,
+	}
+}
+
+// revoke makes helix reject every token issued so far
+func (fake *twitchAuthFake) revoke() {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	fake.valid = ""
+}
+
+func (fake *twitchAuthFake) counts() (int, int) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	return fake.tokenRequests, fake.helixRequests
+}
+
+// request returns a helix call made with the client of tt, it sends the token SetAppAccessToken set last
+func request(tt *TenseiTwitch) func() (*helix.ResponseCommon, error) {
+	return func() (*helix.ResponseCommon, error) {
+		resp, err := tt.helix.GetUsers(&helix.UsersParams{IDs: []string{"1"}})
+		if err != nil {
+			return nil, err
+		}
+		return &resp.ResponseCommon, nil
+	}
+}
+
+func TestAppAccessTokenRefreshWait(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		until    time.Duration
+		lifetime time.Duration
+		want     time.Duration
+	}{
+		{"long lifetime", 60 * 24 * time.Hour, 60 * 24 * time.Hour, 60*24*time.Hour - appTokenRefreshMargin},
+		{"refreshed before the margin", time.Hour, 60 * 24 * time.Hour, time.Hour - appTokenRefreshMargin},
+		{"lifetime below twice the margin", 10 * time.Minute, 10 * time.Minute, 5 * time.Minute},
+		{"lifetime below the minimum", 30 * time.Second, 30 * time.Second, appTokenMinRefreshWait},
+		{"no lifetime", 0, 0, appTokenMinRefreshWait},
+		{"expired", -time.Hour, 60 * 24 * time.Hour, appTokenMinRefreshWait},
+	} {
+		if got := appTokenRefreshWait(test.until, test.lifetime); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestDoHelixRefreshesOnUnauthorized(t *testing.T) {
+	fake := newTwitchAuthFake(t, 3600)
+	tt := fake.twitch(t)
+	if err := tt.refreshAppAccessToken(); err != nil {
+		t.Fatal(err)
+	}
+	fake.revoke()
+
+	if err := tt.doHelix(priorityUser, request(tt)); err != nil {
+		t.Fatal(err)
+	}
+	if tokens, helixRequests := fake.counts(); tokens != 2 || helixRequests != 2 {
+		t.Fatalf("got %d token and %d helix requests, want one refresh and one retry", tokens, helixRequests)
+	}
+}
+
+func TestDoHelixRefreshesOnlyOnce(t *testing.T) {
+	fake := newTwitchAuthFake(t, 3600)
+	tt := fake.twitch(t)
+	if err := tt.refreshAppAccessToken(); err != nil {
+		t.Fatal(err)
+	}
+	// helix keeps rejecting the new token too
+	fake.mutex.Lock()
+	fake.rejectAll = true
+	fake.mutex.Unlock()
+
+	if err := tt.doHelix(priorityUser, request(tt)); err == nil {
+		t.Fatal("got no error for a rejected token")
+	}
+	if tokens, helixRequests := fake.counts(); tokens != 2 || helixRequests != 2 {
+		t.Fatalf("got %d token and %d helix requests, want one refresh and one retry", tokens, helixRequests)
+	}
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// chat lines are collected and sent together to stay below the discord rate limit
+	chatFlushInterval = 2 * time.Second
+	// chatMaxLines lines buffered per discord channel, older ones are dropped
+	chatMaxLines        = 40
+	discordMessageLimit = 2000
+)
+
+// chatBridge bridge with the streamer it relays
+type chatBridge struct {
+	*TwitchChatBridge
+	streamer *TwitchStreamer
+}
+
+type chatBuffer struct {
+	lines   []string
+	dropped int
+}
+
+// startTwitchChat loads the chat bridges, joins their chats and starts relaying
+func (tb *TenseiBot) startTwitchChat() {
+	cfg := tb.Config.Twitch.Chat
+	address, useTLS := cfg.Address, cfg.TLS
+	if address == "" {
+		address, useTLS = twitchChatAddress, true
+	}
+	tb.Twitch.chat = newIRCClient(address, useTLS, cfg.Nick, cfg.Token, tb.relayChatMessage)
+	tb.Twitch.chatBuffers = make(map[string]*chatBuffer)
+
+	tb.Twitch.TwitchStreamerMutex.RLock()
+	tb.Twitch.chatMutex.Lock()
+	for _, bridge := range tb.GetChatBridges() {
+		streamer := tb.Twitch.trackedStreamerWithID(bridge.TwitchStreamerID)
+		if streamer == nil {
+			log.Warnf("[TWITCH_CHAT] skipping bridge to channel: %s, streamer %d isn't tracked", bridge.ChannelID, bridge.TwitchStreamerID)
+			continue
+		}
+		tb.Twitch.chatBridges = append(tb.Twitch.chatBridges, &chatBridge{TwitchChatBridge: bridge, streamer: streamer})
+		tb.Twitch.chat.join(streamer.Name)
+	}
+	tb.Twitch.chatMutex.Unlock()
+	tb.Twitch.TwitchStreamerMutex.RUnlock()
+
+	go func() {
+		ticker := time.NewTicker(chatFlushInterval)
+		for range ticker.C {
+			tb.flushChat()
+		}
+	}()
+}
+
+// relayChatMessage buffers the message for every discord channel bridged to the chat
+func (tb *TenseiBot) relayChatMessage(msg *chatMessage) {
+	var bridges []chatBridge
+	tb.Twitch.chatMutex.Lock()
+	for _, bridge := range tb.Twitch.chatBridges {
+		if strings.EqualFold(bridge.streamer.Name, msg.channel) {
+			bridges = append(bridges, chatBridge{
+				TwitchChatBridge: &TwitchChatBridge{ChannelID: bridge.ChannelID, OnlyLive: bridge.OnlyLive},
+				streamer:         bridge.streamer,
+			})
+		}
+	}
+	tb.Twitch.chatMutex.Unlock()
+	if len(bridges) < 1 {
+		return
+	}
+
+	// the streamer mutex is taken without holding chatMutex, commands lock them the other way around
+	var channelIDs []string
+	tb.Twitch.TwitchStreamerMutex.RLock()
+	for _, bridge := range bridges {
+		if !bridge.OnlyLive || bridge.streamer.IsLive() {
+			channelIDs = append(channelIDs, bridge.ChannelID)
+		}
+	}
+	tb.Twitch.TwitchStreamerMutex.RUnlock()
+
+	line := formatChatMessage(msg)
+	tb.Twitch.chatMutex.Lock()
+	defer tb.Twitch.chatMutex.Unlock()
+	for _, channelID := range channelIDs {
+		buffer, ok := tb.Twitch.chatBuffers[channelID]
+		if !ok {
+			buffer = &chatBuffer{}
+			tb.Twitch.chatBuffers[channelID] = buffer
+		}
+		if len(buffer.lines) >= chatMaxLines {
+			buffer.lines = buffer.lines[1:]
+			buffer.dropped++
+		}
+		buffer.lines = append(buffer.lines, line)
+	}
+}
+
+// formatChatMessage escapes the message so emote names like Kappa or :) show up as written
+func formatChatMessage(msg *chatMessage) string {
+	user := escapeDiscord(msg.user)
+	text := escapeDiscord(msg.text)
+	if msg.action {
+		return fmt.Sprintf("**%s** *%s*", user, text)
+	}
+	return fmt.Sprintf("**%s**: %s", user, text)
+}
+
+// flushChat sends the buffered lines, as few messages as possible per channel
+func (tb *TenseiBot) flushChat() {
+	tb.Twitch.chatMutex.Lock()
+	buffers := tb.Twitch.chatBuffers
+	tb.Twitch.chatBuffers = make(map[string]*chatBuffer)
+	tb.Twitch.chatMutex.Unlock()
+
+	for channelID, buffer := range buffers {
+		lines := buffer.lines
+		if buffer.dropped > 0 {
+			lines = append([]string{fmt.Sprintf("*… skipped %d messages*", buffer.dropped)}, lines...)
+		}
+		for _, content := range joinLines(lines, discordMessageLimit) {
+			_, err := tb.Discord.c.ChannelMessageSendComplex(channelID, &discordgo.MessageSend{
+				Content:         content,
+				AllowedMentions: &discordgo.MessageAllowedMentions{},
+			})
+			if err != nil {
+				log.Errorf("[TWITCH_CHAT] failed relaying chat to channel: %s: %v", channelID, err)
+				break
+			}
+		}
+	}
+}
+
+// joinLines joins the lines into messages of at most limit characters,
+// chat messages are limited to 500 characters so a single line always fits
+func joinLines(lines []string, limit int) []string {
+	var messages []string
+	var sb strings.Builder
+	for _, line := range lines {
+		if sb.Len() > 0 && sb.Len()+1+len(line) > limit {
+			messages = append(messages, sb.String())
+			sb.Reset()
+		}
+		if sb.Len() > 0 {
+			sb.WriteString("\n")
+		}
+		sb.WriteString(line)
+	}
+	if sb.Len() > 0 {
+		messages = append(messages, sb.String())
+	}
+	return messages
+}
+
+// trackedStreamerWithID returns the streamer with the database id, the caller has to hold TwitchStreamerMutex
+func (tt *TenseiTwitch) trackedStreamerWithID(id uint) *TwitchStreamer {
+	for _, streamer := range tt.TwitchStreamers {
+		if streamer.ID == id {
+			return streamer
+		}
+	}
+	return nil
+}
+
+// removeChatBridges stops relaying the chat of the streamer to the guild, every guild when guildID is empty
+func (tb *TenseiBot) removeChatBridges(streamer *TwitchStreamer, guildID string) {
+	tb.Twitch.chatMutex.Lock()
+	defer tb.Twitch.chatMutex.Unlock()
+
+	var kept []*chatBridge
+	bridged := false
+	for _, bridge := range tb.Twitch.chatBridges {
+		if bridge.streamer != streamer {
+			kept = append(kept, bridge)
+			continue
+		}
+		if guildID != "" && bridge.GuildID != guildID {
+			kept = append(kept, bridge)
+			bridged = true
+			continue
+		}
+		tb.RemoveChatBridge(bridge.TwitchChatBridge)
+	}
+	if len(kept) < len(tb.Twitch.chatBridges) && !bridged {
+		tb.Twitch.chat.part(streamer.Name)
+	}
+	tb.Twitch.chatBridges = kept
+}
+
+// chatBridgeFields returns the chat bridges of the guild for !twitch list
+func (tb *TenseiBot) chatBridgeFields(s *discordgo.Session, guildID string) []*discordgo.MessageEmbedField {
+	tb.Twitch.chatMutex.Lock()
+	defer tb.Twitch.chatMutex.Unlock()
+
+	var fields []*discordgo.MessageEmbedField
+	for _, bridge := range tb.Twitch.chatBridges {
+		if bridge.GuildID != guildID {
+			continue
+		}
+		mode := "always"
+		if bridge.OnlyLive {
+			mode = "only while live"
+		}
+		fields = append(fields, &discordgo.MessageEmbedField{
+			Name:  fmt.Sprintf("%s chat in #%s", bridge.streamer.Name, channelName(s, bridge.ChannelID)),
+			Value: mode,
+		})
+	}
+	return fields
+}
+
+func discordTwitchChat(tb *TenseiBot) commandFunc {
+	return func(ctx *commandContext) {
+		name := ctx.str("streamer")
+		channelID := ctx.str("channel")
+		mode := strings.ToLower(ctx.str("mode"))
+		if mode == "" {
+			mode = "always"
+		}
+		if !contains(chatModes, mode) {
+			ctx.error("unknown mode '%s', use always, live or off", mode)
+			return
+		}
+		// turning a bridge off works even after its channel was deleted
+		if mode != "off" {
+			if err := guildChannel(ctx.s, ctx.guildID, channelID); err != nil {
+				ctx.error("%v", err)
+				return
+			}
+		}
+
+		tb.Twitch.TwitchStreamerMutex.RLock()
+		streamer := tb.Twitch.trackedStreamer(name)
+		alerted := streamer != nil && streamer.hasGuildAlert(ctx.guildID)
+		tb.Twitch.TwitchStreamerMutex.RUnlock()
+		if streamer == nil {
+			ctx.error("%s isn't tracked, add an alert with twitch add first", name)
+			return
+		}
+		// removing the last alert of the guild removes its bridges
+		if mode != "off" && !alerted {
+			ctx.error("%s has no alert in this server, add one with twitch add first", streamer.Name)
+			return
+		}
+
+		tb.Twitch.chatMutex.Lock()
+		defer tb.Twitch.chatMutex.Unlock()
+		var bridge *chatBridge
+		bridged := 0
+		for _, b := range tb.Twitch.chatBridges {
+			if b.streamer != streamer {
+				continue
+			}
+			bridged++
+			if b.ChannelID == channelID && b.GuildID == ctx.guildID {
+				bridge = b
+			}
+		}
+
+		switch {
+		case mode == "off" && bridge == nil:
+			ctx.error("%s chat isn't relayed to <#%s>", streamer.Name, channelID)
+		case mode == "off":
+			tb.RemoveChatBridge(bridge.TwitchChatBridge)
+			for i, b := range tb.Twitch.chatBridges {
+				if b == bridge {
+					tb.Twitch.chatBridges = append(tb.Twitch.chatBridges[:i], tb.Twitch.chatBridges[i+1:]...)
+					break
+				}
+			}
+			if bridged == 1 {
+				tb.Twitch.chat.part(streamer.Name)
+			}
+			ctx.success("stopped relaying %s chat to <#%s>", streamer.Name, channelID)
+		case bridge != nil:
+			bridge.OnlyLive = mode == "live"
+			tb.UpdateChatBridge(bridge.TwitchChatBridge)
+			ctx.success("relaying %s chat to <#%s> %s", streamer.Name, channelID, chatModeDescription(bridge.OnlyLive))
+		default:
+			bridge = &chatBridge{
+				TwitchChatBridge: &TwitchChatBridge{
+					ChannelID:        channelID,
+					GuildID:          ctx.guildID,
+					OnlyLive:         mode == "live",
+					TwitchStreamerID: streamer.ID,
+				},
+				streamer: streamer,
+			}
+			tb.AddChatBridge(bridge.TwitchChatBridge)
+			tb.Twitch.chatBridges = append(tb.Twitch.chatBridges, bridge)
+			tb.Twitch.chat.join(streamer.Name)
+			ctx.success("relaying %s chat to <#%s> %s", streamer.Name, channelID, chatModeDescription(bridge.OnlyLive))
+		}
+	}
+}
+
+var chatModes = []string{"always", "live", "off"}
+
+func completeChatModes(value string) []string {
+	var modes []string
+	for _, mode := range chatModes {
+		if strings.HasPrefix(mode, strings.ToLower(value)) {
+			modes = append(modes, mode)
+		}
+	}
+	return modes
+}
+
+func chatModeDescription(onlyLive bool) string {
+	if onlyLive {
+		return "while they are live"
+	}
+	return "all the time"
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/nicklaw5/helix"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	eventSubMessageID        = "Twitch-Eventsub-Message-Id"
+	eventSubMessageTimestamp = "Twitch-Eventsub-Message-Timestamp"
+	eventSubMessageSignature = "Twitch-Eventsub-Message-Signature"
+	eventSubMessageType      = "Twitch-Eventsub-Message-Type"
+
+	eventSubTypeVerification = "webhook_callback_verification"
+	eventSubTypeNotification = "notification"
+	eventSubTypeRevocation   = "revocation"
+
+	// eventSubMaxAge twitch retries messages, older ones are rejected to prevent replays
+	eventSubMaxAge       = 10 * time.Minute
+	eventSubSyncInterval = 10 * time.Minute
+	// eventSubOnlineRetries helix sometimes doesn't return the stream right after stream.online
+	eventSubOnlineRetries = 4
+	eventSubOnlineDelay   = 15 * time.Second
+)
+
+// eventSubHandler verifies and dedupes twitch eventsub webhook callbacks
+type eventSubHandler struct {
+	secret string
+	notify func(subscriptionType string, event json.RawMessage)
+	revoke func(subscription helix.EventSubSubscription)
+
+	seenMutex sync.Mutex
+	seen      map[string]time.Time
+}
+
+type eventSubMessage struct {
+	Challenge    string                     `json:"challenge"`
+	Subscription helix.EventSubSubscription `json:"subscription"`
+	Event        json.RawMessage            `json:"event"`
+}
+
+type eventSubKey struct {
+	typ       string
+	channelID string
+}
+
+// eventSubStreamEvent the fields of stream.online and stream.offline events we need
+type eventSubStreamEvent struct {
+	BroadcasterUserID    string `json:"broadcaster_user_id"`
+	BroadcasterUserLogin string `json:"broadcaster_user_login"`
+}
+
+func newEventSubHandler(secret string, notify func(string, json.RawMessage), revoke func(helix.EventSubSubscription)) *eventSubHandler {
+	return &eventSubHandler{
+		secret: secret,
+		notify: notify,
+		revoke: revoke,
+		seen:   make(map[string]time.Time),
+	}
+}
+
+// signEventSub returns the signature twitch sends in the Twitch-Eventsub-Message-Signature header
+func signEventSub(secret, id, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(id))
+	mac.Write([]byte(timestamp))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (h *eventSubHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	id := r.Header.Get(eventSubMessageID)
+	timestamp := r.Header.Get(eventSubMessageTimestamp)
+	signature := signEventSub(h.secret, id, timestamp, body)
+	if !hmac.Equal([]byte(signature), []byte(r.Header.Get(eventSubMessageSignature))) {
+		log.Warnf("[EVENTSUB] invalid signature for message %s from %s", id, r.RemoteAddr)
+		http.Error(w, "invalid signature", http.StatusForbidden)
+		return
+	}
+	sent, err := time.Parse(time.RFC3339Nano, timestamp)
+	if err != nil || time.Since(sent) > eventSubMaxAge {
+		log.Warnf("[EVENTSUB] rejected message %s with timestamp %s", id, timestamp)
+		http.Error(w, "message too old", http.StatusForbidden)
+		return
+	}
+
+	var msg eventSubMessage
+	if err := json.Unmarshal(body, &msg); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Header.Get(eventSubMessageType) {
+	case eventSubTypeVerification:
+		log.Infof("[EVENTSUB] verified %s subscription for %s", msg.Subscription.Type, msg.Subscription.Condition.BroadcasterUserID)
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte(msg.Challenge))
+		return
+	case eventSubTypeNotification:
+		if h.isDuplicate(id) {
+			log.Debugf("[EVENTSUB] ignoring duplicate message %s", id)
+			break
+		}
+		go h.notify(msg.Subscription.Type, msg.Event)
+	case eventSubTypeRevocation:
+		log.Warnf("[EVENTSUB] %s subscription for %s was revoked: %s", msg.Subscription.Type, msg.Subscription.Condition.BroadcasterUserID, msg.Subscription.Status)
+		go h.revoke(msg.Subscription)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// isDuplicate remembers message ids, twitch may send the same notification more than once
+func (h *eventSubHandler) isDuplicate(id string) bool {
+	h.seenMutex.Lock()
+	defer h.seenMutex.Unlock()
+
+	for seenID, t := range h.seen {
+		if time.Since(t) > eventSubMaxAge {
+			delete(h.seen, seenID)
+		}
+	}
+	if _, ok := h.seen[id]; ok {
+		return true
+	}
+	h.seen[id] = time.Now()
+	return false
+}
+
+// startEventSub starts the webhook server and keeps the subscriptions in sync
+func (tb *TenseiBot) startEventSub() {
+	cfg := tb.Config.Twitch.EventSub
+	callback, err := url.Parse(cfg.Callback)
+	if err != nil || cfg.Secret == "" {
+		log.Errorf("[EVENTSUB] missing or invalid callback/secret in config file: %v", err)
+		return
+	}
+	path := callback.Path
+	if path == "" {
+		path = "/"
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(path, newEventSubHandler(cfg.Secret, tb.handleEventSubNotification, tb.handleEventSubRevocation))
+	go func() {
+		log.Infof("[EVENTSUB] listening on %s%s", cfg.Listen, path)
+		if err := http.ListenAndServe(cfg.Listen, mux); err != nil {
+			log.Errorf("[EVENTSUB] server stopped: %v", err)
+		}
+	}()
+
+	tb.syncEventSubSubscriptions()
+	ticker := time.NewTicker(eventSubSyncInterval)
+	for range ticker.C {
+		tb.syncEventSubSubscriptions()
+	}
+}
+
+func (tb *TenseiBot) handleEventSubNotification(subscriptionType string, event json.RawMessage) {
+	var e eventSubStreamEvent
+	if err := json.Unmarshal(event, &e); err != nil {
+		log.Warnf("[EVENTSUB] failed decoding %s event: %v", subscriptionType, err)
+		return
+	}
+	log.Infof("[EVENTSUB] %s for %s(%s)", subscriptionType, e.BroadcasterUserLogin, e.BroadcasterUserID)
+
+	switch subscriptionType {
+	case helix.EventSubTypeStreamOnline:
+		for i := 0; i < eventSubOnlineRetries; i++ {
+			streams, err := tb.Twitch.getStreamsByUserID([]string{e.BroadcasterUserID})
+			if err != nil {
+				log.Warn(err)
+			} else if stream := streams[e.BroadcasterUserID]; isStreaming(stream) {
+				tb.runTwitchJob(e.BroadcasterUserID, stream)
+				return
+			}
+			time.Sleep(eventSubOnlineDelay)
+		}
+		log.Warnf("[EVENTSUB] %s is online but helix has no stream, leaving it to polling", e.BroadcasterUserLogin)
+	case helix.EventSubTypeStreamOffline:
+		tb.runTwitchJob(e.BroadcasterUserID, nil)
+	}
+}
+
+func (tb *TenseiBot) handleEventSubRevocation(subscription helix.EventSubSubscription) {
+	// user_removed means the channel is gone, everything else gets recreated
+	if subscription.Status != "user_removed" {
+		tb.syncEventSubSubscriptions()
+	}
+}
+
+// runTwitchJob runs the job of a single streamer outside of the polling loop
+func (tb *TenseiBot) runTwitchJob(channelID string, stream *helix.Stream) {
+	tb.Twitch.TwitchStreamerMutex.RLock()
+	var tracked *TwitchStreamer
+	for _, streamer := range tb.Twitch.TwitchStreamers {
+		if streamer.ChannelID == channelID {
+			tracked = streamer
+		}
+	}
+	tb.Twitch.TwitchStreamerMutex.RUnlock()
+	if tracked == nil {
+		log.Warnf("[EVENTSUB] got event for untracked channel %s", channelID)
+		return
+	}
+
+	if isStreaming(stream) {
+		tb.Twitch.fetchProfileImages([]string{channelID})
+	}
+	tb.runLiveJob(tb.twitchSource(), tracked, tb.Twitch.liveStream(stream))
+}
+
+// syncEventSubSubscriptions creates the missing stream.online/offline subscriptions
+// of all streamers and deletes the ones of streamers that aren't tracked anymore
+func (tb *TenseiBot) syncEventSubSubscriptions() {
+	if tb.Config.Twitch.EventSub.Listen == "" {
+		return
+	}
+	existing, err := tb.Twitch.getEventSubSubscriptions()
+	if err != nil {
+		log.Warn(err)
+		return
+	}
+
+	wanted := make(map[eventSubKey]bool)
+	tb.Twitch.TwitchStreamerMutex.RLock()
+	for _, streamer := range tb.Twitch.TwitchStreamers {
+		wanted[eventSubKey{helix.EventSubTypeStreamOnline, streamer.ChannelID}] = true
+		wanted[eventSubKey{helix.EventSubTypeStreamOffline, streamer.ChannelID}] = true
+	}
+	tb.Twitch.TwitchStreamerMutex.RUnlock()
+
+	callback := tb.Config.Twitch.EventSub.Callback
+	for _, sub := range existing {
+		key := eventSubKey{sub.Type, sub.Condition.BroadcasterUserID}
+		active := sub.Status == "enabled" || sub.Status == "webhook_callback_verification_pending"
+		if wanted[key] && active && sub.Transport.Callback == callback {
+			delete(wanted, key)
+			continue
+		}
+		if err := tb.Twitch.removeEventSubSubscription(sub.ID); err != nil {
+			log.Warn(err)
+		}
+	}
+
+	for key := range wanted {
+		if err := tb.Twitch.createEventSubSubscription(key.typ, key.channelID, callback, tb.Config.Twitch.EventSub.Secret); err != nil {
+			log.Warn(err)
+		}
+	}
+}
+
+func (tt *TenseiTwitch) getEventSubSubscriptions() ([]helix.EventSubSubscription, error) {
+	var subscriptions []helix.EventSubSubscription
+	cursor := ""
+	for {
+		var resp *helix.EventSubSubscriptionsResponse
+		err := tt.doHelix(priorityBackground, func() (*helix.ResponseCommon, error) {
+			var err error
+			resp, err = tt.helix.GetEventSubSubscriptions(&helix.EventSubSubscriptionsParams{
+				After: cursor,
+			})
+			if err != nil {
+				return nil, err
+			}
+			return &resp.ResponseCommon, nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("[EVENTSUB] failed getting subscriptions: %v", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("[EVENTSUB] failed getting subscriptions: %d %s", resp.StatusCode, resp.ErrorMessage)
+		}
+		subscriptions = append(subscriptions, resp.Data.EventSubSubscriptions...)
+		cursor = resp.Data.Pagination.Cursor
+		if cursor == "" {
+			return subscriptions, nil
+		}
+	}
+}
+
+func (tt *TenseiTwitch) createEventSubSubscription(typ, channelID, callback, secret string) error {
+	var resp *helix.EventSubSubscriptionsResponse
+	err := tt.doHelix(priorityBackground, func() (*helix.ResponseCommon, error) {
+		var err error
+		resp, err = tt.helix.CreateEventSubSubscription(&helix.EventSubSubscription{
+			Type:    typ,
+			Version: "1",
+			Condition: helix.EventSubCondition{
+				BroadcasterUserID: channelID,
+			},
+			Transport: helix.EventSubTransport{
+				Method:   "webhook",
+				Callback: callback,
+				Secret:   secret,
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &resp.ResponseCommon, nil
+	})
+	if err != nil {
+		return fmt.Errorf("[EVENTSUB] failed creating %s subscription for %s: %v", typ, channelID, err)
+	}
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusConflict {
+		return fmt.Errorf("[EVENTSUB] failed creating %s subscription for %s: %d %s", typ, channelID, resp.StatusCode, resp.ErrorMessage)
+	}
+	log.Infof("[EVENTSUB] created %s subscription for %s", typ, channelID)
+	return nil
+}
+
+func (tt *TenseiTwitch) removeEventSubSubscription(id string) error {
+	err := tt.doHelix(priorityBackground, func() (*helix.ResponseCommon, error) {
+		resp, err := tt.helix.RemoveEventSubSubscription(id)
+		if err != nil {
+			return nil, err
+		}
+		return &resp.ResponseCommon, nil
+	})
+	if err != nil {
+		return fmt.Errorf("[EVENTSUB] failed removing subscription %s: %v", id, err)
+	}
+	log.Infof("[EVENTSUB] removed subscription %s", id)
+	return nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nicklaw5/helix"
+)
+
+const testEventSubSecret = "s3cr3t-s3cr3t"
+
+// eventSubFake posts signed messages to an eventSubHandler like twitch does
+type eventSubFake struct {
+	server  *httptest.Server
+	notify  chan string
+	revoked chan helix.EventSubSubscription
+}
+
+func newEventSubFake(t *testing.T) *eventSubFake {
+	fake := &eventSubFake{
+		notify:  make(chan string, 10),
+		revoked: make(chan helix.EventSubSubscription, 10),
+	}
+	handler := newEventSubHandler(testEventSubSecret,
+		func(typ string, event json.RawMessage) { fake.notify <- typ },
+		func(sub helix.EventSubSubscription) { fake.revoked <- sub },
+	)
+	fake.server = httptest.NewServer(handler)
+	t.Cleanup(fake.server.Close)
+	return fake
+}
+
+// post sends body as the message type, secret signs it
+func (fake *eventSubFake) post(t *testing.T, secret, id, typ string, sent time.Time, body string) (int, string) {
+	timestamp := sent.UTC().Format(time.RFC3339Nano)
+	req, err := http.NewRequest(http.MethodPost, fake.server.URL, bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(eventSubMessageID, id)
+	req.Header.Set(eventSubMessageTimestamp, timestamp)
+	req.Header.Set(eventSubMessageType, typ)
+	req.Header.Set(eventSubMessageSignature, signEventSub(secret, id, timestamp, []byte(body)))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, string(data)
+}
+
+const testStreamOnline = `{
+	"subscription": {"id": "sub-1", "type": "stream.online", "version": "1", "status": "enabled", "condition": {"broadcaster_user_id": "1337"}},
+	"event": {"broadcaster_user_id": "1337", "broadcaster_user_login": "tensei"}
+}`
+
+func TestEventSubVerification(t *testing.T) {
+	fake := newEventSubFake(t)
+	body := `{"challenge": "pogchamp-kappa-360noscope", "subscription": {"type": "stream.online", "condition": {"broadcaster_user_id": "1337"}}}`
+	status, resp := fake.post(t, testEventSubSecret, "msg-1", eventSubTypeVerification, time.Now(), body)
+	if status != http.StatusOK || resp != "pogchamp-kappa-360noscope" {
+		t.Fatalf("got %d %q, want the challenge", status, resp)
+	}
+}
+
+func TestEventSubBadSignature(t *testing.T) {
+	fake := newEventSubFake(t)
+	status, _ := fake.post(t, "wrong-secret", "msg-1", eventSubTypeNotification, time.Now(), testStreamOnline)
+	if status != http.StatusForbidden {
+		t.Fatalf("got %d, want %d", status, http.StatusForbidden)
+	}
+	select {
+	case typ := <-fake.notify:
+		t.Fatalf("notification %s with bad signature was handled", typ)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestEventSubDuplicate(t *testing.T) {
+	fake := newEventSubFake(t)
+	for i := 0; i < 2; i++ {
+		status, _ := fake.post(t, testEventSubSecret, "msg-1", eventSubTypeNotification, time.Now(), testStreamOnline)
+		if status != http.StatusNoContent {
+			t.Fatalf("got %d, want %d", status, http.StatusNoContent)
+		}
+	}
+	select {
+	case typ := <-fake.notify:
+		if typ != "stream.online" {
+			t.Fatalf("got %s, want stream.online", typ)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("notification wasn't handled")
+	}
+	select {
+	case <-fake.notify:
+		t.Fatal("duplicate notification was handled")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestEventSubStaleTimestamp(t *testing.T) {
+	fake := newEventSubFake(t)
+	status, _ := fake.post(t, testEventSubSecret, "msg-1", eventSubTypeNotification, time.Now().Add(-2*eventSubMaxAge), testStreamOnline)
+	if status != http.StatusForbidden {
+		t.Fatalf("got %d, want %d", status, http.StatusForbidden)
+	}
+	select {
+	case <-fake.notify:
+		t.Fatal("stale notification was handled")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestEventSubRevocation(t *testing.T) {
+	fake := newEventSubFake(t)
+	body := `{"subscription": {"id": "sub-1", "type": "stream.offline", "status": "authorization_revoked", "condition": {"broadcaster_user_id": "1337"}}}`
+	status, _ := fake.post(t, testEventSubSecret, "msg-1", eventSubTypeRevocation, time.Now(), body)
+	if status != http.StatusNoContent {
+		t.Fatalf("got %d, want %d", status, http.StatusNoContent)
+	}
+	select {
+	case sub := <-fake.revoked:
+		if sub.Type != "stream.offline" || sub.Status != "authorization_revoked" || sub.Condition.BroadcasterUserID != "1337" {
+			t.Fatalf("unexpected revoked subscription %+v", sub)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("revocation wasn't handled")
+	}
+}
@@ -0,0 +1,565 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/nicklaw5/helix"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// groupMaxPages pages of streams checked per category, sorted by viewers
+	groupMaxPages = 5
+	// groupMaxAlerts new streams alerted per subscription and poll, the others follow in the next polls
+	groupMaxAlerts = 5
+	teamMembersTTL = time.Hour
+)
+
+// groupKey identifies a stream or streamer in a channel
+type groupKey struct {
+	channelID string
+	id        string
+}
+
+type teamMembers struct {
+	ids     []string
+	fetched time.Time
+}
+
+// matches returns true when the stream passes the filters of the subscription
+func (sub *TwitchGroupSubscription) matches(stream *helix.Stream) bool {
+	if !isStreaming(stream) {
+		return false
+	}
+	if sub.Kind == groupKindCategory && stream.GameID != sub.TargetID {
+		return false
+	}
+	if sub.Language != "" && !strings.EqualFold(stream.Language, sub.Language) {
+		return false
+	}
+	if stream.ViewerCount < sub.MinViewers {
+		return false
+	}
+	for _, tag := range sub.TagList() {
+		if !contains(stream.Tags, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// TagList returns the tags a stream needs
+func (sub *TwitchGroupSubscription) TagList() []string {
+	if sub.Tags == "" {
+		return nil
+	}
+	return strings.Split(sub.Tags, ",")
+}
+
+// filterSummary describes the filters of the subscription
+func (sub *TwitchGroupSubscription) filterSummary() string {
+	var filters []string
+	if sub.Language != "" {
+		filters = append(filters, fmt.Sprintf("language %s", sub.Language))
+	}
+	if sub.MinViewers > 0 {
+		filters = append(filters, fmt.Sprintf("at least %d viewers", sub.MinViewers))
+	}
+	if sub.Tags != "" {
+		filters = append(filters, fmt.Sprintf("tags %s", strings.Join(sub.TagList(), ", ")))
+	}
+	if len(filters) < 1 {
+		return "every stream"
+	}
+	return strings.Join(filters, ", ")
+}
+
+// hasFilter returns true when the subscription doesn't match every stream
+func (sub *TwitchGroupSubscription) hasFilter() bool {
+	return sub.Language != "" || sub.MinViewers > 0 || sub.Tags != ""
+}
+
+// parseGroupFilters splits lang:, viewers: and tags: filters from the category or team name
+func parseGroupFilters(text string) (string, *TwitchGroupSubscription, error) {
+	sub := &TwitchGroupSubscription{}
+	var name []string
+	for _, word := range strings.Fields(text) {
+		key, value := "", word
+		if i := strings.Index(word, ":"); i > 0 {
+			key, value = strings.ToLower(word[:i]), word[i+1:]
+		}
+		switch key {
+		case "lang", "language":
+			sub.Language = strings.ToLower(value)
+		case "viewers", "min":
+			viewers, err := strconv.Atoi(value)
+			if err != nil || viewers < 0 {
+				return "", nil, fmt.Errorf("'%s' is not a viewer count", value)
+			}
+			sub.MinViewers = viewers
+		case "tag", "tags":
+			for _, tag := range strings.Split(value, ",") {
+				if tag != "" && !contains(sub.TagList(), tag) {
+					sub.Tags = strings.Trim(sub.Tags+","+tag, ",")
+				}
+			}
+		default:
+			name = append(name, word)
+		}
+	}
+	if len(name) < 1 {
+		return "", nil, fmt.Errorf("missing name")
+	}
+	return strings.Join(name, " "), sub, nil
+}
+
+// getGameByName returns the category with the exact name
+func (tt *TenseiTwitch) getGameByName(name string) (*helix.Game, error) {
+	var resp *helix.GamesResponse
+	err := tt.doHelix(priorityUser, func() (*helix.ResponseCommon, error) {
+		var err error
+		resp, err = tt.helix.GetGames(&helix.GamesParams{Names: []string{name}})
+		if err != nil {
+			return nil, err
+		}
+		return &resp.ResponseCommon, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("[TWITCH] failed getting game %s: %v", name, err)
+	}
+	if len(resp.Data.Games) < 1 {
+		return nil, fmt.Errorf("[TWITCH] no game with name: %s found", name)
+	}
+	return &resp.Data.Games[0], nil
+}
+
+// getTeam returns the team by name or id
+func (tt *TenseiTwitch) getTeam(priority helixPriority, name, id string) (*helix.Team, error) {
+	var resp *helix.TeamsResponse
+	err := tt.doHelix(priority, func() (*helix.ResponseCommon, error) {
+		var err error
+		resp, err = tt.helix.GetTeams(&helix.TeamsParams{Name: name, ID: id})
+		if err != nil {
+			return nil, err
+		}
+		return &resp.ResponseCommon, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("[TWITCH] failed getting team %s%s: %v", name, id, err)
+	}
+	if len(resp.Data.Teams) < 1 {
+		return nil, fmt.Errorf("[TWITCH] no team %s%s found", name, id)
+	}
+	return &resp.Data.Teams[0], nil
+}
+
+// getTeamMemberIDs returns the user ids of the team, cached for teamMembersTTL,
+// only the group poll uses the cache
+func (tt *TenseiTwitch) getTeamMemberIDs(teamID string) ([]string, error) {
+	if members, ok := tt.teamMembers[teamID]; ok && time.Since(members.fetched) < teamMembersTTL {
+		return members.ids, nil
+	}
+	team, err := tt.getTeam(priorityBackground, "", teamID)
+	if err != nil {
+		return nil, err
+	}
+	members := &teamMembers{fetched: time.Now()}
+	for _, user := range team.Users {
+		members.ids = append(members.ids, user.UserID)
+	}
+	tt.teamMembers[teamID] = members
+	return members.ids, nil
+}
+
+// getStreamsByGame returns the streams of the category with at least minViewers viewers
+func (tt *TenseiTwitch) getStreamsByGame(gameID string, minViewers int) ([]helix.Stream, error) {
+	var streams []helix.Stream
+	cursor := ""
+	for page := 0; page < groupMaxPages; page++ {
+		var resp *helix.StreamsResponse
+		err := tt.doHelix(priorityBackground, func() (*helix.ResponseCommon, error) {
+			var err error
+			resp, err = tt.helix.GetStreams(&helix.StreamsParams{
+				GameIDs: []string{gameID},
+				First:   helixMaxIDs,
+				After:   cursor,
+			})
+			if err != nil {
+				return nil, err
+			}
+			return &resp.ResponseCommon, nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("[TWITCH] failed getting streams of game %s: %v", gameID, err)
+		}
+		streams = append(streams, resp.Data.Streams...)
+
+		// streams are sorted by viewers, the next page only has smaller ones
+		n := len(resp.Data.Streams)
+		if n < 1 || resp.Data.Streams[n-1].ViewerCount < minViewers || resp.Data.Pagination.Cursor == "" {
+			break
+		}
+		cursor = resp.Data.Pagination.Cursor
+	}
+	return streams, nil
+}
+
+// getStreamsOfUsers returns the streams of the users by user id, 100 per request
+func (tt *TenseiTwitch) getStreamsOfUsers(ids []string) (map[string]*helix.Stream, error) {
+	streams := make(map[string]*helix.Stream)
+	for len(ids) > 0 {
+		n := helixMaxIDs
+		if n > len(ids) {
+			n = len(ids)
+		}
+		chunk, err := tt.getStreamsByUserID(ids[:n])
+		if err != nil {
+			return nil, err
+		}
+		for id, stream := range chunk {
+			streams[id] = stream
+		}
+		ids = ids[n:]
+	}
+	return streams, nil
+}
+
+// pollTwitchGroups updates the running group alerts and sends alerts for new matching streams,
+// the requests are made without groupMutex so the group commands don't wait for them
+func (tb *TenseiBot) pollTwitchGroups() {
+	tt := tb.Twitch
+	// streamers with their own alert in a channel don't get a second one from a group
+	tracked := make(map[groupKey]bool)
+	tt.TwitchStreamerMutex.RLock()
+	for _, streamer := range tt.TwitchStreamers {
+		for _, alert := range streamer.TwitchAlertSubscriptions {
+			tracked[groupKey{alert.ChannelID, streamer.ChannelID}] = true
+		}
+	}
+	tt.TwitchStreamerMutex.RUnlock()
+
+	tt.groupMutex.Lock()
+	subs := make([]*TwitchGroupSubscription, len(tt.groupSubscriptions))
+	copy(subs, tt.groupSubscriptions)
+	var ids []string
+	for _, alert := range tt.groupAlerts {
+		if !contains(ids, alert.UserID) {
+			ids = append(ids, alert.UserID)
+		}
+	}
+	tt.groupMutex.Unlock()
+
+	// don't end the running alerts when the request failed, they would look offline
+	streams, err := tt.getStreamsOfUsers(ids)
+	if err != nil {
+		log.Warn(err)
+	}
+	matches := tb.matchGroupStreams(subs)
+	for _, match := range matches {
+		ids = append(ids, match.stream.UserID)
+	}
+	tt.fetchProfileImages(ids)
+
+	tt.groupMutex.Lock()
+	defer tt.groupMutex.Unlock()
+
+	if err == nil {
+		tb.updateGroupAlerts(streams)
+	}
+
+	live := make(map[groupKey]bool)
+	for _, alert := range tt.groupAlerts {
+		live[groupKey{alert.ChannelID, alert.StreamID}] = true
+	}
+	sent := make(map[*TwitchGroupSubscription]int)
+	for _, match := range matches {
+		stream, sub := match.stream, match.sub
+		if live[groupKey{sub.ChannelID, stream.ID}] || tracked[groupKey{sub.ChannelID, stream.UserID}] {
+			continue
+		}
+		// the subscription was removed while polling
+		if !tt.hasGroupSubscription(sub) || sent[sub] >= groupMaxAlerts {
+			continue
+		}
+		sent[sub]++
+		live[groupKey{sub.ChannelID, stream.ID}] = true
+		tb.sendGroupAlert(sub, stream)
+	}
+}
+
+type groupMatch struct {
+	sub    *TwitchGroupSubscription
+	stream *helix.Stream
+}
+
+// matchGroupStreams returns the streams that pass the filters of a subscription,
+// a stream matching several subscriptions of a channel is returned once
+func (tb *TenseiBot) matchGroupStreams(subs []*TwitchGroupSubscription) []groupMatch {
+	minViewers := make(map[string]int)
+	for _, sub := range subs {
+		if sub.Kind != groupKindCategory {
+			continue
+		}
+		if min, ok := minViewers[sub.TargetID]; !ok || sub.MinViewers < min {
+			minViewers[sub.TargetID] = sub.MinViewers
+		}
+	}
+	byGame := make(map[string][]helix.Stream)
+	for gameID, min := range minViewers {
+		streams, err := tb.Twitch.getStreamsByGame(gameID, min)
+		if err != nil {
+			log.Warn(err)
+			continue
+		}
+		byGame[gameID] = streams
+	}
+
+	byTeam := make(map[string][]*helix.Stream)
+	for _, sub := range subs {
+		if sub.Kind != groupKindTeam {
+			continue
+		}
+		if _, ok := byTeam[sub.TargetID]; ok {
+			continue
+		}
+		ids, err := tb.Twitch.getTeamMemberIDs(sub.TargetID)
+		if err != nil {
+			log.Warn(err)
+			continue
+		}
+		streams, err := tb.Twitch.getStreamsOfUsers(ids)
+		if err != nil {
+			log.Warn(err)
+			continue
+		}
+		for _, stream := range streams {
+			byTeam[sub.TargetID] = append(byTeam[sub.TargetID], stream)
+		}
+	}
+
+	var matches []groupMatch
+	seen := make(map[groupKey]bool)
+	add := func(sub *TwitchGroupSubscription, stream *helix.Stream) {
+		key := groupKey{sub.ChannelID, stream.ID}
+		if seen[key] || !sub.matches(stream) {
+			return
+		}
+		seen[key] = true
+		matches = append(matches, groupMatch{sub: sub, stream: stream})
+	}
+	for _, sub := range subs {
+		switch sub.Kind {
+		case groupKindCategory:
+			streams := byGame[sub.TargetID]
+			for i := range streams {
+				add(sub, &streams[i])
+			}
+		case groupKindTeam:
+			for _, stream := range byTeam[sub.TargetID] {
+				add(sub, stream)
+			}
+		}
+	}
+	return matches
+}
+
+// groupStreamer stands in for a tracked streamer to reuse the alert embeds
+func groupStreamer(login, userID string, startedAt time.Time) *TwitchStreamer {
+	return &TwitchStreamer{
+		Name:      login,
+		ChannelID: userID,
+		LiveState: LiveState{StreamStartTime: startedAt.UTC()},
+	}
+}
+
+// sendGroupAlert sends the live embed of a stream matching the subscription, the caller has to hold groupMutex
+func (tb *TenseiBot) sendGroupAlert(sub *TwitchGroupSubscription, stream *helix.Stream) {
+	streamer := groupStreamer(stream.UserLogin, stream.UserID, stream.StartedAt)
+	tb.Twitch.setProfileImage(streamer)
+	embed := tb.Twitch.createLiveEmbed(stream, streamer, tb.guildTimeFormat(sub.GuildID))
+	msg, err := tb.Discord.c.ChannelMessageSendEmbed(sub.ChannelID, embed)
+	if err != nil {
+		log.Errorf("[TWITCH_GROUP] failed sending embed to channel: %s, streamer: %s", sub.ChannelID, stream.UserLogin)
+		return
+	}
+
+	alert := &TwitchGroupAlert{
+		TwitchGroupSubscriptionID: sub.ID,
+		ChannelID:                 sub.ChannelID,
+		GuildID:                   sub.GuildID,
+		StreamID:                  stream.ID,
+		UserID:                    stream.UserID,
+		UserLogin:                 stream.UserLogin,
+		MessageID:                 msg.ID,
+		StartedAt:                 stream.StartedAt.UTC(),
+		Live:                      true,
+	}
+	if err := tb.AddGroupAlert(alert); err != nil {
+		log.Errorf("[TWITCH_GROUP] failed saving alert for stream: %s in channel: %s: %v", stream.ID, sub.ChannelID, err)
+		return
+	}
+	tb.Twitch.groupAlerts = append(tb.Twitch.groupAlerts, alert)
+	log.Infof("[TWITCH_GROUP] sent alert for %s to channel: %s (%s %s)", stream.UserLogin, sub.ChannelID, sub.Kind, sub.TargetName)
+}
+
+// updateGroupAlerts edits the live embeds of running streams and ends the others, streams are the
+// polled streams by user id, the caller has to hold groupMutex
+func (tb *TenseiBot) updateGroupAlerts(streams map[string]*helix.Stream) {
+	var live []*TwitchGroupAlert
+	for _, alert := range tb.Twitch.groupAlerts {
+		stream := streams[alert.UserID]
+		if isStreaming(stream) && stream.ID == alert.StreamID {
+			live = append(live, alert)
+			streamer := groupStreamer(alert.UserLogin, alert.UserID, alert.StartedAt)
+			tb.Twitch.setProfileImage(streamer)
+			embed := tb.Twitch.createLiveEmbed(stream, streamer, tb.guildTimeFormat(alert.GuildID))
+			if _, err := tb.Discord.c.ChannelMessageEditEmbed(alert.ChannelID, alert.MessageID, embed); err != nil {
+				log.Errorf("[TWITCH_GROUP] failed editing embed in channel: %s, streamer: %s", alert.ChannelID, alert.UserLogin)
+			}
+			continue
+		}
+		tb.endGroupAlert(alert)
+	}
+	tb.Twitch.groupAlerts = live
+}
+
+// endGroupAlert turns the live embed of the alert into an end embed
+func (tb *TenseiBot) endGroupAlert(alert *TwitchGroupAlert) {
+	streamer := groupStreamer(alert.UserLogin, alert.UserID, alert.StartedAt)
+	tb.Twitch.setProfileImage(streamer)
+	streamer.StreamEndTime = time.Now().UTC()
+	alert.Live = false
+	tb.UpdateGroupAlert(alert)
+	embed := createEndEmbed(streamer, nil, nil, tb.guildTimeFormat(alert.GuildID))
+	if _, err := tb.Discord.c.ChannelMessageEditEmbed(alert.ChannelID, alert.MessageID, embed); err != nil {
+		log.Errorf("[TWITCH_GROUP] failed editing end embed in channel: %s, streamer: %s", alert.ChannelID, alert.UserLogin)
+	}
+}
+
+// groupSubscription returns the subscription of the channel, the caller has to hold groupMutex
+func (tt *TenseiTwitch) groupSubscription(kind, channelID, name string) *TwitchGroupSubscription {
+	for _, sub := range tt.groupSubscriptions {
+		if sub.Kind == kind && sub.ChannelID == channelID && strings.EqualFold(sub.TargetName, name) {
+			return sub
+		}
+	}
+	return nil
+}
+
+// hasGroupSubscription returns true when the subscription wasn't removed, the caller has to hold groupMutex
+func (tt *TenseiTwitch) hasGroupSubscription(sub *TwitchGroupSubscription) bool {
+	for _, s := range tt.groupSubscriptions {
+		if s == sub {
+			return true
+		}
+	}
+	return false
+}
+
+// groupSubscriptionFields returns the category and team subscriptions of the guild for !twitch list
+func (tb *TenseiBot) groupSubscriptionFields(s *discordgo.Session, guildID string) []*discordgo.MessageEmbedField {
+	tb.Twitch.groupMutex.Lock()
+	defer tb.Twitch.groupMutex.Unlock()
+
+	var fields []*discordgo.MessageEmbedField
+	for _, sub := range tb.Twitch.groupSubscriptions {
+		if sub.GuildID != guildID {
+			continue
+		}
+		fields = append(fields, &discordgo.MessageEmbedField{
+			Name:  fmt.Sprintf("%s %s in #%s", sub.Kind, sub.TargetName, channelName(s, sub.ChannelID)),
+			Value: sub.filterSummary(),
+		})
+	}
+	return fields
+}
+
+func discordTwitchGroupAdd(tb *TenseiBot, kind string) commandFunc {
+	return func(ctx *commandContext) {
+		channelID := ctx.str("channel")
+		if err := guildChannel(ctx.s, ctx.guildID, channelID); err != nil {
+			ctx.error("%v", err)
+			return
+		}
+		name, sub, err := parseGroupFilters(ctx.str(kind))
+		if err != nil {
+			ctx.error("%v", err)
+			return
+		}
+
+		switch kind {
+		case groupKindCategory:
+			// most categories have more streams than anyone wants alerts for
+			if !sub.hasFilter() {
+				ctx.error("category alerts need a lang:, viewers: or tags: filter")
+				return
+			}
+			game, err := tb.Twitch.getGameByName(name)
+			if err != nil {
+				log.Warn(err)
+				ctx.error("couldn't find category %s", name)
+				return
+			}
+			sub.TargetID, sub.TargetName = game.ID, game.Name
+		case groupKindTeam:
+			team, err := tb.Twitch.getTeam(priorityUser, strings.ToLower(name), "")
+			if err != nil {
+				log.Warn(err)
+				ctx.error("couldn't find team %s", name)
+				return
+			}
+			sub.TargetID, sub.TargetName = team.ID, team.TeamName
+		}
+		sub.Kind = kind
+		sub.ChannelID = channelID
+		sub.GuildID = ctx.guildID
+
+		tb.Twitch.groupMutex.Lock()
+		defer tb.Twitch.groupMutex.Unlock()
+		if existing := tb.Twitch.groupSubscription(kind, channelID, sub.TargetName); existing != nil {
+			ctx.error("there already is a %s %s alert in <#%s>", kind, existing.TargetName, channelID)
+			return
+		}
+		tb.AddGroupSubscription(sub)
+		tb.Twitch.groupSubscriptions = append(tb.Twitch.groupSubscriptions, sub)
+		ctx.success("added %s %s alert to <#%s> for %s", kind, sub.TargetName, channelID, sub.filterSummary())
+	}
+}
+
+func discordTwitchGroupRemove(tb *TenseiBot, kind string) commandFunc {
+	return func(ctx *commandContext) {
+		channelID := ctx.str("channel")
+		name := ctx.str(kind)
+
+		tb.Twitch.groupMutex.Lock()
+		defer tb.Twitch.groupMutex.Unlock()
+		sub := tb.Twitch.groupSubscription(kind, channelID, name)
+		if sub == nil || sub.GuildID != ctx.guildID {
+			ctx.error("there is no %s %s alert in <#%s>", kind, name, channelID)
+			return
+		}
+		tb.RemoveGroupSubscription(sub)
+		for i, s := range tb.Twitch.groupSubscriptions {
+			if s == sub {
+				tb.Twitch.groupSubscriptions = append(tb.Twitch.groupSubscriptions[:i], tb.Twitch.groupSubscriptions[i+1:]...)
+				break
+			}
+		}
+
+		// nothing updates the alerts of the subscription anymore
+		var live []*TwitchGroupAlert
+		for _, alert := range tb.Twitch.groupAlerts {
+			if alert.TwitchGroupSubscriptionID == sub.ID {
+				tb.endGroupAlert(alert)
+				continue
+			}
+			live = append(live, alert)
+		}
+		tb.Twitch.groupAlerts = live
+		ctx.success("removed %s %s alert from <#%s>", kind, sub.TargetName, channelID)
+	}
+}
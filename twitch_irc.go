@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	twitchChatAddress = "irc.chat.twitch.tv:6697"
+	// twitch pings about every 5 minutes, no line for longer means the connection is dead
+	chatReadTimeout       = 6 * time.Minute
+	chatReconnectDelay    = 5 * time.Second
+	chatMaxReconnectDelay = 5 * time.Minute
+	// twitch allows 20 joins every 10 seconds
+	chatJoinBatch    = 20
+	chatJoinInterval = 11 * time.Second
+)
+
+// chatMessage PRIVMSG from twitch chat
+type chatMessage struct {
+	// channel login of the streamer without #
+	channel string
+	user    string
+	text    string
+	// action messages are sent with /me
+	action bool
+}
+
+// ircClient read only twitch chat client, it keeps the joined channels across reconnects
+type ircClient struct {
+	address   string
+	useTLS    bool
+	nick      string
+	pass      string
+	onMessage func(*chatMessage)
+
+	mutex    sync.Mutex
+	conn     net.Conn
+	channels map[string]bool
+	running  sync.Once
+}
+
+// newIRCClient connects anonymously when nick is empty
+func newIRCClient(address string, useTLS bool, nick, token string, onMessage func(*chatMessage)) *ircClient {
+	if nick == "" {
+		nick = fmt.Sprintf("justinfan%d", 10000+rand.Intn(90000))
+		token = ""
+	}
+	if token != "" && !strings.HasPrefix(token, "oauth:") {
+		token = "oauth:" + token
+	}
+	return &ircClient{
+		address:   address,
+		useTLS:    useTLS,
+		nick:      strings.ToLower(nick),
+		pass:      token,
+		onMessage: onMessage,
+		channels:  make(map[string]bool),
+	}
+}
+
+// join joins the chat of the channel, the first join connects
+func (c *ircClient) join(channel string) {
+	channel = strings.ToLower(channel)
+	c.mutex.Lock()
+	if !c.channels[channel] {
+		c.channels[channel] = true
+		if c.conn != nil {
+			c.write("JOIN #" + channel)
+		}
+	}
+	c.mutex.Unlock()
+	c.running.Do(func() { go c.run() })
+}
+
+// part leaves the chat of the channel
+func (c *ircClient) part(channel string) {
+	channel = strings.ToLower(channel)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !c.channels[channel] {
+		return
+	}
+	delete(c.channels, channel)
+	if c.conn != nil {
+		c.write("PART #" + channel)
+	}
+}
+
+// write sends a line, the caller has to hold mutex
+func (c *ircClient) write(line string) {
+	if c.conn == nil {
+		return
+	}
+	if _, err := fmt.Fprintf(c.conn, "%s\r\n", line); err != nil {
+		log.Warnf("[TWITCH_CHAT] failed sending %s: %v", strings.Fields(line)[0], err)
+	}
+}
+
+func (c *ircClient) send(line string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.write(line)
+}
+
+// run keeps the client connected
+func (c *ircClient) run() {
+	delay := chatReconnectDelay
+	for {
+		connected := time.Now()
+		err := c.connect()
+		// a connection that held for a while starts over with a short delay
+		if time.Since(connected) > chatMaxReconnectDelay {
+			delay = chatReconnectDelay
+		}
+		log.Warnf("[TWITCH_CHAT] disconnected: %v, reconnecting in %s", err, delay)
+		time.Sleep(delay)
+		delay *= 2
+		if delay > chatMaxReconnectDelay {
+			delay = chatMaxReconnectDelay
+		}
+	}
+}
+
+// connect logs in, joins the channels and reads until the connection breaks
+func (c *ircClient) connect() error {
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if c.useTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", c.address, nil)
+	} else {
+		conn, err = dialer.Dial("tcp", c.address)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	c.mutex.Lock()
+	c.conn = conn
+	if c.pass != "" {
+		c.write("PASS " + c.pass)
+	}
+	c.write("NICK " + c.nick)
+	c.write("CAP REQ :twitch.tv/tags")
+	var channels []string
+	for channel := range c.channels {
+		channels = append(channels, "#"+channel)
+	}
+	c.mutex.Unlock()
+	defer func() {
+		c.mutex.Lock()
+		c.conn = nil
+		c.mutex.Unlock()
+	}()
+	log.Infof("[TWITCH_CHAT] connected to %s as %s", c.address, c.nick)
+	go c.joinAll(conn, channels)
+
+	reader := bufio.NewReader(conn)
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(chatReadTimeout))
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		if err := c.handleLine(strings.TrimRight(line, "\r\n")); err != nil {
+			return err
+		}
+	}
+}
+
+// joinAll joins the channels in batches to stay below the join limit
+func (c *ircClient) joinAll(conn net.Conn, channels []string) {
+	for len(channels) > 0 {
+		n := chatJoinBatch
+		if n > len(channels) {
+			n = len(channels)
+		}
+		c.mutex.Lock()
+		// stop when the connection was replaced
+		if c.conn != conn {
+			c.mutex.Unlock()
+			return
+		}
+		c.write("JOIN " + strings.Join(channels[:n], ","))
+		c.mutex.Unlock()
+		channels = channels[n:]
+		if len(channels) > 0 {
+			time.Sleep(chatJoinInterval)
+		}
+	}
+}
+
+func (c *ircClient) handleLine(line string) error {
+	tags, prefix, command, params := parseIRCLine(line)
+	switch command {
+	case "PING":
+		c.send("PONG :" + lastParam(params))
+	case "RECONNECT":
+		return fmt.Errorf("server asked to reconnect")
+	case "NOTICE":
+		log.Warnf("[TWITCH_CHAT] notice: %s", lastParam(params))
+	case "PRIVMSG":
+		if len(params) < 2 {
+			return nil
+		}
+		msg := &chatMessage{
+			channel: strings.TrimPrefix(params[0], "#"),
+			user:    tags["display-name"],
+			text:    params[1],
+		}
+		if msg.user == "" {
+			msg.user = strings.SplitN(prefix, "!", 2)[0]
+		}
+		if strings.HasPrefix(msg.text, "\x01ACTION ") {
+			msg.action = true
+			msg.text = strings.TrimSuffix(strings.TrimPrefix(msg.text, "\x01ACTION "), "\x01")
+		}
+		c.onMessage(msg)
+	}
+	return nil
+}
+
+var ircTagReplacer = strings.NewReplacer(`\s`, " ", `\:`, ";", `\\`, `\`, `\r`, "\r", `\n`, "\n")
+
+// parseIRCLine splits "@tags :prefix COMMAND params :trailing" into its parts
+func parseIRCLine(line string) (map[string]string, string, string, []string) {
+	tags := make(map[string]string)
+	if strings.HasPrefix(line, "@") {
+		var raw string
+		raw, line = splitWord(line[1:])
+		for _, tag := range strings.Split(raw, ";") {
+			kv := strings.SplitN(tag, "=", 2)
+			if len(kv) == 2 {
+				tags[kv[0]] = ircTagReplacer.Replace(kv[1])
+			} else {
+				tags[kv[0]] = ""
+			}
+		}
+	}
+
+	var prefix string
+	if strings.HasPrefix(line, ":") {
+		prefix, line = splitWord(line[1:])
+	}
+	command, line := splitWord(line)
+
+	var params []string
+	for line != "" {
+		if strings.HasPrefix(line, ":") {
+			params = append(params, line[1:])
+			break
+		}
+		var param string
+		param, line = splitWord(line)
+		params = append(params, param)
+	}
+	return tags, prefix, strings.ToUpper(command), params
+}
+
+func splitWord(s string) (string, string) {
+	parts := strings.SplitN(s, " ", 2)
+	if len(parts) < 2 {
+		return parts[0], ""
+	}
+	return parts[0], strings.TrimLeft(parts[1], " ")
+}
+
+func lastParam(params []string) string {
+	if len(params) < 1 {
+		return ""
+	}
+	return params[len(params)-1]
+}
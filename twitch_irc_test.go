@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ircFake is a local stand-in for the twitch chat server
+type ircFake struct {
+	listener net.Listener
+	conns    chan *ircFakeConn
+}
+
+type ircFakeConn struct {
+	conn  net.Conn
+	lines chan string
+}
+
+func newIRCFake(t *testing.T) *ircFake {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := &ircFake{listener: listener, conns: make(chan *ircFakeConn, 4)}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			fc := &ircFakeConn{conn: conn, lines: make(chan string, 100)}
+			t.Cleanup(func() { conn.Close() })
+			go func() {
+				reader := bufio.NewReader(conn)
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil {
+						close(fc.lines)
+						return
+					}
+					fc.lines <- strings.TrimRight(line, "\r\n")
+				}
+			}()
+			fake.conns <- fc
+		}
+	}()
+	return fake
+}
+
+// accept waits for the client to connect
+func (fake *ircFake) accept(t *testing.T, timeout time.Duration) *ircFakeConn {
+	t.Helper()
+	select {
+	case fc := <-fake.conns:
+		return fc
+	case <-time.After(timeout):
+		t.Fatal("client didn't connect")
+	}
+	return nil
+}
+
+func (fc *ircFakeConn) send(t *testing.T, line string) {
+	t.Helper()
+	if _, err := fmt.Fprintf(fc.conn, "%s\r\n", line); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// expect reads lines until one starts with prefix
+func (fc *ircFakeConn) expect(t *testing.T, prefix string) string {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case line, ok := <-fc.lines:
+			if !ok {
+				t.Fatalf("connection closed while waiting for %s", prefix)
+			}
+			if strings.HasPrefix(line, prefix) {
+				return line
+			}
+		case <-timeout:
+			t.Fatalf("client didn't send %s", prefix)
+		}
+	}
+}
+
+// expectJoins reads lines until every channel was joined
+func (fc *ircFakeConn) expectJoins(t *testing.T, channels ...string) {
+	t.Helper()
+	missing := make(map[string]bool)
+	for _, channel := range channels {
+		missing["#"+channel] = true
+	}
+	for len(missing) > 0 {
+		line := fc.expect(t, "JOIN ")
+		for _, channel := range strings.Split(strings.TrimPrefix(line, "JOIN "), ",") {
+			delete(missing, channel)
+		}
+	}
+}
+
+func TestParseIRCLine(t *testing.T) {
+	line := `@badge-info=;color=#FF0000;display-name=Tensei\sBot;emotes= :tensei!tensei@tensei.tmi.twitch.tv PRIVMSG #forsen :hello there :)`
+	tags, prefix, command, params := parseIRCLine(line)
+	if tags["display-name"] != "Tensei Bot" || tags["color"] != "#FF0000" || tags["emotes"] != "" {
+		t.Fatalf("unexpected tags %v", tags)
+	}
+	if _, ok := tags["badge-info"]; !ok {
+		t.Fatalf("empty tag badge-info is missing from %v", tags)
+	}
+	if prefix != "tensei!tensei@tensei.tmi.twitch.tv" || command != "PRIVMSG" {
+		t.Fatalf("got prefix %q and command %q", prefix, command)
+	}
+	if want := []string{"#forsen", "hello there :)"}; !reflect.DeepEqual(params, want) {
+		t.Fatalf("got params %q, want %q", params, want)
+	}
+
+	_, prefix, command, params = parseIRCLine("PING :tmi.twitch.tv")
+	if prefix != "" || command != "PING" || lastParam(params) != "tmi.twitch.tv" {
+		t.Fatalf("got %q %q %q", prefix, command, params)
+	}
+}
+
+func TestIRCClient(t *testing.T) {
+	fake := newIRCFake(t)
+	messages := make(chan *chatMessage, 10)
+	client := newIRCClient(fake.listener.Addr().String(), false, "", "", func(msg *chatMessage) { messages <- msg })
+	client.join("Forsen")
+	client.join("tensei")
+
+	fc := fake.accept(t, 2*time.Second)
+	if nick := fc.expect(t, "NICK "); !strings.HasPrefix(nick, "NICK justinfan") {
+		t.Fatalf("anonymous client sent %s", nick)
+	}
+	fc.expectJoins(t, "forsen", "tensei")
+
+	fc.send(t, "PING :tmi.twitch.tv")
+	if pong := fc.expect(t, "PONG"); pong != "PONG :tmi.twitch.tv" {
+		t.Fatalf("got %q", pong)
+	}
+
+	fc.send(t, `@display-name=Tensei :tensei!tensei@tensei.tmi.twitch.tv PRIVMSG #forsen :`+"\x01ACTION waves\x01")
+	fc.send(t, `:someone!someone@someone.tmi.twitch.tv PRIVMSG #tensei :hi chat`)
+	for _, want := range []chatMessage{
+		{channel: "forsen", user: "Tensei", text: "waves", action: true},
+		{channel: "tensei", user: "someone", text: "hi chat"},
+	} {
+		select {
+		case msg := <-messages:
+			if *msg != want {
+				t.Fatalf("got %+v, want %+v", *msg, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("message %q wasn't relayed", want.text)
+		}
+	}
+
+	// twitch asks clients to reconnect before restarting a server
+	fc.send(t, ":tmi.twitch.tv RECONNECT")
+	reconnected := fake.accept(t, chatReconnectDelay+3*time.Second)
+	reconnected.expect(t, "NICK ")
+	reconnected.expectJoins(t, "forsen", "tensei")
+}
+
+func TestJoinLines(t *testing.T) {
+	var lines []string
+	for i := 0; i < 5; i++ {
+		lines = append(lines, strings.Repeat(fmt.Sprint(i), 900))
+	}
+	messages := joinLines(lines, discordMessageLimit)
+	if len(messages) != 3 {
+		t.Fatalf("got %d messages, want 3", len(messages))
+	}
+	for _, msg := range messages {
+		if len(msg) > discordMessageLimit {
+			t.Fatalf("message with %d characters is over the limit", len(msg))
+		}
+	}
+	if strings.Join(messages, "\n") != strings.Join(lines, "\n") {
+		t.Fatal("lines got lost or reordered")
+	}
+	if messages := joinLines([]string{"a", "b"}, discordMessageLimit); len(messages) != 1 || messages[0] != "a\nb" {
+		t.Fatalf("got %q", messages)
+	}
+}
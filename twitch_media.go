@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nicklaw5/helix"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	endEmbedClips = 3
+	// the VOD of a stream can show up a few minutes after it ended
+	vodRetryDelay     = 5 * time.Minute
+	vodMaxRetries     = 3
+	vodStartTolerance = 5 * time.Minute
+)
+
+// streamMedia VOD and top clips of an ended stream for the end embed
+type streamMedia struct {
+	vod   *helix.Video
+	clips []helix.Clip
+}
+
+// endedStream session and media of a stream that ended for its end embeds
+type endedStream struct {
+	session *TwitchStreamSession
+	media   *streamMedia
+}
+
+// endedMessage end embed that gets edited again once the VOD is available
+type endedMessage struct {
+	alert     *AlertSubscription
+	messageID string
+}
+
+// getStreamVideo returns the VOD of the stream, nil if there is none yet
+func (tt *TenseiTwitch) getStreamVideo(userID, streamID string, startedAt time.Time) (*helix.Video, error) {
+	var resp *helix.VideosResponse
+	err := tt.doHelix(priorityBackground, func() (*helix.ResponseCommon, error) {
+		var err error
+		resp, err = tt.helix.GetVideos(&helix.VideosParams{
+			UserID: userID,
+			Type:   "archive",
+			First:  5,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &resp.ResponseCommon, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("[TWITCH] failed getting videos of %s: %v", userID, err)
+	}
+
+	for i, video := range resp.Data.Videos {
+		if streamID != "" && video.StreamID == streamID {
+			return &resp.Data.Videos[i], nil
+		}
+		// older videos don't have the stream id, the archive is created when the stream starts
+		created, err := time.Parse(time.RFC3339, video.CreatedAt)
+		if video.StreamID == "" && err == nil && absDuration(created.Sub(startedAt)) < vodStartTolerance {
+			return &resp.Data.Videos[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// getTopClips returns the most viewed clips created between start and end
+func (tt *TenseiTwitch) getTopClips(userID string, start, end time.Time) ([]helix.Clip, error) {
+	var resp *helix.ClipsResponse
+	err := tt.doHelix(priorityBackground, func() (*helix.ResponseCommon, error) {
+		var err error
+		resp, err = tt.helix.GetClips(&helix.ClipsParams{
+			BroadcasterID: userID,
+			StartedAt:     helix.Time{Time: start},
+			EndedAt:       helix.Time{Time: end},
+			First:         endEmbedClips,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &resp.ResponseCommon, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("[TWITCH] failed getting clips of %s: %v", userID, err)
+	}
+	clips := resp.Data.Clips
+	if len(clips) > endEmbedClips {
+		clips = clips[:endEmbedClips]
+	}
+	return clips, nil
+}
+
+// getStreamMedia looks up the VOD and top clips of the stream that just ended
+func (tt *TenseiTwitch) getStreamMedia(streamer *TwitchStreamer, session *TwitchStreamSession) *streamMedia {
+	media := &streamMedia{}
+	streamID := ""
+	if session != nil {
+		streamID = session.StreamID
+	}
+
+	var err error
+	if media.vod, err = tt.getStreamVideo(streamer.ChannelID, streamID, streamer.StreamStartTime); err != nil {
+		log.Warn(err)
+	}
+	// clips made in the last minutes of the stream still count
+	if media.clips, err = tt.getTopClips(streamer.ChannelID, streamer.StreamStartTime, time.Now().UTC()); err != nil {
+		log.Warn(err)
+	}
+	return media
+}
+
+// retryStreamVideo looks for the VOD again and edits the end embeds once it is found,
+// streamer is a copy so a new stream doesn't change it
+func (tb *TenseiBot) retryStreamVideo(streamer TwitchStreamer, session *TwitchStreamSession, media *streamMedia, messages []endedMessage) {
+	streamID := ""
+	if session != nil {
+		streamID = session.StreamID
+	}
+	for retry := 0; retry < vodMaxRetries; retry++ {
+		time.Sleep(vodRetryDelay)
+		vod, err := tb.Twitch.getStreamVideo(streamer.ChannelID, streamID, streamer.StreamStartTime)
+		if err != nil {
+			log.Warn(err)
+			continue
+		}
+		if vod == nil {
+			continue
+		}
+
+		log.Infof("[TWITCH_JOB] found VOD of %s after %d retries", streamer.Name, retry+1)
+		media.vod = vod
+		for _, msg := range messages {
+			embed := createEndEmbed(&streamer, session, media, tb.alertTimeFormat(msg.alert))
+			if _, err := tb.Discord.c.ChannelMessageEditEmbed(msg.alert.ChannelID, msg.messageID, embed); err != nil {
+				log.Errorf("[TWITCH_JOB] (stream end) failed adding VOD to embed in channel: %s, streamer: %s", msg.alert.ChannelID, streamer.Name)
+			}
+		}
+		return
+	}
+	log.Infof("[TWITCH_JOB] no VOD of %s found", streamer.Name)
+}
+
+// write adds the VOD and clip links to the end embed description
+func (media *streamMedia) write(sb *strings.Builder) {
+	if media.vod != nil {
+		sb.WriteString(fmt.Sprintf("\n**VOD:** [%s](%s) (%d views)", escapeDiscord(media.vod.Title), media.vod.URL, media.vod.ViewCount))
+	}
+	if len(media.clips) > 0 {
+		sb.WriteString("\n**Top Clips:**")
+		for _, clip := range media.clips {
+			sb.WriteString(fmt.Sprintf("\n[%s](%s) (%d views)", escapeDiscord(clip.Title), clip.URL, clip.ViewCount))
+		}
+	}
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/nicklaw5/helix"
+	log "github.com/sirupsen/logrus"
+)
+
+type helixPriority int
+
+const (
+	// priorityBackground requests from jobs, they wait while commands are queued
+	priorityBackground helixPriority = iota
+	// priorityUser requests from commands
+	priorityUser
+)
+
+const (
+	// helixUserReserve requests background jobs leave for commands
+	helixUserReserve = 5
+	helixMaxRetries  = 4
+	helixRetryDelay  = time.Second
+)
+
+// helixRequest calls helix and returns the common part of the response
+type helixRequest func() (*helix.ResponseCommon, error)
+
+// doHelix runs the request once the rate limit allows it and retries when helix answers 429
+func (tt *TenseiTwitch) doHelix(priority helixPriority, req helixRequest) error {
+	delay := helixRetryDelay
+	refreshed := false
+	for attempt := 0; ; attempt++ {
+		tt.acquireHelix(priority)
+		resp, err := req()
+		if err != nil {
+			return err
+		}
+		tt.updateRateLimit(resp.GetRateLimit(), resp.GetRateLimitRemaining(), resp.GetRateLimitReset())
+
+		// the token expired or got revoked, get a new one and try once more
+		if resp.StatusCode == http.StatusUnauthorized && tt.clientSecret != "" && !refreshed {
+			log.Warnf("[TWITCH_AUTH] helix returned 401: %s", resp.ErrorMessage)
+			refreshed = true
+			if err := tt.refreshAppAccessToken(); err != nil {
+				return fmt.Errorf("[TWITCH_AUTH] unauthorized and failed refreshing app access token: %v", err)
+			}
+			continue
+		}
+		if resp.StatusCode == http.StatusUnauthorized {
+			return fmt.Errorf("[TWITCH_AUTH] unauthorized: %s", resp.ErrorMessage)
+		}
+		if resp.StatusCode != http.StatusTooManyRequests {
+			return nil
+		}
+		if attempt == helixMaxRetries {
+			return fmt.Errorf("[TWITCH_RATELIMIT] still rate limited after %d retries", helixMaxRetries)
+		}
+		log.Warnf("[TWITCH_RATELIMIT] rate limited, retrying in %s", delay)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// acquireHelix blocks until a request can be made, background requests also
+// wait while commands are waiting or only the reserve for commands is left
+func (tt *TenseiTwitch) acquireHelix(priority helixPriority) {
+	if priority == priorityUser {
+		tt.RateLimitMutex.Lock()
+		tt.waitingUserRequests++
+		tt.RateLimitMutex.Unlock()
+		defer func() {
+			tt.RateLimitMutex.Lock()
+			tt.waitingUserRequests--
+			tt.RateLimitMutex.Unlock()
+		}()
+	}
+
+	for {
+		wait := tt.takeHelixToken(priority)
+		if wait <= 0 {
+			return
+		}
+		log.Debugf("[TWITCH_RATELIMIT] waiting %s for the rate limit to reset", wait)
+		time.Sleep(wait)
+	}
+}
+
+// takeHelixToken returns how long to wait before trying again, 0 if the request can be made
+func (tt *TenseiTwitch) takeHelixToken(priority helixPriority) time.Duration {
+	tt.RateLimitMutex.Lock()
+	defer tt.RateLimitMutex.Unlock()
+
+	// no request made yet, the limit is unknown
+	if tt.RateLimit == 0 {
+		return 0
+	}
+	if time.Now().After(tt.RateLimitReset) {
+		tt.RateLimitRemaining = tt.RateLimit
+		tt.RateLimitReset = time.Now().Add(time.Minute)
+	}
+
+	reserve := 0
+	if priority == priorityBackground {
+		if tt.waitingUserRequests > 0 {
+			return 100 * time.Millisecond
+		}
+		reserve = helixUserReserve
+	}
+	if tt.RateLimitRemaining > reserve {
+		tt.RateLimitRemaining--
+		return 0
+	}
+
+	wait := time.Until(tt.RateLimitReset)
+	if wait > time.Second {
+		wait = time.Second
+	}
+	return wait + time.Millisecond
+}
+
+// RateLimitBudget returns the remaining requests, the limit and when it resets
+func (tt *TenseiTwitch) RateLimitBudget() (int, int, time.Time) {
+	tt.RateLimitMutex.RLock()
+	defer tt.RateLimitMutex.RUnlock()
+
+	return tt.RateLimitRemaining, tt.RateLimit, tt.RateLimitReset
+}
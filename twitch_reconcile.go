@@ -0,0 +1,123 @@
+package main
+
+import (
+	"time"
+
+	"github.com/nicklaw5/helix"
+	log "github.com/sirupsen/logrus"
+)
+
+// reconcileTwitchStreams brings the alerts in line with the streams after a restart,
+// streams that ended while the bot was down get their end embeds, running streams keep
+// their live embeds and deleted live embeds are sent again
+func (tb *TenseiBot) reconcileTwitchStreams() {
+	tb.Twitch.TwitchStreamerMutex.RLock()
+	streamers := make([]*TwitchStreamer, len(tb.Twitch.TwitchStreamers))
+	copy(streamers, tb.Twitch.TwitchStreamers)
+	tb.Twitch.TwitchStreamerMutex.RUnlock()
+
+	for len(streamers) > 0 {
+		n := helixMaxIDs
+		if n > len(streamers) {
+			n = len(streamers)
+		}
+		tb.reconcileTwitchStreamers(streamers[:n])
+		streamers = streamers[n:]
+	}
+	log.Info("[TWITCH] reconciled live alerts")
+}
+
+func (tb *TenseiBot) reconcileTwitchStreamers(streamers []*TwitchStreamer) {
+	ids := make([]string, 0, len(streamers))
+	for _, streamer := range streamers {
+		ids = append(ids, streamer.ChannelID)
+	}
+	// without the current state every streamer would look offline, leave it to the jobs
+	streams, err := tb.Twitch.getStreamsByUserID(ids)
+	if err != nil {
+		log.Warnf("[TWITCH] skipping reconciliation: %v", err)
+		return
+	}
+
+	var gameIDs []string
+	for _, stream := range streams {
+		gameIDs = append(gameIDs, stream.GameID)
+	}
+	if err := tb.Twitch.cacheGames(gameIDs); err != nil {
+		log.Warn(err)
+	}
+
+	source := tb.twitchSource()
+	for _, streamer := range streamers {
+		if !beginLiveJob(source, streamer) {
+			continue
+		}
+		source.Lock()
+		live, started := streamer.IsLive(), streamer.StreamStartTime
+		source.Unlock()
+
+		stream := streams[streamer.ChannelID]
+		switch {
+		case !live:
+		case isStreaming(stream) && stream.StartedAt.UTC().Equal(started):
+			tb.reattachLiveAlerts(streamer, stream)
+		default:
+			// the stream ended while the bot was down, a new one is picked up by the next poll
+			tb.finishStaleStream(streamer)
+		}
+		endLiveJob(source, streamer)
+	}
+}
+
+// finishStaleStream ends a stream that went offline while the bot was down,
+// the end time is the last poll that saw it live
+func (tb *TenseiBot) finishStaleStream(streamer *TwitchStreamer) {
+	source := tb.twitchSource()
+	source.Lock()
+	streamer.StreamEndTime = time.Now().UTC()
+	if session, err := tb.GetOpenStreamSession(streamer); err == nil {
+		streamer.Session = session
+		streamer.StreamEndTime = session.UpdatedAt.UTC()
+	}
+	log.Infof("[TWITCH] streamer %s stopped streaming while offline, length %s", streamer.Name, streamer.StreamLength())
+	alerts := streamer.Alerts()
+	source.Unlock()
+	tb.endLiveAlerts(source, streamer, alerts)
+}
+
+// reattachLiveAlerts continues a stream that is still running, the existing live embeds
+// are kept and only subscriptions without a message get a new one
+func (tb *TenseiBot) reattachLiveAlerts(streamer *TwitchStreamer, stream *helix.Stream) {
+	log.Infof("[TWITCH] streamer %s is still live, reattaching", streamer.Name)
+	source := tb.twitchSource()
+	tb.Twitch.fetchProfileImages([]string{streamer.ChannelID})
+
+	source.Lock()
+	if session, err := tb.GetOpenStreamSession(streamer); err == nil {
+		streamer.Session = session
+	}
+	tb.Twitch.setProfileImage(streamer)
+	var messages []*liveMessage
+	for _, alert := range streamer.Alerts() {
+		messages = append(messages, tb.newLiveMessage(source, streamer, tb.Twitch.liveStream(stream), alert))
+	}
+	source.Unlock()
+
+	var missing []*liveMessage
+	for _, m := range messages {
+		if m.messageID != "" {
+			_, err := tb.Discord.c.ChannelMessage(m.channelID, m.messageID)
+			if err == nil {
+				continue
+			}
+			if !isDiscordNotFound(err) {
+				log.Warnf("[TWITCH] failed checking embed in channel: %s, streamer: %s: %v", m.channelID, streamer.Name, err)
+				continue
+			}
+			log.Infof("[TWITCH] embed in channel: %s was deleted, sending a new one, streamer: %s", m.channelID, streamer.Name)
+		}
+		m.messageID = ""
+		missing = append(missing, m)
+	}
+	tb.postLiveMessages(source, streamer, missing, "reattach")
+}
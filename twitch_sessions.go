@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/nicklaw5/helix"
+	log "github.com/sirupsen/logrus"
+)
+
+// startStreamSession opens the history entry of a stream that just started
+func (tb *TenseiBot) startStreamSession(streamer *TwitchStreamer, stream *helix.Stream) {
+	streamer.Session = &TwitchStreamSession{
+		TwitchStreamerID: streamer.ID,
+		StreamID:         stream.ID,
+		StartTime:        streamer.StreamStartTime,
+	}
+	tb.AddStreamSession(streamer.Session)
+	tb.recordStreamSample(streamer, stream)
+}
+
+// recordStreamSample adds the title, category and viewer count of the poll to the session,
+// returns the timeline entry when the title or category changed since the last poll
+func (tb *TenseiBot) recordStreamSample(streamer *TwitchStreamer, stream *helix.Stream) *TwitchSessionChange {
+	// the bot restarted while the stream was running
+	if streamer.Session == nil {
+		session, err := tb.GetOpenStreamSession(streamer)
+		if err != nil {
+			log.Debug(err)
+			tb.startStreamSession(streamer, stream)
+			return nil
+		}
+		streamer.Session = session
+	}
+
+	session := streamer.Session
+	change := tb.recordSessionChange(session, stream)
+	session.Titles = appendDistinct(session.Titles, stream.Title)
+	if stream.GameID != "" {
+		// unknown categories stay out of the list instead of showing up as ???
+		if game, err := tb.Twitch.getGameByID(stream.GameID); err == nil {
+			session.Categories = appendDistinct(session.Categories, game.Name)
+		}
+	}
+	if stream.ViewerCount > session.PeakViewers {
+		session.PeakViewers = stream.ViewerCount
+	}
+	session.ViewerTotal += stream.ViewerCount
+	session.ViewerSamples++
+	tb.UpdateStreamSession(session)
+	tb.AddViewerSample(&TwitchViewerSample{
+		TwitchStreamSessionID: session.ID,
+		Viewers:               stream.ViewerCount,
+	})
+	return change
+}
+
+// recordSessionChange compares the stream with the last poll and adds a timeline entry
+// on the first poll and when something changed, only changes are returned
+func (tb *TenseiBot) recordSessionChange(session *TwitchStreamSession, stream *helix.Stream) *TwitchSessionChange {
+	// streams can start without a title and category, so the last poll can't tell
+	first := session.Changes == 0
+	change := &TwitchSessionChange{
+		TwitchStreamSessionID: session.ID,
+		Time:                  time.Now().UTC(),
+		Title:                 stream.Title,
+		GameID:                stream.GameID,
+		Category:              tb.Twitch.gameName(stream.GameID),
+		TitleChanged:          !first && stream.Title != session.Title,
+		CategoryChanged:       !first && stream.GameID != session.GameID,
+	}
+	if first {
+		change.Time = session.StartTime
+	} else if !change.TitleChanged && !change.CategoryChanged {
+		return nil
+	}
+
+	session.Title = stream.Title
+	session.GameID = stream.GameID
+	session.Changes++
+	tb.AddSessionChange(change)
+	if first {
+		return nil
+	}
+	log.Infof("[TWITCH_JOB] stream of %s changed to %s: %s", stream.UserName, change.Category, change.Title)
+	return change
+}
+
+// sendChangeNotices posts a short notice about the new title or category to the alert channels
+func (tb *TenseiBot) sendChangeNotices(channelIDs []string, streamer string, change *TwitchSessionChange) {
+	name := escapeDiscord(strings.Title(streamer))
+	var content string
+	switch {
+	case change.CategoryChanged && change.TitleChanged:
+		content = fmt.Sprintf("**%s** is now playing **%s**: %s", name, escapeDiscord(change.Category), escapeDiscord(change.Title))
+	case change.CategoryChanged:
+		content = fmt.Sprintf("**%s** is now playing **%s**", name, escapeDiscord(change.Category))
+	default:
+		content = fmt.Sprintf("**%s** changed the title to: %s", name, escapeDiscord(change.Title))
+	}
+	for _, channelID := range channelIDs {
+		_, err := tb.Discord.c.ChannelMessageSendComplex(channelID, &discordgo.MessageSend{
+			Content:         content,
+			AllowedMentions: &discordgo.MessageAllowedMentions{},
+		})
+		if err != nil {
+			log.Errorf("[TWITCH_JOB] (stream change) failed sending notice to channel: %s, streamer: %s", channelID, streamer)
+		}
+	}
+}
+
+// endStreamSession closes the session of a stream that went offline and returns it,
+// nil if there was no session
+func (tb *TenseiBot) endStreamSession(streamer *TwitchStreamer) *TwitchStreamSession {
+	session := streamer.Session
+	if session == nil {
+		var err error
+		if session, err = tb.GetOpenStreamSession(streamer); err != nil {
+			log.Warn(err)
+			return nil
+		}
+	}
+	session.EndTime = streamer.StreamEndTime
+	tb.UpdateStreamSession(session)
+	session.Timeline = tb.GetSessionChanges(session)
+	streamer.Session = nil
+	return session
+}
+
+// AverageViewers returns the mean of the viewer samples
+func (s *TwitchStreamSession) AverageViewers() int {
+	if s.ViewerSamples == 0 {
+		return 0
+	}
+	return s.ViewerTotal / s.ViewerSamples
+}
+
+// TitleList returns the titles of the session
+func (s *TwitchStreamSession) TitleList() []string {
+	return splitList(s.Titles)
+}
+
+// CategoryList returns the categories of the session
+func (s *TwitchStreamSession) CategoryList() []string {
+	return splitList(s.Categories)
+}
+
+// categoryTimeline returns the timeline entries where the category changed, starting with the first one
+func (s *TwitchStreamSession) categoryTimeline() []*TwitchSessionChange {
+	var timeline []*TwitchSessionChange
+	for i, change := range s.Timeline {
+		if i == 0 || change.CategoryChanged {
+			timeline = append(timeline, change)
+		}
+	}
+	return timeline
+}
+
+// viewerSummary describes the viewers of the session for embeds
+func (s *TwitchStreamSession) viewerSummary() string {
+	return fmt.Sprintf("%d peak, %d average", s.PeakViewers, s.AverageViewers())
+}
+
+// appendDistinct adds value to the newline separated list if it isn't in there yet
+func appendDistinct(list, value string) string {
+	if value == "" || contains(splitList(list), value) {
+		return list
+	}
+	if list == "" {
+		return value
+	}
+	return list + "\n" + value
+}
+
+func splitList(list string) []string {
+	if list == "" {
+		return nil
+	}
+	return strings.Split(list, "\n")
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/nicklaw5/helix"
+	log "github.com/sirupsen/logrus"
+)
+
+// twitchSource polls the tracked streamers with helix
+type twitchSource struct {
+	tb *TenseiBot
+}
+
+func (tb *TenseiBot) twitchSource() *twitchSource {
+	return &twitchSource{tb: tb}
+}
+
+// twitchStream is a helix stream as LiveStream
+type twitchStream struct {
+	*helix.Stream
+	game string
+}
+
+// liveStream converts the polled stream, nil when it isn't live
+func (tt *TenseiTwitch) liveStream(stream *helix.Stream) LiveStream {
+	if !isStreaming(stream) {
+		return nil
+	}
+	return twitchStream{Stream: stream, game: tt.gameName(stream.GameID)}
+}
+
+// StreamID ...
+func (s twitchStream) StreamID() string {
+	return s.ID
+}
+
+// StartTime ...
+func (s twitchStream) StartTime() time.Time {
+	return s.StartedAt
+}
+
+// Placeholders {streamer}, {title}, {game}, {url} and {viewers}
+func (s twitchStream) Placeholders() map[string]string {
+	return map[string]string{
+		"streamer": s.UserLogin,
+		"title":    s.Title,
+		"game":     s.game,
+		"url":      fmt.Sprintf("https://twitch.tv/%s", s.UserLogin),
+		"viewers":  fmt.Sprintf("%d", s.ViewerCount),
+	}
+}
+
+// DisplayName ...
+func (s *TwitchStreamer) DisplayName() string {
+	return s.Name
+}
+
+// State ...
+func (s *TwitchStreamer) State() *LiveState {
+	return &s.LiveState
+}
+
+// Alerts ...
+func (s *TwitchStreamer) Alerts() []LiveAlert {
+	alerts := make([]LiveAlert, 0, len(s.TwitchAlertSubscriptions))
+	for _, alert := range s.TwitchAlertSubscriptions {
+		alerts = append(alerts, alert)
+	}
+	return alerts
+}
+
+// Settings ...
+func (alert *TwitchAlertSubscription) Settings() *AlertSubscription {
+	return &alert.AlertSubscription
+}
+
+// Name ...
+func (ts *twitchSource) Name() string {
+	return "twitch"
+}
+
+// Poll checks all streamers with one request per 100 streamers, the requests
+// are made without the lock so commands don't wait for the helix rate limit
+func (ts *twitchSource) Poll(job func(channel LiveChannel, stream LiveStream)) {
+	tt := ts.tb.Twitch
+	tt.TwitchStreamerMutex.RLock()
+	streamers := make([]*TwitchStreamer, len(tt.TwitchStreamers))
+	copy(streamers, tt.TwitchStreamers)
+	tt.TwitchStreamerMutex.RUnlock()
+
+	for len(streamers) > 0 {
+		n := helixMaxIDs
+		if n > len(streamers) {
+			n = len(streamers)
+		}
+		ts.pollStreamers(streamers[:n], job)
+		streamers = streamers[n:]
+	}
+}
+
+func (ts *twitchSource) pollStreamers(streamers []*TwitchStreamer, job func(channel LiveChannel, stream LiveStream)) {
+	tt := ts.tb.Twitch
+	ids := make([]string, 0, len(streamers))
+	for _, streamer := range streamers {
+		ids = append(ids, streamer.ChannelID)
+	}
+	// don't touch the streamers when the request failed, they would look offline
+	streams, err := tt.getStreamsByUserID(ids)
+	if err != nil {
+		log.Warn(err)
+		return
+	}
+
+	var gameIDs, started []string
+	tt.TwitchStreamerMutex.RLock()
+	for _, streamer := range streamers {
+		stream := streams[streamer.ChannelID]
+		if !isStreaming(stream) {
+			continue
+		}
+		gameIDs = append(gameIDs, stream.GameID)
+		if !streamer.IsLive() {
+			started = append(started, streamer.ChannelID)
+		}
+	}
+	tt.TwitchStreamerMutex.RUnlock()
+	if err := tt.cacheGames(gameIDs); err != nil {
+		log.Warn(err)
+	}
+	tt.fetchProfileImages(started)
+
+	var wg sync.WaitGroup
+	wg.Add(len(streamers))
+	for _, streamer := range streamers {
+		go func(streamer *TwitchStreamer, stream LiveStream) {
+			defer wg.Done()
+			job(streamer, stream)
+		}(streamer, tt.liveStream(streams[streamer.ChannelID]))
+	}
+	wg.Wait()
+}
+
+// LiveEmbed ...
+func (ts *twitchSource) LiveEmbed(channel LiveChannel, stream LiveStream, tf timeFormat) *discordgo.MessageEmbed {
+	return ts.tb.Twitch.createLiveEmbed(stream.(twitchStream).Stream, channel.(*TwitchStreamer), tf)
+}
+
+// EndEmbed ...
+func (ts *twitchSource) EndEmbed(channel LiveChannel, tf timeFormat) *discordgo.MessageEmbed {
+	streamer := channel.(*TwitchStreamer)
+	if streamer.lastStream == nil {
+		return createEndEmbed(streamer, nil, nil, tf)
+	}
+	return createEndEmbed(streamer, streamer.lastStream.session, streamer.lastStream.media, tf)
+}
+
+// StreamStarted opens the stream session
+func (ts *twitchSource) StreamStarted(channel LiveChannel, stream LiveStream) {
+	streamer := channel.(*TwitchStreamer)
+	ts.tb.Twitch.setProfileImage(streamer)
+	ts.tb.startStreamSession(streamer, stream.(twitchStream).Stream)
+}
+
+// StreamUpdated records the poll and sends the change notices
+func (ts *twitchSource) StreamUpdated(channel LiveChannel, stream LiveStream) {
+	streamer := channel.(*TwitchStreamer)
+	change := ts.tb.recordStreamSample(streamer, stream.(twitchStream).Stream)
+	if change == nil {
+		return
+	}
+	var channelIDs []string
+	for _, alert := range streamer.TwitchAlertSubscriptions {
+		if alert.NotifyChanges {
+			channelIDs = append(channelIDs, alert.ChannelID)
+		}
+	}
+	// the lock is held, the notices are sent without it
+	if len(channelIDs) > 0 {
+		go ts.tb.sendChangeNotices(channelIDs, streamer.Name, change)
+	}
+}
+
+// StreamEnded closes the stream session and looks up the VOD and clips for the end embeds
+func (ts *twitchSource) StreamEnded(channel LiveChannel) {
+	streamer := channel.(*TwitchStreamer)
+	tt := ts.tb.Twitch
+	tt.TwitchStreamerMutex.Lock()
+	session := ts.tb.endStreamSession(streamer)
+	ended := *streamer
+	tt.TwitchStreamerMutex.Unlock()
+
+	media := tt.getStreamMedia(&ended, session)
+
+	tt.TwitchStreamerMutex.Lock()
+	defer tt.TwitchStreamerMutex.Unlock()
+	streamer.lastStream = &endedStream{session: session, media: media}
+}
+
+// AlertsEnded edits the end embeds again once the VOD is available
+func (ts *twitchSource) AlertsEnded(channel LiveChannel, alerts []LiveAlert) {
+	streamer := channel.(*TwitchStreamer)
+	ended := streamer.lastStream
+	if ended == nil || ended.media.vod != nil || len(alerts) < 1 {
+		return
+	}
+	var messages []endedMessage
+	for _, alert := range alerts {
+		settings := alert.Settings()
+		messages = append(messages, endedMessage{alert: settings, messageID: settings.MessageID})
+	}
+	go ts.tb.retryStreamVideo(*streamer, ended.session, ended.media, messages)
+}
+
+// SaveChannel ...
+func (ts *twitchSource) SaveChannel(channel LiveChannel) {
+	ts.tb.UpdateStreamer(channel.(*TwitchStreamer))
+}
+
+// SaveAlert ...
+func (ts *twitchSource) SaveAlert(alert LiveAlert) {
+	ts.tb.UpdateAlertSubscription(alert.(*TwitchAlertSubscription))
+}
+
+// Lock ...
+func (ts *twitchSource) Lock() {
+	ts.tb.Twitch.TwitchStreamerMutex.Lock()
+}
+
+// Unlock ...
+func (ts *twitchSource) Unlock() {
+	ts.tb.Twitch.TwitchStreamerMutex.Unlock()
+}
+
+// Tracked ...
+func (ts *twitchSource) Tracked(channel LiveChannel) bool {
+	for _, streamer := range ts.tb.Twitch.TwitchStreamers {
+		if streamer == channel {
+			return true
+		}
+	}
+	return false
+}
+
+func (tt *TenseiTwitch) createLiveEmbed(stream *helix.Stream, streamer *TwitchStreamer, tf timeFormat) *discordgo.MessageEmbed {
+	channelURL := fmt.Sprintf("https://twitch.tv/%s", streamer.Name)
+	thumbnailURL := fmt.Sprintf("https://static-cdn.jtvnw.net/previews-ttv/live_user_%s-1920x1080.jpg?t=%d", streamer.Name, time.Now().Unix())
+	liveFor := time.Now().UTC().Sub(streamer.StreamStartTime)
+
+	return &discordgo.MessageEmbed{
+		Author: &discordgo.MessageEmbedAuthor{
+			Name: fmt.Sprintf("%s", strings.Title(streamer.Name)),
+			URL:  channelURL,
+		},
+		Title: stream.Title,
+		URL:   channelURL,
+		Image: &discordgo.MessageEmbedImage{
+			URL: thumbnailURL,
+		},
+		Fields: []*discordgo.MessageEmbedField{
+			{
+				Name:   "Category",
+				Value:  tt.gameName(stream.GameID),
+				Inline: true,
+			},
+			{
+				Name:   "Viewers",
+				Value:  fmt.Sprintf("%d", stream.ViewerCount),
+				Inline: true,
+			},
+			{
+				Name:   "Started",
+				Value:  tf.format(streamer.StreamStartTime),
+				Inline: true,
+			},
+		},
+		Thumbnail: &discordgo.MessageEmbedThumbnail{
+			URL: streamer.ProfileImageURL,
+		},
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: fmt.Sprintf("Live for %s", humanizeDuration(liveFor)),
+		},
+		Color: 0xFF0000,
+	}
+}
+
+// createEndEmbed session is nil when the stream wasn't recorded, media when VOD and clips weren't looked up
+func createEndEmbed(streamer *TwitchStreamer, session *TwitchStreamSession, media *streamMedia, tf timeFormat) *discordgo.MessageEmbed {
+	channelURL := fmt.Sprintf("https://twitch.tv/%s", streamer.Name)
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("**Started at:** %s\n", tf.format(streamer.StreamStartTime)))
+	sb.WriteString(fmt.Sprintf("__**Ended at:** %s__\n", tf.format(streamer.StreamEndTime)))
+	sb.WriteString(fmt.Sprintf("**Total Time:** %s", humanizeDuration(streamer.StreamLength())))
+	if session != nil && session.ViewerSamples > 0 {
+		sb.WriteString(fmt.Sprintf("\n**Peak Viewers:** %d", session.PeakViewers))
+		sb.WriteString(fmt.Sprintf("\n**Average Viewers:** %d", session.AverageViewers()))
+	}
+	if session != nil {
+		timeline := session.categoryTimeline()
+		switch len(timeline) {
+		case 0:
+		case 1:
+			sb.WriteString(fmt.Sprintf("\n**Category:** %s", timeline[0].Category))
+		default:
+			sb.WriteString("\n**Categories:**")
+			for _, change := range timeline {
+				sb.WriteString(fmt.Sprintf("\n%s %s", tf.format(change.Time), change.Category))
+			}
+		}
+	}
+	if media != nil {
+		media.write(&sb)
+	}
+
+	return &discordgo.MessageEmbed{
+		Author: &discordgo.MessageEmbedAuthor{
+			Name: fmt.Sprintf("%s was Live", strings.Title(streamer.Name)),
+			URL:  channelURL,
+		},
+		Thumbnail: &discordgo.MessageEmbedThumbnail{
+			URL: streamer.ProfileImageURL,
+		},
+		URL:         channelURL,
+		Description: sb.String(),
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/bwmarrin/discordgo"
 	log "github.com/sirupsen/logrus"
+	"net/http"
 	"strings"
 	"time"
 )
@@ -26,6 +27,46 @@ func hasAlertSubscription(streamer *TwitchStreamer, channelID string) bool {
 	return false
 }
 
+// channelName returns the name of the channel or the id if it can't be found
+func channelName(s *discordgo.Session, channelID string) string {
+	channel, err := s.State.Channel(channelID)
+	if err != nil {
+		return channelID
+	}
+	return channel.Name
+}
+
+// guildChannel returns an error unless the channel is a text channel of the guild
+func guildChannel(s *discordgo.Session, guildID, channelID string) error {
+	channel, err := s.State.Channel(channelID)
+	if err != nil {
+		channel, err = s.Channel(channelID)
+	}
+	if err != nil || channel.GuildID != guildID {
+		return fmt.Errorf("<#%s> isn't a channel of this server", channelID)
+	}
+	if channel.Type != discordgo.ChannelTypeGuildText && channel.Type != discordgo.ChannelTypeGuildNews {
+		return fmt.Errorf("<#%s> isn't a text channel", channelID)
+	}
+	return nil
+}
+
+var discordMarkdownReplacer = strings.NewReplacer(
+	"\\", "\\\\", "*", "\\*", "_", "\\_", "~", "\\~", "`", "\\`",
+	"|", "\\|", ">", "\\>", "[", "\\[", "]", "\\]",
+)
+
+// escapeDiscord escapes discord markdown so user text like stream titles shows up as written
+func escapeDiscord(s string) string {
+	return discordMarkdownReplacer.Replace(s)
+}
+
+// isDiscordNotFound returns true when discord answered 404 because the message or channel is gone
+func isDiscordNotFound(err error) bool {
+	restErr, ok := err.(*discordgo.RESTError)
+	return ok && restErr.Response != nil && restErr.Response.StatusCode == http.StatusNotFound
+}
+
 func humanizeDuration(duration time.Duration) string {
 	var sb strings.Builder
 
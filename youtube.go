@@ -0,0 +1,417 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	youtubeAPIURL = "https://www.googleapis.com/youtube/v3"
+	// youtubeMaxIDs is the most ids the data api accepts in one request
+	youtubeMaxIDs = 50
+	// youtubeRecentUploads uploads per channel checked for new videos and live streams
+	youtubeRecentUploads = 5
+	// polling costs quota, it only has to catch what websub missed when websub is enabled
+	youtubePollInterval       = 5 * time.Minute
+	youtubeWebSubPollInterval = 30 * time.Minute
+)
+
+var (
+	youtubeChannelIDRe = regexp.MustCompile(`(?:^|/channel/)(UC[\w-]{22})`)
+	youtubeHandleRe    = regexp.MustCompile(`(?:^|youtube\.com/)(@[\w.-]+)`)
+)
+
+// TenseiYouTube youtube part of the bot
+type TenseiYouTube struct {
+	apiURL     string
+	apiKey     string
+	httpClient *http.Client
+
+	Channels      []*YouTubeChannel
+	ChannelsMutex sync.Mutex
+}
+
+type youtubeThumbnails struct {
+	Default *youtubeThumbnail `json:"default"`
+	High    *youtubeThumbnail `json:"high"`
+	Maxres  *youtubeThumbnail `json:"maxres"`
+}
+
+type youtubeThumbnail struct {
+	URL string `json:"url"`
+}
+
+// best returns the largest thumbnail
+func (t youtubeThumbnails) best() string {
+	for _, thumbnail := range []*youtubeThumbnail{t.Maxres, t.High, t.Default} {
+		if thumbnail != nil {
+			return thumbnail.URL
+		}
+	}
+	return ""
+}
+
+type youtubeChannelResource struct {
+	ID      string `json:"id"`
+	Snippet struct {
+		Title      string            `json:"title"`
+		Thumbnails youtubeThumbnails `json:"thumbnails"`
+	} `json:"snippet"`
+}
+
+type youtubePlaylistItem struct {
+	ContentDetails struct {
+		VideoID string `json:"videoId"`
+	} `json:"contentDetails"`
+}
+
+type youtubeVideo struct {
+	ID      string `json:"id"`
+	Snippet struct {
+		ChannelID   string            `json:"channelId"`
+		Title       string            `json:"title"`
+		PublishedAt time.Time         `json:"publishedAt"`
+		Thumbnails  youtubeThumbnails `json:"thumbnails"`
+		// LiveBroadcastContent is live, upcoming or none
+		LiveBroadcastContent string `json:"liveBroadcastContent"`
+	} `json:"snippet"`
+	LiveStreamingDetails *struct {
+		ScheduledStartTime time.Time `json:"scheduledStartTime"`
+		ActualStartTime    time.Time `json:"actualStartTime"`
+		ActualEndTime      time.Time `json:"actualEndTime"`
+		ConcurrentViewers  string    `json:"concurrentViewers"`
+	} `json:"liveStreamingDetails"`
+}
+
+type youtubeError struct {
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// NewYouTube creates the youtube client
+func (tb *TenseiBot) NewYouTube() {
+	tb.YouTube.apiURL = strings.TrimSuffix(tb.Config.YouTube.APIURL, "/")
+	if tb.YouTube.apiURL == "" {
+		tb.YouTube.apiURL = youtubeAPIURL
+	}
+	tb.YouTube.apiKey = tb.Config.Google.APIKey
+	tb.YouTube.httpClient = &http.Client{Timeout: 10 * time.Second}
+	tb.YouTube.Channels = tb.GetYouTubeChannels()
+
+	interval := youtubePollInterval
+	if tb.Config.YouTube.WebSub.Listen != "" {
+		interval = youtubeWebSubPollInterval
+		go tb.startWebSub()
+	}
+	go tb.startYouTubeJobs(interval)
+
+	log.Info("[MODULE] youtube loaded")
+}
+
+func (tb *TenseiBot) startYouTubeJobs(interval time.Duration) {
+	log.Infof("[YOUTUBE] starting %d YOUTUBE_JOBS", len(tb.YouTube.Channels))
+	source := tb.youtubeSource()
+	tb.startLiveJobs(source)
+	ticker := time.NewTicker(interval)
+	for range ticker.C {
+		tb.startLiveJobs(source)
+	}
+}
+
+// get calls the data api and decodes the items of the response
+func (ty *TenseiYouTube) get(resource string, params url.Values, items interface{}) error {
+	params.Set("key", ty.apiKey)
+	resp, err := ty.httpClient.Get(fmt.Sprintf("%s/%s?%s", ty.apiURL, resource, params.Encode()))
+	if err != nil {
+		return fmt.Errorf("[YOUTUBE] failed getting %s: %v", resource, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr youtubeError
+		_ = json.NewDecoder(resp.Body).Decode(&apiErr)
+		return fmt.Errorf("[YOUTUBE] failed getting %s: status %d: %s", resource, resp.StatusCode, apiErr.Error.Message)
+	}
+	body := struct {
+		Items interface{} `json:"items"`
+	}{Items: items}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return fmt.Errorf("[YOUTUBE] failed decoding %s: %v", resource, err)
+	}
+	return nil
+}
+
+// getChannel returns the channel by id or @handle
+func (ty *TenseiYouTube) getChannel(id, handle string) (*youtubeChannelResource, error) {
+	params := url.Values{"part": {"snippet"}}
+	if id != "" {
+		params.Set("id", id)
+	} else {
+		params.Set("forHandle", handle)
+	}
+	var channels []youtubeChannelResource
+	if err := ty.get("channels", params, &channels); err != nil {
+		return nil, err
+	}
+	if len(channels) < 1 {
+		return nil, fmt.Errorf("[YOUTUBE] no channel %s%s found", id, handle)
+	}
+	return &channels[0], nil
+}
+
+// getRecentUploads returns the ids of the newest videos of the channel
+func (ty *TenseiYouTube) getRecentUploads(channelID string) ([]string, error) {
+	// the uploads playlist of a channel is its id with UU instead of UC
+	params := url.Values{
+		"part":       {"contentDetails"},
+		"playlistId": {"UU" + strings.TrimPrefix(channelID, "UC")},
+		"maxResults": {fmt.Sprintf("%d", youtubeRecentUploads)},
+	}
+	var items []youtubePlaylistItem
+	if err := ty.get("playlistItems", params, &items); err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(items))
+	for _, item := range items {
+		ids = append(ids, item.ContentDetails.VideoID)
+	}
+	return ids, nil
+}
+
+// getVideos returns the videos by id, 50 per request
+func (ty *TenseiYouTube) getVideos(ids []string) (map[string]*youtubeVideo, error) {
+	videos := make(map[string]*youtubeVideo)
+	for len(ids) > 0 {
+		n := youtubeMaxIDs
+		if n > len(ids) {
+			n = len(ids)
+		}
+		params := url.Values{
+			"part": {"snippet,liveStreamingDetails"},
+			"id":   {strings.Join(ids[:n], ",")},
+		}
+		var items []*youtubeVideo
+		if err := ty.get("videos", params, &items); err != nil {
+			return nil, err
+		}
+		for _, video := range items {
+			videos[video.ID] = video
+		}
+		ids = ids[n:]
+	}
+	return videos, nil
+}
+
+// parseYouTubeChannel returns the channel id or @handle of a channel url, id or handle
+func parseYouTubeChannel(value string) (string, string) {
+	if match := youtubeChannelIDRe.FindStringSubmatch(value); match != nil {
+		return match[1], ""
+	}
+	if match := youtubeHandleRe.FindStringSubmatch(value); match != nil {
+		return "", match[1]
+	}
+	return "", "@" + value
+}
+
+// trackedChannel returns the channel with the id or title, the caller has to hold ChannelsMutex
+func (ty *TenseiYouTube) trackedChannel(value string) *YouTubeChannel {
+	id, _ := parseYouTubeChannel(value)
+	for _, channel := range ty.Channels {
+		if channel.ChannelID == id || strings.EqualFold(channel.Title, value) {
+			return channel
+		}
+	}
+	return nil
+}
+
+func (ty *TenseiYouTube) untrackChannel(channel *YouTubeChannel) {
+	for i, c := range ty.Channels {
+		if c == channel {
+			ty.Channels = append(ty.Channels[:i], ty.Channels[i+1:]...)
+			return
+		}
+	}
+}
+
+func (tb *TenseiBot) completeYouTubeChannels(value string) []string {
+	tb.YouTube.ChannelsMutex.Lock()
+	defer tb.YouTube.ChannelsMutex.Unlock()
+
+	var titles []string
+	for _, channel := range tb.YouTube.Channels {
+		if strings.HasPrefix(strings.ToLower(channel.Title), strings.ToLower(value)) {
+			titles = append(titles, channel.Title)
+		}
+	}
+	return titles
+}
+
+func discordYouTubeAdd(tb *TenseiBot) commandFunc {
+	return func(ctx *commandContext) {
+		value := ctx.str("channel")
+		channelID := ctx.str("discordchannel")
+		if err := guildChannel(ctx.s, ctx.guildID, channelID); err != nil {
+			ctx.error("%v", err)
+			return
+		}
+
+		tb.YouTube.ChannelsMutex.Lock()
+		defer tb.YouTube.ChannelsMutex.Unlock()
+
+		channel := tb.YouTube.trackedChannel(value)
+		if channel == nil {
+			resource, err := tb.YouTube.getChannel(parseYouTubeChannel(value))
+			if err != nil {
+				log.Warn(err)
+				ctx.error("couldn't find youtube channel %s", value)
+				return
+			}
+			// the handle or url of a channel that is already tracked
+			channel = tb.YouTube.trackedChannel(resource.ID)
+			if channel == nil {
+				channel = &YouTubeChannel{
+					ChannelID:    resource.ID,
+					Title:        resource.Snippet.Title,
+					ThumbnailURL: resource.Snippet.Thumbnails.best(),
+					// only announce videos uploaded from now on
+					LastUploadTime: time.Now().UTC(),
+				}
+				tb.AddYouTubeChannel(channel)
+				tb.YouTube.Channels = append(tb.YouTube.Channels, channel)
+				go tb.subscribeWebSub(channel.ChannelID, true)
+				log.Infof("[YOUTUBE] started tracking channel %s", channel.Title)
+			}
+		}
+
+		for _, alert := range channel.YouTubeAlertSubscriptions {
+			if alert.ChannelID == channelID {
+				ctx.notice("%s alerts are already posted in <#%s>", channel.Title, channelID)
+				return
+			}
+		}
+		channel.YouTubeAlertSubscriptions = append(channel.YouTubeAlertSubscriptions, &YouTubeAlertSubscription{
+			AlertSubscription: AlertSubscription{
+				ChannelID: channelID,
+				GuildID:   ctx.guildID,
+			},
+		})
+		tb.UpdateYouTubeChannel(channel)
+		ctx.success("added %s live and upload alerts to <#%s>", channel.Title, channelID)
+	}
+}
+
+func discordYouTubeRemove(tb *TenseiBot) commandFunc {
+	return func(ctx *commandContext) {
+		value := ctx.str("channel")
+		channelID := ctx.str("discordchannel")
+
+		tb.YouTube.ChannelsMutex.Lock()
+		channel := tb.YouTube.trackedChannel(value)
+		tb.YouTube.ChannelsMutex.Unlock()
+		if channel == nil {
+			// handles are only known to the api
+			if resource, err := tb.YouTube.getChannel(parseYouTubeChannel(value)); err == nil {
+				value = resource.ID
+			}
+		}
+
+		tb.YouTube.ChannelsMutex.Lock()
+		channel = tb.YouTube.trackedChannel(value)
+		if channel == nil {
+			tb.YouTube.ChannelsMutex.Unlock()
+			ctx.error("%s isn't tracked", value)
+			return
+		}
+
+		var kept []*YouTubeAlertSubscription
+		var removed []LiveAlert
+		var mentions []string
+		for _, alert := range channel.YouTubeAlertSubscriptions {
+			if alert.GuildID != ctx.guildID || (channelID != "" && alert.ChannelID != channelID) {
+				kept = append(kept, alert)
+				continue
+			}
+			tb.RemoveYouTubeAlertSubscription(alert)
+			removed = append(removed, alert)
+			mentions = append(mentions, fmt.Sprintf("<#%s>", alert.ChannelID))
+		}
+		if len(removed) < 1 {
+			tb.YouTube.ChannelsMutex.Unlock()
+			ctx.error("there are no %s alerts to remove", channel.Title)
+			return
+		}
+		channel.YouTubeAlertSubscriptions = kept
+
+		// stop tracking channels nobody is subscribed to, a running job ends the embeds itself
+		untrack := len(kept) < 1
+		ending := untrack && channel.IsLive() && !channel.job
+		if untrack {
+			tb.YouTube.untrackChannel(channel)
+		}
+		if ending {
+			channel.job = true
+			channel.StreamEndTime = time.Now().UTC()
+		}
+		tb.YouTube.ChannelsMutex.Unlock()
+
+		if ending {
+			tb.endLiveAlerts(tb.youtubeSource(), channel, removed)
+		}
+		if untrack {
+			tb.RemoveYouTubeChannel(channel)
+			go tb.subscribeWebSub(channel.ChannelID, false)
+			log.Infof("[YOUTUBE] stopped tracking channel %s", channel.Title)
+		}
+		ctx.success("removed %s alert from %s", channel.Title, strings.Join(mentions, ", "))
+	}
+}
+
+func discordYouTubeList(tb *TenseiBot) commandFunc {
+	return func(ctx *commandContext) {
+		var fields []*discordgo.MessageEmbedField
+		tf := tb.guildTimeFormat(ctx.guildID)
+		tb.YouTube.ChannelsMutex.Lock()
+		for _, channel := range tb.YouTube.Channels {
+			for _, alert := range channel.YouTubeAlertSubscriptions {
+				if alert.GuildID != ctx.guildID {
+					continue
+				}
+				fields = append(fields, &discordgo.MessageEmbedField{
+					Name:  fmt.Sprintf("%s in #%s", channel.Title, channelName(ctx.s, alert.ChannelID)),
+					Value: liveStatus(&channel.LiveState, tf),
+				})
+			}
+		}
+		tb.YouTube.ChannelsMutex.Unlock()
+
+		if len(fields) < 1 {
+			ctx.error("there are no youtube alerts on this server")
+			return
+		}
+
+		var pages []*discordgo.MessageEmbed
+		for len(fields) > 0 {
+			n := twitchListPerPage
+			if n > len(fields) {
+				n = len(fields)
+			}
+			pages = append(pages, &discordgo.MessageEmbed{
+				Title:  "YouTube alerts",
+				Fields: fields[:n],
+			})
+			fields = fields[n:]
+		}
+		ctx.replyPages(pages)
+	}
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+)
+
+// youtubeSource polls the uploads of the tracked channels with the data api
+type youtubeSource struct {
+	tb *TenseiBot
+}
+
+func (tb *TenseiBot) youtubeSource() *youtubeSource {
+	return &youtubeSource{tb: tb}
+}
+
+// youtubeStream is a live video as LiveStream, also used for the placeholders of upload alerts
+type youtubeStream struct {
+	video   *youtubeVideo
+	channel string
+}
+
+// isLive returns true for running live streams and premieres
+func (v *youtubeVideo) isLive() bool {
+	return v.Snippet.LiveBroadcastContent == "live" && v.LiveStreamingDetails != nil &&
+		!v.LiveStreamingDetails.ActualStartTime.IsZero() && v.LiveStreamingDetails.ActualEndTime.IsZero()
+}
+
+// isUpload returns true for regular videos, live streams and their VODs are handled by the live alerts
+func (v *youtubeVideo) isUpload() bool {
+	return v.Snippet.LiveBroadcastContent == "none" && v.LiveStreamingDetails == nil
+}
+
+func (v *youtubeVideo) url() string {
+	return fmt.Sprintf("https://www.youtube.com/watch?v=%s", v.ID)
+}
+
+// StreamID ...
+func (s youtubeStream) StreamID() string {
+	return s.video.ID
+}
+
+// StartTime ...
+func (s youtubeStream) StartTime() time.Time {
+	if s.video.LiveStreamingDetails == nil {
+		return s.video.Snippet.PublishedAt
+	}
+	return s.video.LiveStreamingDetails.ActualStartTime
+}
+
+// Placeholders {channel}, {streamer}, {title}, {url} and {viewers}
+func (s youtubeStream) Placeholders() map[string]string {
+	return map[string]string{
+		"channel":  s.channel,
+		"streamer": s.channel,
+		"title":    s.video.Snippet.Title,
+		"url":      s.video.url(),
+		"viewers":  s.viewers(),
+	}
+}
+
+func (s youtubeStream) viewers() string {
+	if s.video.LiveStreamingDetails == nil || s.video.LiveStreamingDetails.ConcurrentViewers == "" {
+		return "0"
+	}
+	return s.video.LiveStreamingDetails.ConcurrentViewers
+}
+
+// DisplayName ...
+func (c *YouTubeChannel) DisplayName() string {
+	return c.Title
+}
+
+// State ...
+func (c *YouTubeChannel) State() *LiveState {
+	return &c.LiveState
+}
+
+// Alerts ...
+func (c *YouTubeChannel) Alerts() []LiveAlert {
+	alerts := make([]LiveAlert, 0, len(c.YouTubeAlertSubscriptions))
+	for _, alert := range c.YouTubeAlertSubscriptions {
+		alerts = append(alerts, alert)
+	}
+	return alerts
+}
+
+// Settings ...
+func (alert *YouTubeAlertSubscription) Settings() *AlertSubscription {
+	return &alert.AlertSubscription
+}
+
+func (c *YouTubeChannel) url() string {
+	return fmt.Sprintf("https://www.youtube.com/channel/%s", c.ChannelID)
+}
+
+// liveStream returns the running stream among the videos of the channel, nil when it is offline
+func (c *YouTubeChannel) liveStream(videos []*youtubeVideo) LiveStream {
+	for _, video := range videos {
+		if video.Snippet.ChannelID == c.ChannelID && video.isLive() {
+			return youtubeStream{video: video, channel: c.Title}
+		}
+	}
+	return nil
+}
+
+// Name ...
+func (ys *youtubeSource) Name() string {
+	return "youtube"
+}
+
+// Poll checks the recent uploads of every channel, live streams show up in the uploads too,
+// the requests are made without the lock
+func (ys *youtubeSource) Poll(job func(channel LiveChannel, stream LiveStream)) {
+	ty := ys.tb.YouTube
+	ty.ChannelsMutex.Lock()
+	channels := make([]*YouTubeChannel, len(ty.Channels))
+	copy(channels, ty.Channels)
+	liveVideos := make(map[*YouTubeChannel]string)
+	for _, channel := range channels {
+		if channel.IsLive() && channel.LiveVideoID != "" {
+			liveVideos[channel] = channel.LiveVideoID
+		}
+	}
+	ty.ChannelsMutex.Unlock()
+
+	var ids []string
+	uploads := make(map[*YouTubeChannel][]string)
+	for _, channel := range channels {
+		recent, err := ty.getRecentUploads(channel.ChannelID)
+		if err != nil {
+			log.Warn(err)
+			continue
+		}
+		// the live video can drop out of the recent uploads before it ends
+		if id, ok := liveVideos[channel]; ok && !contains(recent, id) {
+			recent = append(recent, id)
+		}
+		uploads[channel] = recent
+		ids = append(ids, recent...)
+	}
+	// don't touch the channels when the request failed, they would look offline
+	videos, err := ty.getVideos(ids)
+	if err != nil {
+		log.Warn(err)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for channel, recent := range uploads {
+		var channelVideos []*youtubeVideo
+		for _, id := range recent {
+			if video, ok := videos[id]; ok {
+				channelVideos = append(channelVideos, video)
+			}
+		}
+
+		wg.Add(1)
+		go func(channel *YouTubeChannel, channelVideos []*youtubeVideo) {
+			defer wg.Done()
+			ty.ChannelsMutex.Lock()
+			if !ys.Tracked(channel) {
+				ty.ChannelsMutex.Unlock()
+				return
+			}
+			if video, ok := videos[channel.LiveVideoID]; ok {
+				channel.liveVideo = video
+			}
+			ys.tb.sendUploadAlerts(channel, channelVideos)
+			ty.ChannelsMutex.Unlock()
+			job(channel, channel.liveStream(channelVideos))
+		}(channel, channelVideos)
+	}
+	wg.Wait()
+}
+
+// LiveEmbed ...
+func (ys *youtubeSource) LiveEmbed(channel LiveChannel, stream LiveStream, tf timeFormat) *discordgo.MessageEmbed {
+	c := channel.(*YouTubeChannel)
+	s := stream.(youtubeStream)
+	liveFor := time.Now().UTC().Sub(c.StreamStartTime)
+
+	return &discordgo.MessageEmbed{
+		Author: &discordgo.MessageEmbedAuthor{
+			Name: c.Title,
+			URL:  c.url(),
+		},
+		Title: s.video.Snippet.Title,
+		URL:   s.video.url(),
+		Image: &discordgo.MessageEmbedImage{
+			URL: s.video.Snippet.Thumbnails.best(),
+		},
+		Fields: []*discordgo.MessageEmbedField{
+			{
+				Name:   "Viewers",
+				Value:  s.viewers(),
+				Inline: true,
+			},
+			{
+				Name:   "Started",
+				Value:  tf.format(c.StreamStartTime),
+				Inline: true,
+			},
+		},
+		Thumbnail: &discordgo.MessageEmbedThumbnail{
+			URL: c.ThumbnailURL,
+		},
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: fmt.Sprintf("Live for %s", humanizeDuration(liveFor)),
+		},
+		Color: 0xFF0000,
+	}
+}
+
+// EndEmbed ...
+func (ys *youtubeSource) EndEmbed(channel LiveChannel, tf timeFormat) *discordgo.MessageEmbed {
+	c := channel.(*YouTubeChannel)
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("**Started at:** %s\n", tf.format(c.StreamStartTime)))
+	sb.WriteString(fmt.Sprintf("__**Ended at:** %s__\n", tf.format(c.StreamEndTime)))
+	sb.WriteString(fmt.Sprintf("**Total Time:** %s", humanizeDuration(c.StreamLength())))
+	if c.liveVideo != nil {
+		sb.WriteString(fmt.Sprintf("\n**VOD:** [%s](%s)", escapeDiscord(c.liveVideo.Snippet.Title), c.liveVideo.url()))
+	}
+
+	return &discordgo.MessageEmbed{
+		Author: &discordgo.MessageEmbedAuthor{
+			Name: fmt.Sprintf("%s was Live", c.Title),
+			URL:  c.url(),
+		},
+		Thumbnail: &discordgo.MessageEmbedThumbnail{
+			URL: c.ThumbnailURL,
+		},
+		URL:         c.url(),
+		Description: sb.String(),
+	}
+}
+
+// StreamStarted remembers the live video to check it until it ends
+func (ys *youtubeSource) StreamStarted(channel LiveChannel, stream LiveStream) {
+	c := channel.(*YouTubeChannel)
+	c.LiveVideoID = stream.StreamID()
+	c.liveVideo = stream.(youtubeStream).video
+}
+
+// StreamUpdated ...
+func (ys *youtubeSource) StreamUpdated(channel LiveChannel, stream LiveStream) {}
+
+// StreamEnded uses the end time youtube reports instead of the poll time
+func (ys *youtubeSource) StreamEnded(channel LiveChannel) {
+	c := channel.(*YouTubeChannel)
+	ys.tb.YouTube.ChannelsMutex.Lock()
+	defer ys.tb.YouTube.ChannelsMutex.Unlock()
+	if c.liveVideo == nil || c.liveVideo.ID != c.LiveVideoID || c.liveVideo.LiveStreamingDetails == nil {
+		return
+	}
+	if end := c.liveVideo.LiveStreamingDetails.ActualEndTime; !end.IsZero() {
+		c.StreamEndTime = end.UTC()
+	}
+}
+
+// AlertsEnded ...
+func (ys *youtubeSource) AlertsEnded(channel LiveChannel, alerts []LiveAlert) {}
+
+// SaveChannel ...
+func (ys *youtubeSource) SaveChannel(channel LiveChannel) {
+	ys.tb.UpdateYouTubeChannel(channel.(*YouTubeChannel))
+}
+
+// SaveAlert ...
+func (ys *youtubeSource) SaveAlert(alert LiveAlert) {
+	ys.tb.UpdateYouTubeAlertSubscription(alert.(*YouTubeAlertSubscription))
+}
+
+// Lock ...
+func (ys *youtubeSource) Lock() {
+	ys.tb.YouTube.ChannelsMutex.Lock()
+}
+
+// Unlock ...
+func (ys *youtubeSource) Unlock() {
+	ys.tb.YouTube.ChannelsMutex.Unlock()
+}
+
+// Tracked ...
+func (ys *youtubeSource) Tracked(channel LiveChannel) bool {
+	for _, c := range ys.tb.YouTube.Channels {
+		if c == channel {
+			return true
+		}
+	}
+	return false
+}
+
+// sendUploadAlerts posts the videos uploaded since the last announced one,
+// the caller has to hold ChannelsMutex
+func (tb *TenseiBot) sendUploadAlerts(channel *YouTubeChannel, videos []*youtubeVideo) {
+	newest := channel.LastUploadTime
+	for _, video := range videos {
+		if video.Snippet.ChannelID != channel.ChannelID || !video.isUpload() || !video.Snippet.PublishedAt.After(channel.LastUploadTime) {
+			continue
+		}
+		log.Infof("[YOUTUBE_JOB] %s uploaded %s", channel.Title, video.ID)
+		stream := youtubeStream{video: video, channel: channel.Title}
+		for _, alert := range channel.YouTubeAlertSubscriptions {
+			embed := createUploadEmbed(channel, video, tb.alertTimeFormat(&alert.AlertSubscription))
+			if _, err := tb.sendLiveAlert(&alert.AlertSubscription, stream, embed); err != nil {
+				log.Errorf("[YOUTUBE_JOB] failed sending upload embed to channel: %s, channel: %s", alert.ChannelID, channel.Title)
+			}
+		}
+		if video.Snippet.PublishedAt.After(newest) {
+			newest = video.Snippet.PublishedAt
+		}
+	}
+	if newest.After(channel.LastUploadTime) {
+		channel.LastUploadTime = newest.UTC()
+		tb.UpdateYouTubeChannel(channel)
+	}
+}
+
+func createUploadEmbed(channel *YouTubeChannel, video *youtubeVideo, tf timeFormat) *discordgo.MessageEmbed {
+	return &discordgo.MessageEmbed{
+		Author: &discordgo.MessageEmbedAuthor{
+			Name: fmt.Sprintf("%s uploaded a video", channel.Title),
+			URL:  channel.url(),
+		},
+		Title: video.Snippet.Title,
+		URL:   video.url(),
+		Image: &discordgo.MessageEmbedImage{
+			URL: video.Snippet.Thumbnails.best(),
+		},
+		Fields: []*discordgo.MessageEmbedField{
+			{
+				Name:  "Published",
+				Value: tf.format(video.Snippet.PublishedAt),
+			},
+		},
+		Thumbnail: &discordgo.MessageEmbedThumbnail{
+			URL: channel.ThumbnailURL,
+		},
+		Color: 0xFF0000,
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	webSubHubURL   = "https://pubsubhubbub.appspot.com/subscribe"
+	webSubTopicURL = "https://www.youtube.com/xml/feeds/videos.xml?channel_id="
+	// the hub drops subscriptions after the lease, they are renewed well before
+	webSubLease         = 5 * 24 * time.Hour
+	webSubRenewInterval = 4 * 24 * time.Hour
+	// webSubLiveRetries the data api sometimes reports a stream as upcoming right after the notification
+	webSubLiveRetries = 4
+	webSubLiveDelay   = 15 * time.Second
+)
+
+// webSubHandler verifies subscriptions and signed feed notifications of the hub
+type webSubHandler struct {
+	secret string
+	// subscribed returns if the bot still wants notifications for the topic
+	subscribed func(topic string) bool
+	notify     func(entry webSubEntry)
+}
+
+type webSubFeed struct {
+	Entries []webSubEntry `xml:"entry"`
+}
+
+// webSubEntry video of a feed notification, yt: elements match by local name
+type webSubEntry struct {
+	VideoID   string `xml:"videoId"`
+	ChannelID string `xml:"channelId"`
+	Title     string `xml:"title"`
+}
+
+// signWebSub returns the signature the hub sends in the X-Hub-Signature header
+func signWebSub(secret string, body []byte) string {
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write(body)
+	return "sha1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (h *webSubHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.verify(w, r)
+	case http.MethodPost:
+		h.receive(w, r)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// verify confirms subscribe and unsubscribe requests by echoing the challenge
+func (h *webSubHandler) verify(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	mode, topic := query.Get("hub.mode"), query.Get("hub.topic")
+	if mode == "subscribe" && !h.subscribed(topic) {
+		log.Warnf("[WEBSUB] refusing subscription to untracked topic %s", topic)
+		http.Error(w, "unknown topic", http.StatusNotFound)
+		return
+	}
+	log.Infof("[WEBSUB] verified %s for %s", mode, topic)
+	w.Header().Set("Content-Type", "text/plain")
+	_, _ = w.Write([]byte(query.Get("hub.challenge")))
+}
+
+func (h *webSubHandler) receive(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	// the hub expects a 2xx for notifications with a wrong signature, they are only ignored
+	signature := signWebSub(h.secret, body)
+	if !hmac.Equal([]byte(signature), []byte(r.Header.Get("X-Hub-Signature"))) {
+		log.Warnf("[WEBSUB] invalid signature from %s", r.RemoteAddr)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var feed webSubFeed
+	if err := xml.Unmarshal(body, &feed); err != nil {
+		log.Warnf("[WEBSUB] failed decoding feed: %v", err)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	// deleted videos come as at:deleted-entry and have no entries
+	for _, entry := range feed.Entries {
+		if entry.VideoID != "" && entry.ChannelID != "" {
+			go h.notify(entry)
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// startWebSub starts the callback server and keeps the subscriptions at the hub alive
+func (tb *TenseiBot) startWebSub() {
+	cfg := tb.Config.YouTube.WebSub
+	callback, err := url.Parse(cfg.Callback)
+	if err != nil || cfg.Secret == "" {
+		log.Errorf("[WEBSUB] missing or invalid callback/secret in config file: %v", err)
+		return
+	}
+	path := callback.Path
+	if path == "" {
+		path = "/"
+	}
+
+	handler := &webSubHandler{
+		secret:     cfg.Secret,
+		subscribed: tb.isWebSubTopic,
+		notify:     tb.handleWebSubNotification,
+	}
+	mux := http.NewServeMux()
+	mux.Handle(path, handler)
+	go func() {
+		log.Infof("[WEBSUB] listening on %s%s", cfg.Listen, path)
+		if err := http.ListenAndServe(cfg.Listen, mux); err != nil {
+			log.Errorf("[WEBSUB] server stopped: %v", err)
+		}
+	}()
+
+	tb.renewWebSubSubscriptions()
+	ticker := time.NewTicker(webSubRenewInterval)
+	for range ticker.C {
+		tb.renewWebSubSubscriptions()
+	}
+}
+
+func (tb *TenseiBot) renewWebSubSubscriptions() {
+	tb.YouTube.ChannelsMutex.Lock()
+	ids := make([]string, 0, len(tb.YouTube.Channels))
+	for _, channel := range tb.YouTube.Channels {
+		ids = append(ids, channel.ChannelID)
+	}
+	tb.YouTube.ChannelsMutex.Unlock()
+
+	for _, id := range ids {
+		tb.subscribeWebSub(id, true)
+	}
+}
+
+// subscribeWebSub subscribes to or unsubscribes from the upload feed of the channel,
+// it does nothing when websub is disabled
+func (tb *TenseiBot) subscribeWebSub(channelID string, subscribe bool) {
+	cfg := tb.Config.YouTube.WebSub
+	if cfg.Listen == "" {
+		return
+	}
+	hubURL := cfg.HubURL
+	if hubURL == "" {
+		hubURL = webSubHubURL
+	}
+	mode := "unsubscribe"
+	if subscribe {
+		mode = "subscribe"
+	}
+
+	form := url.Values{
+		"hub.callback":      {cfg.Callback},
+		"hub.topic":         {webSubTopicURL + channelID},
+		"hub.mode":          {mode},
+		"hub.verify":        {"async"},
+		"hub.secret":        {cfg.Secret},
+		"hub.lease_seconds": {fmt.Sprintf("%d", int(webSubLease.Seconds()))},
+	}
+	resp, err := tb.YouTube.httpClient.PostForm(hubURL, form)
+	if err != nil {
+		log.Errorf("[WEBSUB] failed to %s %s: %v", mode, channelID, err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		log.Errorf("[WEBSUB] failed to %s %s: status %d: %s", mode, channelID, resp.StatusCode, strings.TrimSpace(string(body)))
+		return
+	}
+	log.Debugf("[WEBSUB] requested %s for %s", mode, channelID)
+}
+
+// isWebSubTopic returns true when the topic is the feed of a tracked channel
+func (tb *TenseiBot) isWebSubTopic(topic string) bool {
+	tb.YouTube.ChannelsMutex.Lock()
+	defer tb.YouTube.ChannelsMutex.Unlock()
+	for _, channel := range tb.YouTube.Channels {
+		if topic == webSubTopicURL+channel.ChannelID {
+			return true
+		}
+	}
+	return false
+}
+
+// handleWebSubNotification looks the video up and runs the live job or sends the upload alerts,
+// the feed also notifies about title changes so both only act on new state
+func (tb *TenseiBot) handleWebSubNotification(entry webSubEntry) {
+	log.Infof("[WEBSUB] notification for %s video %s", entry.ChannelID, entry.VideoID)
+	for i := 0; i < webSubLiveRetries; i++ {
+		videos, err := tb.YouTube.getVideos([]string{entry.VideoID})
+		if err != nil {
+			log.Warn(err)
+			return
+		}
+		video, ok := videos[entry.VideoID]
+		if !ok {
+			log.Warnf("[WEBSUB] video %s not found", entry.VideoID)
+			return
+		}
+
+		if video.Snippet.LiveBroadcastContent != "upcoming" || video.LiveStreamingDetails == nil ||
+			video.LiveStreamingDetails.ScheduledStartTime.After(time.Now().Add(webSubLiveDelay*webSubLiveRetries)) {
+			tb.runYouTubeVideo(entry.ChannelID, video)
+			return
+		}
+		// the stream is about to start, polling catches it if it takes longer
+		time.Sleep(webSubLiveDelay)
+	}
+}
+
+// runYouTubeVideo applies the state of a single video to its channel
+func (tb *TenseiBot) runYouTubeVideo(channelID string, video *youtubeVideo) {
+	tb.YouTube.ChannelsMutex.Lock()
+	var channel *YouTubeChannel
+	for _, c := range tb.YouTube.Channels {
+		if c.ChannelID == channelID {
+			channel = c
+		}
+	}
+	if channel == nil {
+		tb.YouTube.ChannelsMutex.Unlock()
+		return
+	}
+
+	switch {
+	case video.isUpload():
+		tb.sendUploadAlerts(channel, []*youtubeVideo{video})
+		tb.YouTube.ChannelsMutex.Unlock()
+	case video.isLive():
+		tb.YouTube.ChannelsMutex.Unlock()
+		tb.runLiveJob(tb.youtubeSource(), channel, channel.liveStream([]*youtubeVideo{video}))
+	case video.ID == channel.LiveVideoID && channel.IsLive():
+		// the running stream ended
+		channel.liveVideo = video
+		tb.YouTube.ChannelsMutex.Unlock()
+		tb.runLiveJob(tb.youtubeSource(), channel, nil)
+	default:
+		tb.YouTube.ChannelsMutex.Unlock()
+	}
+}
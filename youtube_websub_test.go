@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+const (
+	testWebSubSecret  = "s3cr3t-s3cr3t"
+	testWebSubChannel = "UCQ0UDLQCjY0rmuxCDE38FGg"
+)
+
+// testWebSubFeed is a notification like the hub sends for a new upload
+var testWebSubFeed = fmt.Sprintf(`<?xml version='1.0' encoding='UTF-8'?>
+<feed xmlns:yt="http://www.youtube.com/xml/schemas/2015" xmlns="http://www.w3.org/2005/Atom">
+  <link rel="hub" href="https://pubsubhubbub.appspot.com"/>
+  <link rel="self" href="https://www.youtube.com/xml/feeds/videos.xml?channel_id=%[1]s"/>
+  <title>YouTube video feed</title>
+  <updated>2021-03-06T19:05:24.552394234+00:00</updated>
+  <entry>
+    <id>yt:video:dQw4w9WgXcQ</id>
+    <yt:videoId>dQw4w9WgXcQ</yt:videoId>
+    <yt:channelId>%[1]s</yt:channelId>
+    <title>Never Gonna Give You Up</title>
+    <link rel="alternate" href="https://www.youtube.com/watch?v=dQw4w9WgXcQ"/>
+    <author>
+      <name>Tensei</name>
+      <uri>https://www.youtube.com/channel/%[1]s</uri>
+    </author>
+    <published>2021-03-06T19:00:00+00:00</published>
+    <updated>2021-03-06T19:05:24.552394234+00:00</updated>
+  </entry>
+</feed>`, testWebSubChannel)
+
+// webSubFake serves a webSubHandler for a bot tracking a single channel
+type webSubFake struct {
+	server *httptest.Server
+	notify chan webSubEntry
+}
+
+func newWebSubFake(t *testing.T) *webSubFake {
+	tb := &TenseiBot{YouTube: &TenseiYouTube{
+		Channels: []*YouTubeChannel{{ChannelID: testWebSubChannel}},
+	}}
+	fake := &webSubFake{notify: make(chan webSubEntry, 10)}
+	fake.server = httptest.NewServer(&webSubHandler{
+		secret:     testWebSubSecret,
+		subscribed: tb.isWebSubTopic,
+		notify:     func(entry webSubEntry) { fake.notify <- entry },
+	})
+	t.Cleanup(fake.server.Close)
+	return fake
+}
+
+// verify asks the handler to confirm a subscription like the hub does
+func (fake *webSubFake) verify(t *testing.T, mode, topic string) (int, string) {
+	query := url.Values{
+		"hub.mode":          {mode},
+		"hub.topic":         {topic},
+		"hub.challenge":     {"pogchamp-kappa-360noscope"},
+		"hub.lease_seconds": {"432000"},
+	}
+	resp, err := http.Get(fake.server.URL + "?" + query.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, string(data)
+}
+
+// post sends a notification, secret signs it
+func (fake *webSubFake) post(t *testing.T, secret, body string) int {
+	req, err := http.NewRequest(http.MethodPost, fake.server.URL, bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/atom+xml")
+	req.Header.Set("X-Hub-Signature", signWebSub(secret, []byte(body)))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestWebSubVerification(t *testing.T) {
+	fake := newWebSubFake(t)
+	status, body := fake.verify(t, "subscribe", webSubTopicURL+testWebSubChannel)
+	if status != http.StatusOK || body != "pogchamp-kappa-360noscope" {
+		t.Fatalf("got %d %q, want the challenge", status, body)
+	}
+}
+
+func TestWebSubVerificationUntracked(t *testing.T) {
+	fake := newWebSubFake(t)
+	status, body := fake.verify(t, "subscribe", webSubTopicURL+"UCuntracked")
+	if status != http.StatusNotFound {
+		t.Fatalf("got %d %q, want %d", status, body, http.StatusNotFound)
+	}
+	// unsubscribing from channels that were removed is still confirmed
+	status, body = fake.verify(t, "unsubscribe", webSubTopicURL+"UCuntracked")
+	if status != http.StatusOK || body != "pogchamp-kappa-360noscope" {
+		t.Fatalf("got %d %q, want the challenge", status, body)
+	}
+}
+
+func TestWebSubNotification(t *testing.T) {
+	fake := newWebSubFake(t)
+	if status := fake.post(t, testWebSubSecret, testWebSubFeed); status != http.StatusNoContent {
+		t.Fatalf("got %d, want %d", status, http.StatusNoContent)
+	}
+	select {
+	case entry := <-fake.notify:
+		want := webSubEntry{VideoID: "dQw4w9WgXcQ", ChannelID: testWebSubChannel, Title: "Never Gonna Give You Up"}
+		if entry != want {
+			t.Fatalf("got %+v, want %+v", entry, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("notification wasn't handled")
+	}
+}
+
+func TestWebSubBadSignature(t *testing.T) {
+	fake := newWebSubFake(t)
+	// the hub would retry on errors, so the notification is accepted and dropped
+	if status := fake.post(t, "wrong-secret", testWebSubFeed); status != http.StatusNoContent {
+		t.Fatalf("got %d, want %d", status, http.StatusNoContent)
+	}
+	select {
+	case entry := <-fake.notify:
+		t.Fatalf("notification %s with bad signature was handled", entry.VideoID)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestWebSubDeletedEntry(t *testing.T) {
+	fake := newWebSubFake(t)
+	body := `<?xml version='1.0' encoding='UTF-8'?>
+<feed xmlns:at="http://purl.org/atompub/tombstones/1.0" xmlns="http://www.w3.org/2005/Atom">
+  <at:deleted-entry ref="yt:video:dQw4w9WgXcQ" when="2021-03-06T19:05:24.552394234+00:00"/>
+</feed>`
+	if status := fake.post(t, testWebSubSecret, body); status != http.StatusNoContent {
+		t.Fatalf("got %d, want %d", status, http.StatusNoContent)
+	}
+	select {
+	case entry := <-fake.notify:
+		t.Fatalf("deleted video %s was handled", entry.VideoID)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// newWebSubHub returns a hub stand-in that passes the subscription requests to requests
+func newWebSubHub(t *testing.T, status int) (*httptest.Server, chan url.Values) {
+	requests := make(chan url.Values, 10)
+	hub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		requests <- r.PostForm
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(hub.Close)
+	return hub, requests
+}
+
+func webSubBot(hubURL string) *TenseiBot {
+	tb := &TenseiBot{
+		Config:  &TenseiConfig{},
+		YouTube: &TenseiYouTube{httpClient: &http.Client{Timeout: time.Second}},
+	}
+	cfg := &tb.Config.YouTube.WebSub
+	cfg.Listen = "127.0.0.1:0"
+	cfg.Callback = "https://tensei.example.com/websub"
+	cfg.Secret = testWebSubSecret
+	cfg.HubURL = hubURL
+	return tb
+}
+
+func TestSubscribeWebSub(t *testing.T) {
+	hub, requests := newWebSubHub(t, http.StatusAccepted)
+	tb := webSubBot(hub.URL)
+
+	for _, subscribe := range []bool{true, false} {
+		tb.subscribeWebSub(testWebSubChannel, subscribe)
+		select {
+		case form := <-requests:
+			mode := "unsubscribe"
+			if subscribe {
+				mode = "subscribe"
+			}
+			want := map[string]string{
+				"hub.mode":          mode,
+				"hub.topic":         webSubTopicURL + testWebSubChannel,
+				"hub.callback":      "https://tensei.example.com/websub",
+				"hub.secret":        testWebSubSecret,
+				"hub.verify":        "async",
+				"hub.lease_seconds": fmt.Sprint(int(webSubLease.Seconds())),
+			}
+			for key, value := range want {
+				if form.Get(key) != value {
+					t.Fatalf("got %s=%q, want %q", key, form.Get(key), value)
+				}
+			}
+		case <-time.After(time.Second):
+			t.Fatal("hub didn't get a request")
+		}
+	}
+}
+
+func TestSubscribeWebSubDisabled(t *testing.T) {
+	hub, requests := newWebSubHub(t, http.StatusAccepted)
+	tb := webSubBot(hub.URL)
+	tb.Config.YouTube.WebSub.Listen = ""
+
+	tb.subscribeWebSub(testWebSubChannel, true)
+	select {
+	case <-requests:
+		t.Fatal("subscribed with websub disabled")
+	case <-time.After(100 * time.Millisecond):
+	}
+}